@@ -0,0 +1,153 @@
+package http
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"github.com/go-kiss/sniper/pkg/twirp/reflection"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	catalogPath = "/rpc/services" // 服务目录地址
+	openAPIPath = "/rpc/openapi"  // OpenAPI 文档地址
+	docsPath    = "/rpc/docs"     // 接口文档页面地址
+)
+
+// openAPIer 使用 openapi=true 参数生成的服务端实现了该接口
+type openAPIer interface {
+	OpenAPI() []byte
+}
+
+// initCatalog 注册服务目录和接口文档，列出 mux 中注册的全部 twirp 服务
+func initCatalog(mux *http.ServeMux) {
+	servers := registeredServers(mux)
+
+	h, err := reflection.NewHandler(servers...)
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle(catalogPath, h)
+
+	docs := openAPIDocs(servers)
+	mux.HandleFunc(openAPIPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		name := r.URL.Query().Get("service")
+		if name == "" {
+			json.NewEncoder(w).Encode(docList(docs))
+			return
+		}
+
+		doc, ok := docs[name]
+		if !ok {
+			http.Error(w, "service not found", http.StatusNotFound)
+			return
+		}
+		w.Write(doc)
+	})
+
+	// 页面会执行该地址下的脚本，不提供默认的 CDN 地址，需要明确配置固定版本或者自己部署
+	ui := conf.Get("RPC_DOCS_SWAGGER_UI")
+	if ui == "" {
+		return
+	}
+	mux.HandleFunc(docsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsTpl.Execute(w, map[string]any{"UI": ui, "URLs": docList(docs)})
+	})
+}
+
+// registeredServers 遍历已加载的 proto 服务，找出 mux 中注册的服务
+//
+// initMux 只会调用 mux.Handle 注册路由，无法直接拿到服务列表，
+// 所以用每个服务的路由前缀反查 mux 中的 handler。
+func registeredServers(mux *http.ServeMux) (servers []twirp.Server) {
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			prefix := "/" + string(services.Get(i).FullName()) + "/"
+			r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: prefix}}
+			h, pattern := mux.Handler(r)
+			if s, ok := h.(twirp.Server); ok && pattern == prefix {
+				servers = append(servers, s)
+			}
+		}
+		return true
+	})
+	return
+}
+
+// openAPIDocs 收集服务的 OpenAPI 文档，配置了 RPC_PREFIX 时写入 servers 字段
+func openAPIDocs(servers []twirp.Server) map[string][]byte {
+	docs := map[string][]byte{}
+	prefix := conf.Get("RPC_PREFIX")
+	for _, s := range servers {
+		o, ok := s.(openAPIer)
+		if !ok {
+			continue
+		}
+		sd, err := reflection.ServiceDescriptor(s)
+		if err != nil {
+			panic(err)
+		}
+
+		doc := o.OpenAPI()
+		if prefix != "" {
+			var m map[string]any
+			if err := json.Unmarshal(doc, &m); err != nil {
+				panic(err)
+			}
+			m["servers"] = []any{map[string]any{"url": prefix}}
+			if doc, err = json.Marshal(m); err != nil {
+				panic(err)
+			}
+		}
+		docs[string(sd.FullName())] = doc
+	}
+	return docs
+}
+
+type docURL struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// docList 返回文档列表，地址为相对地址，兼容 RPC_PREFIX
+func docList(docs map[string][]byte) []docURL {
+	list := make([]docURL, 0, len(docs))
+	for name := range docs {
+		list = append(list, docURL{Name: name, URL: "openapi?service=" + url.QueryEscape(name)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+var docsTpl = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API Docs</title>
+<link rel="stylesheet" href="{{.UI}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.UI}}/swagger-ui-bundle.js"></script>
+<script src="{{.UI}}/swagger-ui-standalone-preset.js"></script>
+<script>
+window.ui = SwaggerUIBundle({
+  urls: {{.URLs}},
+  dom_id: "#swagger-ui",
+  presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+  layout: "StandaloneLayout",
+});
+</script>
+</body>
+</html>
+`))
@@ -15,6 +15,7 @@ import (
 	"github.com/go-kiss/sniper/pkg"
 	"github.com/go-kiss/sniper/pkg/conf"
 	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/sqldb"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -130,6 +131,10 @@ func startServer() {
 
 	initMux(mux)
 
+	if conf.GetBool("RPC_CATALOG") {
+		initCatalog(mux)
+	}
+
 	var handler http.Handler
 
 	handler = panicHandler{handler: mux}
@@ -144,6 +149,7 @@ func startServer() {
 	http.HandleFunc("/monitor/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong"))
 	})
+	http.HandleFunc("/monitor/sqldb", sqldb.StatsHandler)
 
 	addr := fmt.Sprintf(":%d", port)
 	server = &http.Server{
@@ -0,0 +1,58 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-kiss/sniper/pkg/auth"
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+// Auth 认证和鉴权
+//
+// 通过方法注释 sniper:auth 声明策略：
+//   - sniper:auth 或 sniper:auth=any 要求认证通过
+//   - sniper:auth=admin|editor 要求拥有其中任意一个角色
+//   - sniper:auth=none 不要求认证
+//
+// 未声明策略的方法默认不要求认证，配置 AUTH_REQUIRED=true 后默认要求认证通过。
+// 认证通过的调用方可以使用 auth.FromContext 读取。
+var Auth = &twirp.ServerHooks{
+	RequestRouted: func(ctx context.Context) (context.Context, error) {
+		policy, required := twirp.MethodOptionValue(ctx, "auth")
+		if !required {
+			required = conf.GetBool("AUTH_REQUIRED")
+		}
+		if policy == "none" {
+			required = false
+		}
+
+		hreq, ok := twirp.HttpRequest(ctx)
+		if !ok {
+			return ctx, nil
+		}
+
+		p, err := auth.Authenticate(hreq)
+		if err != nil {
+			// 不要求认证的接口忽略凭证错误，按匿名请求处理
+			if !required {
+				return ctx, nil
+			}
+			if errors.Is(err, auth.ErrNoCredentials) {
+				return ctx, twirp.NewError(twirp.Unauthenticated, "credentials required")
+			}
+			return ctx, twirp.WrapError(twirp.NewError(twirp.Unauthenticated, err.Error()), err)
+		}
+
+		ctx = auth.WithPrincipal(ctx, p)
+
+		if required {
+			if err := auth.Authorize(p, policy); err != nil {
+				return ctx, twirp.WrapError(twirp.NewError(twirp.PermissionDenied, "permission denied"), err)
+			}
+		}
+
+		return ctx, nil
+	},
+}
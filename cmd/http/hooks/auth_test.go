@@ -0,0 +1,71 @@
+package hooks
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/auth"
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+func TestAuth(t *testing.T) {
+	setConf(t, "AUTH_API_KEYS", "bar:k1")
+	setConf(t, "AUTH_ROLES_bar", "ops")
+
+	cases := []struct {
+		name     string
+		option   string
+		required bool
+		key      string
+		code     twirp.ErrorCode
+		subject  string
+	}{
+		{"default anonymous", "", false, "", twirp.NoError, ""},
+		{"default with key", "", false, "k1", twirp.NoError, "bar"},
+		{"default ignores invalid key", "", false, "bad", twirp.NoError, ""},
+		{"required anonymous", "", true, "", twirp.Unauthenticated, ""},
+		{"required invalid key", "", true, "bad", twirp.Unauthenticated, ""},
+		{"required with key", "", true, "k1", twirp.NoError, "bar"},
+		{"none over required", "auth=none", true, "", twirp.NoError, ""},
+		{"none ignores invalid key", "auth=none", true, "bad", twirp.NoError, ""},
+		{"none with key", "auth=none", true, "k1", twirp.NoError, "bar"},
+		{"auth anonymous", "auth", false, "", twirp.Unauthenticated, ""},
+		{"auth any", "cache=1s,auth=any", false, "k1", twirp.NoError, "bar"},
+		{"role denied", "auth=admin", false, "k1", twirp.PermissionDenied, ""},
+		{"role allowed", "auth=admin|ops", false, "k1", twirp.NoError, "bar"},
+		{"role anonymous", "auth=admin", false, "", twirp.Unauthenticated, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setConf(t, "AUTH_REQUIRED", c.required)
+
+			r := httptest.NewRequest("POST", "/", nil)
+			if c.key != "" {
+				r.Header.Set("X-API-Key", c.key)
+			}
+
+			ctx, err := Auth.RequestRouted(testContext(c.option, r))
+
+			code := twirp.NoError
+			if err != nil {
+				twerr, ok := err.(twirp.Error)
+				if !ok {
+					t.Fatal("not a twirp error", err)
+				}
+				code = twerr.Code()
+			}
+			if code != c.code {
+				t.Fatalf("code = %q, want %q, err: %v", code, c.code, err)
+			}
+
+			if c.subject == "" {
+				return
+			}
+			p, ok := auth.FromContext(ctx)
+			if !ok || p.Subject != c.subject {
+				t.Fatal("invalid principal", p)
+			}
+		})
+	}
+}
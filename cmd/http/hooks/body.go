@@ -0,0 +1,88 @@
+package hooks
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+// defaultMaxBodySize 默认请求体大小上限
+const defaultMaxBodySize = 4 << 20
+
+// Body 限制请求体大小并解压请求
+//
+// 大小上限依次读取配置 RPC_MAX_BODY_SIZE_包名_服务名_方法名、方法注释
+// sniper:max_body=1mb 和配置 RPC_MAX_BODY_SIZE，默认 4mb，设为 0 不限制。
+// 上限同时作用于压缩和解压后的请求体，超过上限返回 resource_exhausted 错误，
+// HTTP 状态码为 413。
+//
+// 请求体按 Content-Encoding 解压，支持 gzip 和 zstd，需要放在 Auth 之前，
+// 保证签名校验和缓存读到的都是解压后的内容。
+//
+// 响应体超过 RPC_COMPRESS_MIN_SIZE（默认 1kb）时按 Accept-Encoding 压缩，
+// 方法注释 sniper:compress=false 可以关闭压缩。
+var Body = &twirp.ServerHooks{
+	RequestRouted: func(ctx context.Context) (context.Context, error) {
+		if n := maxBodySize(ctx); n > 0 {
+			ctx = twirp.WithMaxBodySize(ctx, n)
+		}
+
+		if v, ok := twirp.MethodOptionValue(ctx, "compress"); ok && v == "false" {
+			ctx = twirp.WithCompressMinSize(ctx, -1)
+		} else if v := conf.Get("RPC_COMPRESS_MIN_SIZE"); v != "" {
+			ctx = twirp.WithCompressMinSize(ctx, int(conf.GetSizeInBytes("RPC_COMPRESS_MIN_SIZE")))
+		}
+
+		hreq, ok := twirp.HttpRequest(ctx)
+		if !ok {
+			return ctx, nil
+		}
+		return ctx, twirp.DecodeRequestBody(ctx, hreq)
+	},
+}
+
+// maxBodySize 读取请求体大小上限，配置优先于方法注释
+func maxBodySize(ctx context.Context) int64 {
+	name := confName(ctx)
+	if v := conf.Get("RPC_MAX_BODY_SIZE_" + name); v != "" {
+		return int64(conf.GetSizeInBytes("RPC_MAX_BODY_SIZE_" + name))
+	}
+
+	if v, ok := twirp.MethodOptionValue(ctx, "max_body"); ok {
+		n, err := parseSize(v)
+		if err == nil {
+			return n
+		}
+		log.Get(ctx).Warnf("[body] invalid max_body option of %s: %s", fullMethod(ctx), v)
+	}
+
+	if v := conf.Get("RPC_MAX_BODY_SIZE"); v != "" {
+		return int64(conf.GetSizeInBytes("RPC_MAX_BODY_SIZE"))
+	}
+	return defaultMaxBodySize
+}
+
+// parseSize 解析 512、64kb、1mb 这类大小，单位不区分大小写
+func parseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		n      int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}} {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = strings.TrimSpace(v), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,242 @@
+package hooks
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/cache"
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+type cacheKey struct{}
+
+type cacheEntry struct {
+	key string
+	ttl time.Duration
+}
+
+var (
+	lruOnce sync.Once
+	lru     *cache.LRU[[]byte]
+)
+
+// Cache 缓存接口响应
+//
+// 通过方法注释 sniper:cache=10s 或者配置 RPC_CACHE_包名_服务名_方法名 开启，
+// 配置优先，设置为 0 可以关闭。缓存 key 由方法名和解码后的请求计算，
+// 不区分用户，只能用于返回结果与调用方无关的接口。
+//
+// 配置 RPC_CACHE_MEMDB 时使用对应的 memdb 实例，否则使用进程内 LRU 缓存，
+// 容量通过 RPC_CACHE_LOCAL_SIZE 配置，默认为 10000。
+var Cache = &twirp.ServerHooks{
+	RequestRouted: func(ctx context.Context) (context.Context, error) {
+		ttl := cacheTTL(ctx)
+		if ttl <= 0 {
+			return ctx, nil
+		}
+
+		method := fullMethod(ctx)
+		md := methodDescriptor(ctx)
+		if md == nil {
+			return ctx, nil
+		}
+
+		key, err := requestKey(ctx, md)
+		if err != nil {
+			// 请求无法解析，交给业务处理函数返回错误
+			return ctx, nil
+		}
+		key = "rpc:cache:" + method + ":" + key
+
+		if b, ok := cacheGet(ctx, key); ok {
+			mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+			if err == nil {
+				resp := mt.New().Interface()
+				if err := proto.Unmarshal(b, resp); err == nil {
+					rpcCacheRequests.WithLabelValues(method, "hit").Inc()
+					return twirp.WithResponse(ctx, resp), nil
+				}
+			}
+		}
+
+		rpcCacheRequests.WithLabelValues(method, "miss").Inc()
+		return context.WithValue(ctx, cacheKey{}, cacheEntry{key: key, ttl: ttl}), nil
+	},
+	ResponsePrepared: func(ctx context.Context) context.Context {
+		e, ok := ctx.Value(cacheKey{}).(cacheEntry)
+		if !ok {
+			return ctx
+		}
+
+		resp, ok := twirp.Response(ctx)
+		if !ok {
+			return ctx
+		}
+
+		b, err := proto.Marshal(resp)
+		if err != nil {
+			log.Get(ctx).Warnf("[cache] marshal %s response error: %v", e.key, err)
+			return ctx
+		}
+
+		cacheSet(ctx, e.key, b, e.ttl)
+		return ctx
+	},
+}
+
+// cacheTTL 读取缓存有效期，配置优先于方法注释
+func cacheTTL(ctx context.Context) time.Duration {
+	name := confName(ctx)
+	if v := conf.Get("RPC_CACHE_" + name); v != "" {
+		return conf.GetDuration("RPC_CACHE_" + name)
+	}
+
+	v, ok := twirp.MethodOptionValue(ctx, "cache")
+	if !ok {
+		return 0
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		log.Get(ctx).Warnf("[cache] invalid cache option of %s: %s", fullMethod(ctx), v)
+		return 0
+	}
+	return ttl
+}
+
+func methodDescriptor(ctx context.Context) protoreflect.MethodDescriptor {
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(pkg + "." + service))
+	if err != nil {
+		return nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	return sd.Methods().ByName(protoreflect.Name(method))
+}
+
+// requestKey 解码请求并计算摘要，json 和 protobuf 格式的相同请求得到相同的 key
+//
+// 请求体读取后会重新放回，不影响后续处理，压缩的请求体会先解压。
+func requestKey(ctx context.Context, md protoreflect.MethodDescriptor) (string, error) {
+	hreq, ok := twirp.HttpRequest(ctx)
+	if !ok {
+		return "", io.EOF
+	}
+
+	body, err := twirp.ReadRequestBody(ctx, hreq)
+	if err != nil {
+		return "", err
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return "", err
+	}
+	in := mt.New().Interface()
+
+	var data []byte
+	ct, _, _ := mime.ParseMediaType(hreq.Header.Get("Content-Type"))
+	switch ct {
+	case "application/json":
+		if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, in); err != nil {
+			return "", err
+		}
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	case "application/protobuf":
+		if err = proto.Unmarshal(body, in); err != nil {
+			return "", err
+		}
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	default:
+		// 表单参数由生成代码按字段解析，这里直接使用排序后的参数
+		form := hreq.URL.Query()
+		if ct == "application/x-www-form-urlencoded" {
+			values, err := url.ParseQuery(string(body))
+			if err != nil {
+				return "", err
+			}
+			for k, v := range values {
+				form[k] = append(form[k], v...)
+			}
+		}
+		data = []byte(form.Encode())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func cacheGet(ctx context.Context, key string) ([]byte, bool) {
+	if name := conf.Get("RPC_CACHE_MEMDB"); name != "" {
+		b, err := memdb.Get(name).Get(ctx, key).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				log.Get(ctx).Warnf("[cache] get %s error: %v", key, err)
+			}
+			return nil, false
+		}
+		return b, true
+	}
+
+	return localCache().Get(key)
+}
+
+func cacheSet(ctx context.Context, key string, b []byte, ttl time.Duration) {
+	if name := conf.Get("RPC_CACHE_MEMDB"); name != "" {
+		if err := memdb.Get(name).Set(ctx, key, b, ttl).Err(); err != nil {
+			log.Get(ctx).Warnf("[cache] set %s error: %v", key, err)
+		}
+		return
+	}
+
+	localCache().Set(key, b, ttl)
+}
+
+func localCache() *cache.LRU[[]byte] {
+	lruOnce.Do(func() {
+		size := conf.GetInt("RPC_CACHE_LOCAL_SIZE")
+		if size <= 0 {
+			size = 10000
+		}
+		lru = cache.NewLRU[[]byte](size, 24*time.Hour)
+	})
+	return lru
+}
+
+func fullMethod(ctx context.Context) string {
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+	return "/" + pkg + "." + service + "/" + method
+}
+
+// confName 返回方法对应的配置名后缀，格式为包名_服务名_方法名，包名中的 . 替换为 _
+func confName(ctx context.Context) string {
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+	return strings.ReplaceAll(pkg, ".", "_") + "_" + service + "_" + method
+}
@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestKey(t *testing.T) {
+	pb, _ := proto.Marshal(newEchoReq("foo", 1, 2))
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"name":"foo","ids":[1,2]}`))
+	w.Close()
+
+	newReq := func(method, target, ct, encoding string, body []byte) *http.Request {
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		if encoding != "" {
+			r.Header.Set("Content-Encoding", encoding)
+		}
+		return r
+	}
+	post := func(ct, body string) *http.Request {
+		return newReq("POST", "/", ct, "", []byte(body))
+	}
+
+	cases := []struct {
+		name string
+		a, b *http.Request
+		same bool
+	}{
+		{"json field order",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			post("application/json", `{"ids":[1,2],"name":"foo"}`), true},
+		{"json charset and whitespace",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			post("application/json; charset=utf-8", "{ \"name\": \"foo\",\n \"ids\": [\"1\", 2] }"), true},
+		{"json unknown field",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			post("application/json", `{"name":"foo","ids":[1,2],"bar":true}`), true},
+		{"json and protobuf",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			newReq("POST", "/", "application/protobuf", "", pb), true},
+		{"gzip json",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			newReq("POST", "/", "application/json", "gzip", gz.Bytes()), true},
+		{"json different value",
+			post("application/json", `{"name":"foo","ids":[1,2]}`),
+			post("application/json", `{"name":"foo","ids":[2,1]}`), false},
+		{"form body and query",
+			post("application/x-www-form-urlencoded", "name=foo&ids=1&ids=2"),
+			newReq("GET", "/?ids=1&ids=2&name=foo", "", "", nil), true},
+		{"form different value",
+			post("application/x-www-form-urlencoded", "name=foo"),
+			post("application/x-www-form-urlencoded", "name=bar"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			md := methodDescriptor(testContext("", nil))
+			if md == nil {
+				t.Fatal("method not found")
+			}
+
+			ka, err := requestKey(testContext("", c.a), md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kb, err := requestKey(testContext("", c.b), md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ka == kb) != c.same {
+				t.Fatalf("same key = %v, want %v", ka == kb, c.same)
+			}
+		})
+	}
+}
+
+func TestRequestKeyError(t *testing.T) {
+	md := methodDescriptor(testContext("", nil))
+
+	cases := []struct {
+		name string
+		r    *http.Request
+	}{
+		{"invalid json", httptest.NewRequest("POST", "/", bytes.NewReader([]byte("{")))},
+		{"invalid protobuf", httptest.NewRequest("POST", "/", bytes.NewReader([]byte{0xff}))},
+	}
+	cases[0].r.Header.Set("Content-Type", "application/json")
+	cases[1].r.Header.Set("Content-Type", "application/protobuf")
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := requestKey(testContext("", c.r), md); err == nil {
+				t.Fatal("should fail")
+			}
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cases := []struct {
+		name   string
+		option string
+		conf   string
+		ttl    time.Duration
+	}{
+		{"no option", "", "", 0},
+		{"option", "cache=10s", "", 10 * time.Second},
+		{"option with others", "auth,cache=1m,limit=10", "", time.Minute},
+		{"invalid option", "cache=foo", "", 0},
+		{"conf", "", "30s", 30 * time.Second},
+		{"conf over option", "cache=10s", "1m", time.Minute},
+		{"conf disables option", "cache=10s", "0", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setConf(t, "RPC_CACHE_hooks_test_Foo_Echo", c.conf)
+			if ttl := cacheTTL(testContext(c.option, nil)); ttl != c.ttl {
+				t.Fatalf("ttl = %v, want %v", ttl, c.ttl)
+			}
+		})
+	}
+}
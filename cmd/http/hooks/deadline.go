@@ -0,0 +1,77 @@
+package hooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+type deadlineKey struct{}
+
+// Deadline 设置请求超时时间
+//
+// 客户端通过 Twirp-Timeout 请求头传递剩余时间，生成的客户端会根据 ctx 自动设置。
+// 服务端默认超时时间依次读取配置 RPC_TIMEOUT_包名_服务名_方法名、方法注释
+// sniper:timeout=2s 和配置 RPC_TIMEOUT，与客户端剩余时间取较小值。
+// 流式响应的方法不使用 RPC_TIMEOUT，只能单独设置。
+//
+// 客户端剩余时间已经用完的请求直接返回 deadline_exceeded 错误，不再执行业务逻辑。
+var Deadline = &twirp.ServerHooks{
+	RequestRouted: func(ctx context.Context) (context.Context, error) {
+		timeout := methodTimeout(ctx)
+
+		if hreq, ok := twirp.HttpRequest(ctx); ok {
+			if v := hreq.Header.Get(twirp.TimeoutHeader); v != "" {
+				budget, err := twirp.ParseTimeout(v)
+				if err != nil {
+					return ctx, twirp.NewError(twirp.InvalidArgument, err.Error())
+				}
+				if budget <= 0 {
+					return ctx, twirp.NewError(twirp.DeadlineExceeded, "request budget exhausted")
+				}
+				if timeout <= 0 || budget < timeout {
+					timeout = budget
+				}
+			}
+		}
+
+		if timeout <= 0 {
+			return ctx, nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return context.WithValue(ctx, deadlineKey{}, cancel), nil
+	},
+	ResponseSent: func(ctx context.Context) {
+		// 排在后面的 hook 在 ResponseSent 中读到的 ctx.Err() 为 context.Canceled
+		if cancel, ok := ctx.Value(deadlineKey{}).(context.CancelFunc); ok {
+			cancel()
+		}
+	},
+}
+
+// methodTimeout 读取方法的默认超时时间，配置优先于方法注释
+func methodTimeout(ctx context.Context) time.Duration {
+	name := confName(ctx)
+	if v := conf.Get("RPC_TIMEOUT_" + name); v != "" {
+		return conf.GetDuration("RPC_TIMEOUT_" + name)
+	}
+
+	if v, ok := twirp.MethodOptionValue(ctx, "timeout"); ok {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+		log.Get(ctx).Warnf("[deadline] invalid timeout option of %s: %s", fullMethod(ctx), v)
+	}
+
+	// 流的持续时间往往很长，统一的超时时间会中断正常的流
+	if md := methodDescriptor(ctx); md != nil && md.IsStreamingServer() {
+		return 0
+	}
+
+	return conf.GetDuration("RPC_TIMEOUT")
+}
@@ -0,0 +1,40 @@
+package hooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+func TestMethodTimeout(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		option  string
+		conf    string
+		global  string
+		timeout time.Duration
+	}{
+		{"none", "Echo", "", "", "", 0},
+		{"global", "Echo", "", "", "5s", 5 * time.Second},
+		{"option over global", "Echo", "timeout=2s", "", "5s", 2 * time.Second},
+		{"conf over option", "Echo", "timeout=2s", "500ms", "5s", 500 * time.Millisecond},
+		{"invalid option", "Echo", "timeout=foo", "", "5s", 5 * time.Second},
+		{"streaming ignores global", "Watch", "", "", "5s", 0},
+		{"streaming option", "Watch", "timeout=1m", "", "5s", time.Minute},
+		{"streaming conf", "Watch", "", "10m", "5s", 10 * time.Minute},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setConf(t, "RPC_TIMEOUT", c.global)
+			setConf(t, "RPC_TIMEOUT_hooks_test_Foo_"+c.method, c.conf)
+
+			ctx := twirp.WithMethodName(testContext(c.option, nil), c.method)
+			if d := methodTimeout(ctx); d != c.timeout {
+				t.Fatalf("timeout = %v, want %v", d, c.timeout)
+			}
+		})
+	}
+}
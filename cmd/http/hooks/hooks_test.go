@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// 注册测试用的服务 hooks.test.Foo，方法 Echo 的请求包含 name 和 ids 两个字段，
+// Watch 为流式响应方法
+func init() {
+	field := func(name string, n int32, typ descriptorpb.FieldDescriptorProto_Type,
+		label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(n),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+	}
+
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("hooks_test.proto"),
+		Package: proto.String("hooks.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("EchoReq"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING,
+					descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL),
+				field("ids", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64,
+					descriptorpb.FieldDescriptorProto_LABEL_REPEATED),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Foo"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Echo"),
+				InputType:  proto.String(".hooks.test.EchoReq"),
+				OutputType: proto.String(".hooks.test.EchoReq"),
+			}, {
+				Name:            proto.String("Watch"),
+				InputType:       proto.String(".hooks.test.EchoReq"),
+				OutputType:      proto.String(".hooks.test.EchoReq"),
+				ServerStreaming: proto.Bool(true),
+			}},
+		}},
+	}
+
+	f, err := protodesc.NewFile(fd, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(f); err != nil {
+		panic(err)
+	}
+	mt := dynamicpb.NewMessageType(f.Messages().Get(0))
+	if err := protoregistry.GlobalTypes.RegisterMessage(mt); err != nil {
+		panic(err)
+	}
+}
+
+// newEchoReq 创建测试方法的请求
+func newEchoReq(name string, ids ...int64) proto.Message {
+	mt, _ := protoregistry.GlobalTypes.FindMessageByName("hooks.test.EchoReq")
+	m := mt.New()
+	fields := m.Descriptor().Fields()
+	m.Set(fields.ByName("name"), protoreflect.ValueOfString(name))
+	list := m.Mutable(fields.ByName("ids")).List()
+	for _, id := range ids {
+		list.Append(protoreflect.ValueOfInt64(id))
+	}
+	return m.Interface()
+}
+
+// testContext 返回路由到 hooks.test.Foo/Echo 的 ctx，option 为方法注释
+func testContext(option string, r *http.Request) context.Context {
+	ctx := context.Background()
+	ctx = twirp.WithPackageName(ctx, "hooks.test")
+	ctx = twirp.WithServiceName(ctx, "Foo")
+	ctx = twirp.WithMethodName(ctx, "Echo")
+	ctx = twirp.WithMethodOption(ctx, option)
+	if r != nil {
+		ctx = twirp.WithHttpRequest(ctx, r)
+	}
+	return ctx
+}
+
+// setConf 修改配置，测试结束后恢复
+func setConf(t *testing.T, key string, value any) {
+	old := conf.Get(key)
+	conf.Set(key, value)
+	t.Cleanup(func() { conf.Set(key, old) })
+}
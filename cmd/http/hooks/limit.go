@@ -0,0 +1,286 @@
+package hooks
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kiss/sniper/pkg/auth"
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/limit"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/go-kiss/sniper/pkg/twirp"
+)
+
+type limitKey struct{}
+
+type rateRule struct {
+	rate  float64
+	burst int
+	by    string
+}
+
+type rateLimiter struct {
+	rule rateRule
+	db   string
+	l    limit.Limiter
+}
+
+var (
+	rateLimiters     sync.Map // method -> *rateLimiter
+	adaptiveLimiters sync.Map // method -> *limit.Adaptive
+)
+
+// Limit 限流和过载保护
+//
+// 通过方法注释 sniper:limit=100 开启限流，单位为每秒请求数，
+// limit_burst 设置允许的突发请求数，默认与 limit 相同，
+// limit_by 设置限流维度：
+//   - 不设置时整个方法共享额度
+//   - ip 按客户端 IP 限流
+//   - apikey 按请求头 X-API-Key 限流
+//   - principal 按认证通过的调用方限流，需要在 Auth 之后执行
+//
+// 也可以通过 RPC_LIMIT_包名_服务名_方法名、RPC_LIMIT_BURST_xxx 和 RPC_LIMIT_BY_xxx 配置，
+// 配置优先，设置为 0 可以关闭。默认在进程内限流，配置 RPC_LIMIT_MEMDB 后使用对应的
+// memdb 实例在多个实例之间共享额度，memdb 出错时放行。超过限制返回 resource_exhausted 错误。
+//
+// 配置 RPC_CONCURRENCY_ADAPTIVE=true 开启自适应并发限制，每个方法根据耗时变化
+// 自动调整并发上限，范围由 RPC_CONCURRENCY_MIN 和 RPC_CONCURRENCY_MAX 配置，
+// 默认为 20 和 1000，流式方法不受限制。超过上限返回 unavailable 错误。
+var Limit = &twirp.ServerHooks{
+	RequestRouted: func(ctx context.Context) (context.Context, error) {
+		method := fullMethod(ctx)
+
+		if rl := rateLimiterOf(ctx, method); rl != nil {
+			ok, err := rl.l.Allow(ctx, clientKey(ctx, rl.rule.by))
+			if err != nil {
+				log.Get(ctx).Warnf("[limit] %s allow error: %v", method, err)
+			} else if !ok {
+				rpcLimitRejected.WithLabelValues(method, "rate").Inc()
+				return ctx, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded")
+			}
+		}
+
+		if !conf.GetBool("RPC_CONCURRENCY_ADAPTIVE") {
+			return ctx, nil
+		}
+
+		// 流式响应的耗时取决于消息数量，不参与并发限制
+		if md := methodDescriptor(ctx); md != nil && md.IsStreamingServer() {
+			return ctx, nil
+		}
+
+		a := adaptiveLimiterOf(method)
+		release, ok := a.Acquire()
+		if !ok {
+			rpcLimitRejected.WithLabelValues(method, "concurrency").Inc()
+			return ctx, twirp.NewError(twirp.Unavailable, "server overloaded")
+		}
+		rpcConcurrencyLimit.WithLabelValues(method).Set(float64(a.Limit()))
+		rpcConcurrencyInflight.WithLabelValues(method).Set(float64(a.Inflight()))
+
+		return context.WithValue(ctx, limitKey{}, release), nil
+	},
+	ResponseSent: func(ctx context.Context) {
+		release, ok := ctx.Value(limitKey{}).(func(bool))
+		if !ok {
+			return
+		}
+
+		// 超时或者下游不可用说明已经过载，需要快速降低并发上限
+		status, _ := twirp.StatusCode(ctx)
+		dropped := status == "503" || status == "504" ||
+			errors.Is(ctx.Err(), context.DeadlineExceeded)
+		release(dropped)
+
+		method := fullMethod(ctx)
+		a := adaptiveLimiterOf(method)
+		rpcConcurrencyLimit.WithLabelValues(method).Set(float64(a.Limit()))
+		rpcConcurrencyInflight.WithLabelValues(method).Set(float64(a.Inflight()))
+	},
+}
+
+// rateRuleOf 读取限流规则，配置优先于方法注释
+func rateRuleOf(ctx context.Context) (r rateRule) {
+	name := confName(ctx)
+
+	if v := conf.Get("RPC_LIMIT_" + name); v != "" {
+		r.rate = conf.GetFloat64("RPC_LIMIT_" + name)
+	} else if v, ok := twirp.MethodOptionValue(ctx, "limit"); ok {
+		r.rate, _ = strconv.ParseFloat(v, 64)
+	}
+	if r.rate <= 0 {
+		return rateRule{}
+	}
+
+	if v := conf.Get("RPC_LIMIT_BURST_" + name); v != "" {
+		r.burst = conf.GetInt("RPC_LIMIT_BURST_" + name)
+	} else if v, ok := twirp.MethodOptionValue(ctx, "limit_burst"); ok {
+		r.burst, _ = strconv.Atoi(v)
+	}
+	if r.burst <= 0 {
+		r.burst = int(r.rate)
+	}
+	if r.burst < 1 {
+		r.burst = 1
+	}
+
+	r.by = conf.Get("RPC_LIMIT_BY_" + name)
+	if r.by == "" {
+		r.by, _ = twirp.MethodOptionValue(ctx, "limit_by")
+	}
+
+	return r
+}
+
+// rateLimiterOf 返回方法的限流器，配置变化后重新创建
+func rateLimiterOf(ctx context.Context, method string) *rateLimiter {
+	rule := rateRuleOf(ctx)
+	if rule.rate <= 0 {
+		return nil
+	}
+
+	db := conf.Get("RPC_LIMIT_MEMDB")
+	if v, ok := rateLimiters.Load(method); ok {
+		if rl := v.(*rateLimiter); rl.rule == rule && rl.db == db {
+			return rl
+		}
+	}
+
+	rl := &rateLimiter{rule: rule, db: db}
+	if db != "" {
+		rl.l = memdb.NewTokenBucket(memdb.Get(db), "rpc:"+method, rule.rate, rule.burst)
+	} else {
+		rl.l = limit.NewTokenBucket(rule.rate, rule.burst)
+	}
+	rateLimiters.Store(method, rl)
+
+	return rl
+}
+
+func adaptiveLimiterOf(method string) *limit.Adaptive {
+	if v, ok := adaptiveLimiters.Load(method); ok {
+		return v.(*limit.Adaptive)
+	}
+
+	min := conf.GetInt("RPC_CONCURRENCY_MIN")
+	if min <= 0 {
+		min = 20
+	}
+	max := conf.GetInt("RPC_CONCURRENCY_MAX")
+	if max <= 0 {
+		max = 1000
+	}
+
+	v, _ := adaptiveLimiters.LoadOrStore(method, limit.NewAdaptive(min, max))
+	return v.(*limit.Adaptive)
+}
+
+// clientKey 返回限流维度对应的调用方标识，无法识别时使用客户端 IP
+func clientKey(ctx context.Context, by string) string {
+	hreq, ok := twirp.HttpRequest(ctx)
+	if !ok {
+		return ""
+	}
+
+	switch by {
+	case "":
+		return ""
+	case "apikey":
+		// 不在 memdb 中保存明文密钥
+		if k := hreq.Header.Get("X-API-Key"); k != "" {
+			sum := sha1.Sum([]byte(k))
+			return "apikey:" + hex.EncodeToString(sum[:])
+		}
+	case "principal":
+		if p, ok := auth.FromContext(ctx); ok {
+			return p.Type + ":" + p.Subject
+		}
+	}
+
+	return "ip:" + clientIP(ctx)
+}
+
+// clientIP 返回客户端 IP，配置 RPC_LIMIT_TRUST_PROXY=true 时读取 X-Forwarded-For
+//
+// 只有部署在可信的反向代理之后才能开启。客户端可以伪造 X-Forwarded-For 中靠左的部分，
+// 只有代理追加的最右边的地址可信，所以默认使用最右边的地址。
+// 多层代理可以通过 RPC_LIMIT_TRUSTED_PROXIES 配置代理的 IP 或者网段，多个用逗号分隔，
+// 此时从右往左跳过代理地址，使用第一个不是代理的地址，直连的地址不是代理时不读取请求头。
+func clientIP(ctx context.Context) string {
+	hreq, _ := twirp.HttpRequest(ctx)
+
+	remote, _, err := net.SplitHostPort(hreq.RemoteAddr)
+	if err != nil {
+		remote = hreq.RemoteAddr
+	}
+
+	if !conf.GetBool("RPC_LIMIT_TRUST_PROXY") {
+		return remote
+	}
+
+	var ips []string
+	for _, v := range hreq.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return remote
+	}
+
+	proxies := trustedProxies()
+	if len(proxies) == 0 {
+		return ips[len(ips)-1]
+	}
+	if !isTrusted(proxies, remote) {
+		return remote
+	}
+	for i := len(ips) - 1; i > 0; i-- {
+		if !isTrusted(proxies, ips[i]) {
+			return ips[i]
+		}
+	}
+	return ips[0]
+}
+
+// trustedProxies 解析 RPC_LIMIT_TRUSTED_PROXIES 配置，忽略无法解析的项
+func trustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(conf.Get("RPC_LIMIT_TRUSTED_PROXIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(v); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if a, err := netip.ParseAddr(v); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+		}
+	}
+	return prefixes
+}
+
+func isTrusted(proxies []netip.Prefix, ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range proxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package hooks
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/auth"
+)
+
+func TestRateRuleOf(t *testing.T) {
+	cases := []struct {
+		name   string
+		option string
+		conf   map[string]string
+		rule   rateRule
+	}{
+		{"no option", "", nil, rateRule{}},
+		{"option", "limit=10", nil, rateRule{rate: 10, burst: 10}},
+		{"option with burst and by", "limit=10,limit_burst=20,limit_by=ip", nil,
+			rateRule{rate: 10, burst: 20, by: "ip"}},
+		{"fractional rate", "limit=0.5", nil, rateRule{rate: 0.5, burst: 1}},
+		{"invalid rate", "limit=foo", nil, rateRule{}},
+		{"conf", "", map[string]string{"RPC_LIMIT_": "5"}, rateRule{rate: 5, burst: 5}},
+		{"conf over option", "limit=10,limit_burst=20,limit_by=ip", map[string]string{
+			"RPC_LIMIT_":       "5",
+			"RPC_LIMIT_BURST_": "8",
+			"RPC_LIMIT_BY_":    "apikey",
+		}, rateRule{rate: 5, burst: 8, by: "apikey"}},
+		{"conf disables option", "limit=10", map[string]string{"RPC_LIMIT_": "0"}, rateRule{}},
+		{"conf burst only", "limit=10,limit_burst=20", map[string]string{"RPC_LIMIT_BURST_": "30"},
+			rateRule{rate: 10, burst: 30}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, k := range []string{"RPC_LIMIT_", "RPC_LIMIT_BURST_", "RPC_LIMIT_BY_"} {
+				setConf(t, k+"hooks_test_Foo_Echo", c.conf[k])
+			}
+			if r := rateRuleOf(testContext(c.option, nil)); r != c.rule {
+				t.Fatalf("rule = %+v, want %+v", r, c.rule)
+			}
+		})
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	sum := sha1.Sum([]byte("k1"))
+	apikey := "apikey:" + hex.EncodeToString(sum[:])
+
+	cases := []struct {
+		name      string
+		by        string
+		key       string
+		principal *auth.Principal
+		want      string
+	}{
+		{"method", "", "k1", nil, ""},
+		{"ip", "ip", "k1", nil, "ip:192.0.2.1"},
+		{"apikey", "apikey", "k1", nil, apikey},
+		{"apikey fallback", "apikey", "", nil, "ip:192.0.2.1"},
+		{"principal", "principal", "", &auth.Principal{Type: auth.TypeJWT, Subject: "u1"}, auth.TypeJWT + ":u1"},
+		{"principal fallback", "principal", "k1", nil, "ip:192.0.2.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			if c.key != "" {
+				r.Header.Set("X-API-Key", c.key)
+			}
+			ctx := testContext("", r)
+			if c.principal != nil {
+				ctx = auth.WithPrincipal(ctx, c.principal)
+			}
+
+			if k := clientKey(ctx, c.by); k != c.want {
+				t.Fatalf("key = %q, want %q", k, c.want)
+			}
+		})
+	}
+
+	if k := clientKey(context.Background(), "ip"); k != "" {
+		t.Fatal("key without request should be empty", k)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name    string
+		trust   bool
+		proxies string
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"remote", false, "", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"ignore xff", false, "", "192.0.2.1:1234", []string{"1.1.1.1"}, "192.0.2.1"},
+		{"no xff", true, "", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"rightmost", true, "", "10.0.0.1:1234", []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{"rightmost of headers", true, "", "10.0.0.1:1234", []string{"6.6.6.6", "1.1.1.1"}, "1.1.1.1"},
+		{"skip proxies", true, "10.0.0.0/8,192.168.1.1", "10.0.0.1:1234",
+			[]string{"6.6.6.6, 1.1.1.1, 192.168.1.1, 10.1.2.3"}, "1.1.1.1"},
+		{"all proxies", true, "10.0.0.0/8", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"untrusted remote", true, "10.0.0.0/8", "192.0.2.1:1234", []string{"1.1.1.1"}, "192.0.2.1"},
+		{"ipv6 proxy", true, "fd00::/8", "[fd00::1]:1234", []string{"2001:db8::1, fd00::2"}, "2001:db8::1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setConf(t, "RPC_LIMIT_TRUST_PROXY", c.trust)
+			setConf(t, "RPC_LIMIT_TRUSTED_PROXIES", c.proxies)
+
+			r := httptest.NewRequest("POST", "/", nil)
+			r.RemoteAddr = c.remote
+			for _, v := range c.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if ip := clientIP(testContext("", r)); ip != c.want {
+				t.Fatalf("ip = %q, want %q", ip, c.want)
+			}
+		})
+	}
+}
@@ -13,6 +13,38 @@ var rpcDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Buckets:   defBuckets,
 }, []string{"path", "code"})
 
+var rpcCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "rpc",
+	Name:      "cache_requests_total",
+	Help:      "RPC response cache requests by result",
+}, []string{"method", "result"})
+
+var rpcLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "rpc",
+	Name:      "limit_rejected_total",
+	Help:      "RPC requests rejected by limiter",
+}, []string{"method", "reason"})
+
+var rpcConcurrencyLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "sniper",
+	Subsystem: "rpc",
+	Name:      "concurrency_limit",
+	Help:      "RPC adaptive concurrency limit",
+}, []string{"method"})
+
+var rpcConcurrencyInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "sniper",
+	Subsystem: "rpc",
+	Name:      "concurrency_inflight",
+	Help:      "RPC requests in flight",
+}, []string{"method"})
+
 func init() {
 	prometheus.MustRegister(rpcDurations)
+	prometheus.MustRegister(rpcCacheRequests)
+	prometheus.MustRegister(rpcLimitRejected)
+	prometheus.MustRegister(rpcConcurrencyLimit)
+	prometheus.MustRegister(rpcConcurrencyInflight)
 }
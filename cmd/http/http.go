@@ -8,7 +8,7 @@ import (
 	"github.com/go-kiss/sniper/pkg/twirp"
 )
 
-var commonHooks = twirp.ChainHooks(hooks.TraceID, hooks.Log)
+var commonHooks = twirp.ChainHooks(hooks.TraceID, hooks.Log, hooks.Deadline, hooks.Body, hooks.Auth, hooks.Limit, hooks.Cache)
 
 func initMux(mux *http.ServeMux) {
 }
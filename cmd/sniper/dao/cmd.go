@@ -0,0 +1,55 @@
+package dao
+
+import (
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	name, dsn, tables string
+)
+
+func init() {
+	Cmd.Flags().StringVar(&name, "name", "", "数据库配置名，同时也是 dao 包名")
+	Cmd.Flags().StringVar(&dsn, "dsn", "", "数据库连接串，支持 sqlite 和 mysql")
+	Cmd.Flags().StringVar(&tables, "tables", "", "只生成指定的表，多个表用英文逗号分割")
+
+	Cmd.MarkFlagRequired("name")
+	Cmd.MarkFlagRequired("dsn")
+}
+
+// Cmd 数据访问层生成工具
+var Cmd = &cobra.Command{
+	Use:   "dao",
+	Short: "生成 dao 模型代码",
+	Long: `脚手架功能：
+- 读取数据库表结构
+- 生成 dao/<name>/db.go
+- 生成 dao/<name>/<table>.go 模型及 CRUD 方法
+- 重复执行时只更新生成的代码，保留手写代码`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !isSniperDir() {
+			color.Red("只能在 sniper 项目根目录运行!")
+			os.Exit(1)
+		}
+
+		var only []string
+		if tables != "" {
+			only = strings.Split(tables, ",")
+		}
+
+		ts, err := loadTables(dsn, only)
+		if err != nil {
+			color.Red("读取表结构失败: %v", err)
+			os.Exit(1)
+		}
+
+		genDB()
+		for _, t := range ts {
+			genOrUpdateModel(t)
+		}
+	},
+}
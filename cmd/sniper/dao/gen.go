@@ -0,0 +1,209 @@
+package dao
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/imports"
+)
+
+type tpl interface {
+	tpl() string
+}
+
+func pkgName() string {
+	return strings.ToLower(camel(name))
+}
+
+func genDB() {
+	path := fmt.Sprintf("dao/%s/db.go", pkgName())
+	if fileExists(path) {
+		return
+	}
+
+	t := &dbTpl{Package: pkgName(), Name: name}
+	save(path, render(t))
+}
+
+func genOrUpdateModel(t table) {
+	mt := &modelTpl{
+		Package: pkgName(),
+		Table:   t.Name,
+		Model:   model(t.Name),
+	}
+	for _, c := range t.Columns {
+		f := field{Name: camel(c.Name), Type: goType(c), Column: c.Name}
+		mt.Fields = append(mt.Fields, f)
+	}
+	if k := t.key(); k != nil {
+		mt.Key = &field{Name: camel(k.Name), Type: goType(*k), Column: k.Name}
+	}
+
+	path := fmt.Sprintf("dao/%s/%s.go", pkgName(), strings.ToLower(t.Name))
+	code := render(mt)
+
+	if fileExists(path) {
+		old, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		code = merge(old, code)
+	}
+
+	save(path, code)
+}
+
+// merge 将新生成的代码合并到已有代码中
+//
+// 同名的类型和函数会被替换成最新生成的版本，手写的其他代码保持不变。
+func merge(old, gen []byte) []byte {
+	oldAst, err := decorator.Parse(old)
+	if err != nil {
+		panic(err)
+	}
+	genAst, err := decorator.Parse(gen)
+	if err != nil {
+		panic(err)
+	}
+
+	genDecls := map[string]dst.Decl{}
+	var order []string
+	for _, d := range genAst.Decls {
+		k := declKey(d)
+		if k == "" {
+			continue
+		}
+		genDecls[k] = d
+		order = append(order, k)
+	}
+
+	replaced := map[string]bool{}
+	for i, d := range oldAst.Decls {
+		k := declKey(d)
+		if nd, ok := genDecls[k]; ok {
+			oldAst.Decls[i] = nd
+			replaced[k] = true
+		}
+	}
+
+	for _, k := range order {
+		if !replaced[k] {
+			oldAst.Decls = append(oldAst.Decls, genDecls[k])
+		}
+	}
+
+	mergeImports(oldAst, genAst)
+
+	buf := &bytes.Buffer{}
+	if err := decorator.Fprint(buf, oldAst); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// declKey 返回类型或者函数的唯一标识，方法需要带上接收者类型
+func declKey(d dst.Decl) string {
+	switch v := d.(type) {
+	case *dst.GenDecl:
+		if v.Tok != token.TYPE || len(v.Specs) != 1 {
+			return ""
+		}
+		return "type " + v.Specs[0].(*dst.TypeSpec).Name.Name
+	case *dst.FuncDecl:
+		if v.Recv == nil || len(v.Recv.List) == 0 {
+			return "func " + v.Name.Name
+		}
+		recv := v.Recv.List[0].Type
+		if se, ok := recv.(*dst.StarExpr); ok {
+			recv = se.X
+		}
+		if id, ok := recv.(*dst.Ident); ok {
+			return "func " + id.Name + "." + v.Name.Name
+		}
+	}
+	return ""
+}
+
+// mergeImports 把生成代码的导入追加到已有代码中，多余的导入最后由 imports 清理
+func mergeImports(dstAst, srcAst *dst.File) {
+	var target *dst.GenDecl
+	paths := map[string]bool{}
+	for _, d := range dstAst.Decls {
+		gd, ok := d.(*dst.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		if target == nil {
+			target = gd
+		}
+		for _, s := range gd.Specs {
+			paths[s.(*dst.ImportSpec).Path.Value] = true
+		}
+	}
+
+	if target == nil {
+		target = &dst.GenDecl{Tok: token.IMPORT, Lparen: true, Rparen: true}
+		dstAst.Decls = append([]dst.Decl{target}, dstAst.Decls...)
+	}
+
+	for _, d := range srcAst.Decls {
+		gd, ok := d.(*dst.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		for _, s := range gd.Specs {
+			is := s.(*dst.ImportSpec)
+			if paths[is.Path.Value] {
+				continue
+			}
+			paths[is.Path.Value] = true
+			target.Specs = append(target.Specs, dst.Clone(is).(*dst.ImportSpec))
+		}
+	}
+	target.Lparen = len(target.Specs) > 1
+	target.Rparen = target.Lparen
+}
+
+func render(t tpl) []byte {
+	tmpl, err := template.New("sniper").Funcs(template.FuncMap{
+		"lower1st": lower1st,
+	}).Parse(t.tpl())
+	if err != nil {
+		panic(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, t); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func save(path string, code []byte) {
+	// 删除未使用的导入并格式化代码
+	code, err := imports.Process(path, code, &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: false,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(path, code, 0644); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,150 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite" // 注册 sqlite 驱动
+)
+
+type table struct {
+	Name    string
+	Columns []column
+}
+
+type column struct {
+	Name     string
+	Type     string // 数据库中的原始类型，如 varchar(30)
+	Nullable bool
+	Primary  bool
+}
+
+// key 返回主键字段，没有主键或者联合主键返回 nil
+func (t *table) key() *column {
+	var k *column
+	for i := range t.Columns {
+		if !t.Columns[i].Primary {
+			continue
+		}
+		if k != nil {
+			return nil
+		}
+		k = &t.Columns[i]
+	}
+	return k
+}
+
+// 识别规则与 sqldb.Get 保持一致，另外支持直接传 sqlite 文件路径
+func isSqlite(dsn string) bool {
+	if strings.HasPrefix(dsn, "file:") || dsn == ":memory:" {
+		return true
+	}
+	_, err := os.Stat(dsn)
+	return err == nil
+}
+
+func loadTables(dsn string, only []string) ([]table, error) {
+	var driver string
+	var load func(*sql.DB, string) ([]column, error)
+	var list string
+	if isSqlite(dsn) {
+		driver, load = "sqlite", sqliteColumns
+		list = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	} else {
+		// 文件路径不是合法的 mysql dsn，多半是 sqlite 文件写错了路径
+		if _, err := mysql.ParseDSN(dsn); err != nil {
+			return nil, fmt.Errorf("sqlite 文件 %s 不存在，也不是合法的 mysql dsn: %w", dsn, err)
+		}
+		driver, load = "mysql", mysqlColumns
+		list = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	names := only
+	if len(names) == 0 {
+		rows, err := db.Query(list)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var n string
+			if err := rows.Scan(&n); err != nil {
+				return nil, err
+			}
+			names = append(names, n)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	ts := make([]table, 0, len(names))
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		cs, err := load(db, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(cs) == 0 {
+			return nil, fmt.Errorf("表 %s 不存在", n)
+		}
+		ts = append(ts, table{Name: n, Columns: cs})
+	}
+	return ts, nil
+}
+
+func sqliteColumns(db *sql.DB, name string) ([]column, error) {
+	rows, err := db.Query("SELECT name, type, `notnull`, pk FROM pragma_table_info(?)", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cs []column
+	for rows.Next() {
+		var c column
+		var notnull, pk int
+		if err := rows.Scan(&c.Name, &c.Type, &notnull, &pk); err != nil {
+			return nil, err
+		}
+		c.Primary = pk > 0
+		// sqlite 的 integer primary key 是 rowid 别名，不可能为空
+		c.Nullable = notnull == 0 && !c.Primary
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+func mysqlColumns(db *sql.DB, name string) ([]column, error) {
+	rows, err := db.Query(`SELECT column_name, column_type, is_nullable, column_key
+FROM information_schema.columns
+WHERE table_schema = DATABASE() AND table_name = ?
+ORDER BY ordinal_position`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cs []column
+	for rows.Next() {
+		var c column
+		var nullable, key string
+		if err := rows.Scan(&c.Name, &c.Type, &nullable, &key); err != nil {
+			return nil, err
+		}
+		c.Nullable = nullable == "YES"
+		c.Primary = key == "PRI"
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
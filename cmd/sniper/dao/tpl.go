@@ -0,0 +1,209 @@
+package dao
+
+import (
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+type dbTpl struct {
+	Package string // 包名
+	Name    string // 数据库配置名
+}
+
+func (t *dbTpl) tpl() string {
+	return strings.TrimLeft(`
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/go-kiss/sniper/pkg/sqldb"
+)
+
+// db 返回 {{.Name}} 数据库实例
+func db(ctx context.Context) *sqldb.DB {
+	return sqldb.Get(ctx, "{{.Name}}")
+}
+`, "\n")
+}
+
+type field struct {
+	Name   string // 字段名
+	Type   string // go 类型
+	Column string // 列名
+}
+
+type modelTpl struct {
+	Package string  // 包名
+	Table   string  // 表名
+	Model   string  // 模型名
+	Fields  []field // 字段
+	Key     *field  // 主键，没有单一主键时为空
+}
+
+func (t *modelTpl) tpl() string {
+	return strings.TrimLeft(`
+package {{.Package}}
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// {{.Model}} 对应 {{.Table}} 表
+type {{.Model}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} `+"`"+`db:"{{.Column}}"`+"`"+`
+{{- end}}
+}
+
+// TableName 返回表名
+func (m *{{.Model}}) TableName() string { return "{{.Table}}" }
+
+// KeyName 返回主键字段名，没有单一主键时为空，不能使用 Update 和 Upsert
+func (m *{{.Model}}) KeyName() string { return "{{if .Key}}{{.Key.Column}}{{end}}" }
+
+// Insert{{.Model}} 插入 {{.Table}} 记录
+func Insert{{.Model}}(ctx context.Context, m *{{.Model}}) (sql.Result, error) {
+	return db(ctx).InsertContext(ctx, m)
+}
+{{- with .Key}}
+
+// Get{{$.Model}} 根据主键查询 {{$.Table}} 记录
+func Get{{$.Model}}(ctx context.Context, {{.Name | lower1st}} {{.Type}}) (*{{$.Model}}, error) {
+	d := db(ctx)
+	m := &{{$.Model}}{}
+	q := d.Rebind("SELECT * FROM " + d.Quote("{{$.Table}}") + " WHERE " + d.Quote("{{.Column}}") + " = ?")
+	if err := d.GetContext(ctx, m, q, {{.Name | lower1st}}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Update{{$.Model}} 根据主键更新 {{$.Table}} 记录
+func Update{{$.Model}}(ctx context.Context, m *{{$.Model}}) (sql.Result, error) {
+	return db(ctx).UpdateContext(ctx, m)
+}
+
+// Delete{{$.Model}} 根据主键删除 {{$.Table}} 记录
+func Delete{{$.Model}}(ctx context.Context, {{.Name | lower1st}} {{.Type}}) (sql.Result, error) {
+	d := db(ctx)
+	q := d.Rebind("DELETE FROM " + d.Quote("{{$.Table}}") + " WHERE " + d.Quote("{{.Column}}") + " = ?")
+	return d.ExecContext(ctx, q, {{.Name | lower1st}})
+}
+{{- end}}
+`, "\n")
+}
+
+var (
+	intRE   = regexp.MustCompile(`(?i)int`)
+	floatRE = regexp.MustCompile(`(?i)real|floa|doub|dec|numeric`)
+	textRE  = regexp.MustCompile(`(?i)char|clob|text|enum|set|json`)
+	blobRE  = regexp.MustCompile(`(?i)blob|binary`)
+	timeRE  = regexp.MustCompile(`(?i)date|time`)
+	boolRE  = regexp.MustCompile(`(?i)^(bool|boolean|tinyint\(1\))`)
+)
+
+// goType 将数据库类型转换为 go 类型，可以为空的字段使用 sql.NullXXX
+//
+// 匹配顺序参考 sqlite 的类型亲和规则 https://sqlite.org/datatype3.html
+func goType(c column) string {
+	typ := "string"
+	switch {
+	case boolRE.MatchString(c.Type):
+		typ = "bool"
+	case intRE.MatchString(c.Type):
+		typ = "int64"
+	case textRE.MatchString(c.Type):
+		typ = "string"
+	case blobRE.MatchString(c.Type):
+		return "[]byte" // nil 即为 NULL
+	case floatRE.MatchString(c.Type):
+		typ = "float64"
+	case timeRE.MatchString(c.Type):
+		typ = "time.Time"
+	}
+
+	if !c.Nullable {
+		return typ
+	}
+
+	switch typ {
+	case "bool":
+		return "sql.NullBool"
+	case "int64":
+		return "sql.NullInt64"
+	case "float64":
+		return "sql.NullFloat64"
+	case "time.Time":
+		return "sql.NullTime"
+	default:
+		return "sql.NullString"
+	}
+}
+
+// 常见缩写，参考 golint
+var initialisms = map[string]bool{
+	"api": true, "id": true, "ip": true, "json": true, "uid": true,
+	"uri": true, "url": true, "uuid": true, "xml": true, "http": true,
+}
+
+// camel 将下划线风格的名称转换为驼峰风格
+//
+// user_id => UserID
+func camel(s string) string {
+	var b strings.Builder
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	}) {
+		if initialisms[strings.ToLower(p)] {
+			b.WriteString(strings.ToUpper(p))
+			continue
+		}
+		b.WriteString(strings.ToUpper(p[:1]) + p[1:])
+	}
+
+	name := b.String()
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "X" + name
+	}
+	return name
+}
+
+// model 根据表名生成模型名，去掉复数后缀
+//
+// users => User
+func model(table string) string {
+	name := camel(table)
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "sses"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return name[:len(name)-1]
+	}
+	return name
+}
+
+// lower1st 生成参数名，需要避开 go 关键字
+//
+// ID => id, UserID => userID, Type => type_
+func lower1st(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+
+	if strings.ToUpper(s) == s {
+		s = strings.ToLower(s)
+	} else {
+		s = strings.ToLower(s[:1]) + s[1:]
+	}
+
+	if token.IsKeyword(s) {
+		s += "_"
+	}
+	return s
+}
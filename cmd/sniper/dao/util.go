@@ -0,0 +1,30 @@
+package dao
+
+import (
+	"os"
+)
+
+func isSniperDir() bool {
+	dirs, err := os.ReadDir(".")
+	if err != nil {
+		panic(err)
+	}
+
+	// 检查 sniper 项目目录结构
+	// dao 代码依赖 cmd/dao 两个目录
+	sniperDirs := map[string]bool{"cmd": true, "dao": true}
+
+	c := 0
+	for _, d := range dirs {
+		if sniperDirs[d.Name()] {
+			c++
+		}
+	}
+
+	return c == len(sniperDirs)
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return !os.IsNotExist(err)
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 
+	"github.com/go-kiss/sniper/cmd/sniper/dao"
 	"github.com/go-kiss/sniper/cmd/sniper/new"
 	"github.com/go-kiss/sniper/cmd/sniper/rpc"
 	"github.com/go-kiss/sniper/cmd/sniper/twirp"
@@ -32,6 +33,7 @@ var Cmd = &cobra.Command{
 		flags.StringVar(&g.OptionPrefix, "option_prefix", "sniper", "legacy option prefix")
 		flags.StringVar(&g.RootPackage, "root_package", "github.com/go-kiss/sniper", "root package of pkg")
 		flags.BoolVar(&g.ValidateEnable, "validate_enable", false, "generate *.validate.go")
+		flags.BoolVar(&g.OpenAPIEnable, "openapi", false, "generate OpenAPI 3 documents")
 
 		if protocHelp {
 			fmt.Println("protoc-gen-twirp " + twirp.Version)
@@ -53,5 +55,6 @@ func init() {
 func main() {
 	Cmd.AddCommand(rpc.Cmd)
 	Cmd.AddCommand(new.Cmd)
+	Cmd.AddCommand(dao.Cmd)
 	Cmd.Execute()
 }
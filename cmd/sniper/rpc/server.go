@@ -208,8 +208,16 @@ func appendFuncs(serverAst *dst.File, twirp *dst.InterfaceType, imports []*dst.I
 		// 接口定义没有指定参数名
 		ft.Params.List[0].Names = []*dst.Ident{{Name: "ctx"}}
 		ft.Params.List[1].Names = []*dst.Ident{{Name: "req"}}
-		ft.Results.List[0].Names = []*dst.Ident{{Name: "resp"}}
-		ft.Results.List[1].Names = []*dst.Ident{{Name: "err"}}
+
+		// 服务端流式方法的第三个参数为 twirp.ServerStream[*Resp]，只返回 error
+		stream := len(ft.Params.List) == 3
+		if stream {
+			ft.Params.List[2].Names = []*dst.Ident{{Name: "stream"}}
+			ft.Results.List[0].Names = []*dst.Ident{{Name: "err"}}
+		} else {
+			ft.Results.List[0].Names = []*dst.Ident{{Name: "resp"}}
+			ft.Results.List[1].Names = []*dst.Ident{{Name: "err"}}
+		}
 
 		if f, ok := definedFuncs[name]; ok {
 			f.Type = ft
@@ -217,9 +225,14 @@ func appendFuncs(serverAst *dst.File, twirp *dst.InterfaceType, imports []*dst.I
 		}
 
 		in := ft.Params.List[1].Type.(*dst.StarExpr).X
-		out := ft.Results.List[0].Type.(*dst.StarExpr).X
+		var out dst.Expr
+		if stream {
+			out = ft.Params.List[2].Type.(*dst.IndexExpr).Index.(*dst.StarExpr).X
+		} else {
+			out = ft.Results.List[0].Type.(*dst.StarExpr).X
+		}
 
-		appendFunc(buf, name, getType(in), getType(out))
+		appendFunc(buf, name, getType(in), getType(out), stream)
 	}
 
 	pkg := server + "_v" + version
@@ -263,12 +276,13 @@ func getType(e dst.Expr) string {
 	return ""
 }
 
-func appendFunc(buf *bytes.Buffer, name, reqType, respType string) {
+func appendFunc(buf *bytes.Buffer, name, reqType, respType string, stream bool) {
 	args := &funcTpl{
 		Name:     name,
 		ReqType:  reqType,
 		RespType: respType,
 		Service:  upper1st(service),
+		Stream:   stream,
 	}
 
 	t, err := template.New("server").Parse(args.tpl())
@@ -42,9 +42,18 @@ type funcTpl struct {
 	Name     string // 函数名
 	ReqType  string // 请求消息类型
 	RespType string // 返回消息类型
+	Stream   bool   // 是否为服务端流式方法
 }
 
 func (t *funcTpl) tpl() string {
+	if t.Stream {
+		return `
+func (s *{{.Service}}Server) {{.Name}}(ctx context.Context, req *{{.ReqType}}, stream twirp.ServerStream[*{{.RespType}}]) (err error) {
+	// FIXME 请开始你的表演，调用 stream.Send 发送消息
+	return
+}
+`
+	}
 	return `
 func (s *{{.Service}}Server) {{.Name}}(ctx context.Context, req *{{.ReqType}}) (resp *{{.RespType}}, err error) {
 	{{if eq .Name  "Echo"}}
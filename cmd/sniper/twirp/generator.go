@@ -48,6 +48,8 @@ type twirp struct {
 	RootPackage string
 	// 是否开启 validate
 	ValidateEnable bool
+	// 是否生成 OpenAPI 文档
+	OpenAPIEnable bool
 
 	filesHandled int
 
@@ -108,7 +110,6 @@ func (t *twirp) Generate(plugin *protogen.Plugin) error {
 	t.registerPackageName("strings")
 	t.registerPackageName("context")
 	t.registerPackageName("http")
-	t.registerPackageName("io")
 	t.registerPackageName("json")
 	t.registerPackageName("protojson")
 	t.registerPackageName("proto")
@@ -123,6 +124,14 @@ func (t *twirp) Generate(plugin *protogen.Plugin) error {
 			continue
 		}
 
+		for _, s := range f.Services {
+			for _, m := range s.Methods {
+				if m.Desc.IsStreamingClient() {
+					return fmt.Errorf("%s: client streaming is not supported", m.Desc.FullName())
+				}
+			}
+		}
+
 		t.generate(f)
 		if t.ValidateEnable {
 			t.generateValidate(f)
@@ -152,6 +161,9 @@ func (t *twirp) generate(file *protogen.File) {
 
 	for i, service := range file.Services {
 		t.generateService(file, service, i)
+		if t.OpenAPIEnable {
+			t.generateOpenAPI(file, service)
+		}
 	}
 
 	t.generateFileDescriptor(file)
@@ -191,7 +203,6 @@ func (t *twirp) generateImports(file *protogen.File) {
 	t.P(`import `, t.pkgs["fmt"], ` "fmt"`)
 	t.P(`import `, t.pkgs["strconv"], ` "strconv"`)
 	t.P(`import `, t.pkgs["errors"], ` "errors"`)
-	t.P(`import `, t.pkgs["io"], ` "io"`)
 	t.P(`import `, t.pkgs["http"], ` "net/http"`)
 	t.P()
 	t.P(`import `, t.pkgs["protojson"], ` "google.golang.org/protobuf/encoding/protojson"`)
@@ -250,6 +261,9 @@ func (t *twirp) generateService(file *protogen.File, service *protogen.Service,
 	t.sectionComment(service.GoName + ` Interface`)
 	t.generateTwirpInterface(file, service)
 
+	t.sectionComment(service.GoName + ` Client Interface`)
+	t.generateClientInterface(file, service)
+
 	t.sectionComment(service.GoName + ` Protobuf Client`)
 	t.generateClient("Protobuf", file, service)
 
@@ -275,9 +289,34 @@ func (t *twirp) generateSignature(method *protogen.Method) string {
 	methName := method.GoName
 	inputType := t.getType(method.Input)
 	outputType := t.getType(method.Output)
+	if method.Desc.IsStreamingServer() {
+		return fmt.Sprintf(`	%s(%s.Context, *%s, %s.ServerStream[*%s]) error`, methName, t.pkgs["context"], inputType, t.pkgs["twirp"], outputType)
+	}
 	return fmt.Sprintf(`	%s(%s.Context, *%s) (*%s, error)`, methName, t.pkgs["context"], inputType, outputType)
 }
 
+// generateClientInterface 生成客户端接口，普通方法与服务接口相同，
+// 服务端流式方法返回 twirp.ClientStream 迭代读取
+func (t *twirp) generateClientInterface(file *protogen.File, service *protogen.Service) {
+	t.P(`// `, service.GoName, `Client is the client API for the `, service.GoName, ` service.`)
+	t.P(`type `, service.GoName, `Client interface {`)
+	for _, method := range service.Methods {
+		t.printComments(method.Comments)
+		t.P(t.generateClientSignature(method))
+		t.P()
+	}
+	t.P(`}`)
+}
+
+func (t *twirp) generateClientSignature(method *protogen.Method) string {
+	if !method.Desc.IsStreamingServer() {
+		return t.generateSignature(method)
+	}
+	inputType := t.getType(method.Input)
+	outputType := t.getType(method.Output)
+	return fmt.Sprintf(`	%s(%s.Context, *%s) (*%s.ClientStream[*%s], error)`, method.GoName, t.pkgs["context"], inputType, t.pkgs["twirp"], outputType)
+}
+
 func (t *twirp) getType(m *protogen.Message) string {
 	pkg := path.Base(string(m.GoIdent.GoImportPath))
 	if _, ok := t.deps[pkg]; ok {
@@ -297,11 +336,14 @@ func (t *twirp) generateClient(name string, file *protogen.File, service *protog
 	t.P(`type `, structName, ` struct {`)
 	t.P(`  client `, t.pkgs["twirp"], `.HTTPClient`)
 	t.P(`  urls   [`, methCnt, `]string`)
+	t.P(`  hooks  *`, t.pkgs["twirp"], `.ClientHooks`)
 	t.P(`}`)
 	t.P()
-	t.P(`// `, newClientFunc, ` creates a `, name, ` client that implements the `, servName, ` interface.`)
-	t.P(`// It communicates using `, name, ` and can be configured with a custom HTTPClient.`)
-	t.P(`func `, newClientFunc, `(addr string, client `, t.pkgs["twirp"], `.HTTPClient) `, servName, ` {`)
+	t.P(`// `, newClientFunc, ` creates a `, name, ` client that implements the `, servName, `Client interface.`)
+	t.P(`// It communicates using `, name, ` and can be configured with a custom HTTPClient`)
+	t.P(`// and client options such as twirp.WithClientHooks.`)
+	t.P(`func `, newClientFunc, `(addr string, client `, t.pkgs["twirp"], `.HTTPClient, opts ...`, t.pkgs["twirp"], `.ClientOption) `, servName, `Client {`)
+	t.P(`  clientOpts := `, t.pkgs["twirp"], `.NewClientOptions(opts...)`)
 	t.P(`  prefix := addr + `, pathPrefixConst)
 	t.P(`  urls := [`, methCnt, `]string{`)
 	for _, method := range service.Methods {
@@ -311,6 +353,7 @@ func (t *twirp) generateClient(name string, file *protogen.File, service *protog
 	t.P(`  return &`, structName, `{`)
 	t.P(`    client: client,`)
 	t.P(`    urls:   urls,`)
+	t.P(`    hooks:  clientOpts.Hooks,`)
 	t.P(`  }`)
 	t.P(`}`)
 	t.P()
@@ -320,12 +363,23 @@ func (t *twirp) generateClient(name string, file *protogen.File, service *protog
 		inputType := t.getType(method.Input)
 		outputType := t.getType(method.Output)
 
+		if method.Desc.IsStreamingServer() {
+			t.P(`func (c *`, structName, `) `, methName, `(ctx `, t.pkgs["context"], `.Context, in *`, inputType, `) (*`, t.pkgs["twirp"], `.ClientStream[*`, outputType, `], error) {`)
+			t.P(`  ctx = `, t.pkgs["twirp"], `.WithPackageName(ctx, "`, *file.Proto.Package, `")`)
+			t.P(`  ctx = `, t.pkgs["twirp"], `.WithServiceName(ctx, "`, servName, `")`)
+			t.P(`  ctx = `, t.pkgs["twirp"], `.WithMethodName(ctx, "`, methName, `")`)
+			t.P(`  return `, t.pkgs["twirp"], `.Do`, name, `StreamWithHooks(ctx, c.client, c.hooks, c.urls[`, strconv.Itoa(i), `], in, func() *`, outputType, ` { return new(`, outputType, `) })`)
+			t.P(`}`)
+			t.P()
+			continue
+		}
+
 		t.P(`func (c *`, structName, `) `, methName, `(ctx `, t.pkgs["context"], `.Context, in *`, inputType, `) (*`, outputType, `, error) {`)
 		t.P(`  ctx = `, t.pkgs["twirp"], `.WithPackageName(ctx, "`, *file.Proto.Package, `")`)
 		t.P(`  ctx = `, t.pkgs["twirp"], `.WithServiceName(ctx, "`, servName, `")`)
 		t.P(`  ctx = `, t.pkgs["twirp"], `.WithMethodName(ctx, "`, methName, `")`)
 		t.P(`  out := new(`, outputType, `)`)
-		t.P(`  err := `, t.pkgs["twirp"], `.Do`, name, `Request(ctx, c.client, c.urls[`, strconv.Itoa(i), `], in, out)`)
+		t.P(`  err := `, t.pkgs["twirp"], `.Do`, name, `RequestWithHooks(ctx, c.client, c.hooks, c.urls[`, strconv.Itoa(i), `], in, out)`)
 		t.P(`  if err != nil {`)
 		t.P(`    return nil, err`)
 		t.P(`  }`)
@@ -424,6 +478,7 @@ func (t *twirp) generateServerRouting(servStruct string, file *protogen.File, se
 	t.P(`    return`)
 	t.P(`  }`)
 	t.P()
+	t.generateAllowGET(service)
 	t.P(`  if req.Method != "POST" && !`, t.pkgs["twirp"], `.AllowGET(ctx) {`)
 	t.P(`    msg := `, t.pkgs["fmt"], `.Sprintf("unsupported method %q (only POST is allowed)", req.Method)`)
 	t.P(`    err = s.badRouteError(msg, req.Method, req.URL.Path)`)
@@ -449,6 +504,31 @@ func (t *twirp) generateServerRouting(servStruct string, file *protogen.File, se
 	t.P()
 }
 
+// generateAllowGET 方法注释带有 get 选项的方法允许 GET 请求
+func (t *twirp) generateAllowGET(service *protogen.Service) {
+	var paths []string
+	for _, method := range service.Methods {
+		matched := t.methodOptionRegexp.FindStringSubmatch(method.Comments.Trailing.String())
+		if len(matched) != 2 {
+			continue
+		}
+		for _, kv := range strings.Split(matched[1], ",") {
+			if k, _, _ := strings.Cut(kv, "="); k == "get" {
+				paths = append(paths, strconv.Quote(t.pathFor(service, method)))
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	t.P(`  switch req.URL.Path {`)
+	t.P(`  case `, strings.Join(paths, ", "), `:`)
+	t.P(`    ctx = `, t.pkgs["twirp"], `.WithAllowGET(ctx, true)`)
+	t.P(`  }`)
+	t.P()
+}
+
 func (t *twirp) generateServerMethod(file *protogen.File, service *protogen.Service, method *protogen.Method) {
 	methName := method.GoName
 	servStruct := serviceStruct(service)
@@ -477,6 +557,42 @@ func (t *twirp) generateServerMethod(file *protogen.File, service *protogen.Serv
 	t.generateServerJSONMethod(service, method)
 	t.generateServerProtobufMethod(service, method)
 	t.generateServerFormMethod(service, method)
+	if method.Desc.IsStreamingServer() {
+		t.generateServerStreamMethod(service, method)
+	}
+}
+
+// generateStreamCall 流式方法解析请求之后统一交给 stream 方法处理
+func (t *twirp) generateStreamCall(method *protogen.Method) bool {
+	if !method.Desc.IsStreamingServer() {
+		return false
+	}
+	t.P(`  s.stream`, method.GoName, `(ctx, resp, req, reqContent)`)
+	t.P(`}`)
+	t.P()
+	return true
+}
+
+func (t *twirp) generateServerStreamMethod(service *protogen.Service, method *protogen.Method) {
+	servStruct := serviceStruct(service)
+	methName := method.GoName
+	servName := service.GoName
+	t.P(`func (s *`, servStruct, `) stream`, methName, `(ctx `, t.pkgs["context"], `.Context, resp `, t.pkgs["http"], `.ResponseWriter, req *`, t.pkgs["http"], `.Request, reqContent *`, t.getType(method.Input), `) {`)
+	t.P(`  stream := `, t.pkgs["twirp"], `.NewStreamWriter[*`, t.getType(method.Output), `](ctx, resp, req, s.hooks)`)
+	t.P(`  var err error`)
+	t.P(`  func() {`)
+	t.P(`    defer func() {`)
+	t.P(`      // In case of a panic, end the stream with an error and then panic.`)
+	t.P(`      if r := recover(); r != nil {`)
+	t.P(`        stream.Finish(`, t.pkgs["twirp"], `.InternalError("Internal service panic"))`)
+	t.P(`        panic(r)`)
+	t.P(`      }`)
+	t.P(`    }()`)
+	t.P(`    err = s.`, servName, `.`, methName, `(ctx, reqContent, stream)`)
+	t.P(`  }()`)
+	t.P(`  stream.Finish(err)`)
+	t.P(`}`)
+	t.P()
 }
 
 func (t *twirp) generateServerJSONMethod(service *protogen.Service, method *protogen.Method) {
@@ -493,7 +609,7 @@ func (t *twirp) generateServerJSONMethod(service *protogen.Service, method *prot
 	t.P(`  }`)
 	t.P()
 	t.P(`  reqContent := new(`, t.getType(method.Input), `)`)
-	t.P(`  body, err := io.ReadAll(req.Body)`)
+	t.P(`  body, err := `, t.pkgs["twirp"], `.ReadRequestBody(ctx, req)`)
 	t.P(`  if err != nil {`)
 	t.P(`    s.writeError(ctx, resp, err)`)
 	t.P(`    return`)
@@ -509,6 +625,9 @@ func (t *twirp) generateServerJSONMethod(service *protogen.Service, method *prot
 	t.P()
 	t.P(`  ctx = twirp.WithRequest(ctx, reqContent)`)
 	t.addValidate(method, service)
+	if t.generateStreamCall(method) {
+		return
+	}
 	t.P(`  // Call service method`)
 	t.P(`  var respContent `, t.pkgs["proto"], `.Message`)
 	t.P(`  var ok bool`)
@@ -566,6 +685,7 @@ func (t *twirp) generateServerJSONMethod(service *protogen.Service, method *prot
 	t.P(`    resp.Header().Set("Content-Type", "application/json")`)
 	t.P(`  }`)
 	t.P()
+	t.P(`  respBytes = `, t.pkgs["twirp"], `.CompressResponse(ctx, resp, req, respBytes)`)
 	t.P(`  ctx = `, t.pkgs["twirp"], `.WithStatusCode(ctx, respStatus)`)
 	t.P(`  resp.WriteHeader(respStatus)`)
 	t.P()
@@ -592,6 +712,10 @@ func (t *twirp) generateServerFormMethod(service *protogen.Service, method *prot
 	t.P(`    return`)
 	t.P(`  }`)
 	t.P()
+	t.P(`  if err = `, t.pkgs["twirp"], `.DecodeRequestBody(ctx, req); err != nil {`)
+	t.P(`    s.writeError(ctx, resp, err)`)
+	t.P(`    return`)
+	t.P(`  }`)
 	t.P(`  err = req.ParseForm()`)
 	t.P(`  if err != nil {`)
 	t.P(`    s.writeError(ctx, resp, err)`)
@@ -656,6 +780,9 @@ func (t *twirp) generateServerFormMethod(service *protogen.Service, method *prot
 	t.P(`  ctx = twirp.WithRequest(ctx, reqContent)`)
 	t.P()
 	t.addValidate(method, service)
+	if t.generateStreamCall(method) {
+		return
+	}
 
 	t.P()
 	t.P(`  // Call service method`)
@@ -715,6 +842,7 @@ func (t *twirp) generateServerFormMethod(service *protogen.Service, method *prot
 	t.P(`    resp.Header().Set("Content-Type", "application/json")`)
 	t.P(`  }`)
 	t.P()
+	t.P(`  respBytes = `, t.pkgs["twirp"], `.CompressResponse(ctx, resp, req, respBytes)`)
 	t.P(`  ctx = `, t.pkgs["twirp"], `.WithStatusCode(ctx, respStatus)`)
 	t.P(`  resp.WriteHeader(respStatus)`)
 	t.P()
@@ -741,10 +869,9 @@ func (t *twirp) generateServerProtobufMethod(service *protogen.Service, method *
 	t.P(`    return`)
 	t.P(`  }`)
 	t.P()
-	t.P(`  buf, err := `, t.pkgs["io"], `.ReadAll(req.Body)`)
+	t.P(`  buf, err := `, t.pkgs["twirp"], `.ReadRequestBody(ctx, req)`)
 	t.P(`  if err != nil {`)
-	t.P(`    err = s.wrapErr(err, "failed to read request body")`)
-	t.P(`    s.writeError(ctx, resp, `, t.pkgs["twirp"], `.InternalErrorWith(err))`)
+	t.P(`    s.writeError(ctx, resp, err)`)
 	t.P(`    return`)
 	t.P(`  }`)
 	t.P(`  reqContent := new(`, t.getType(method.Input), `)`)
@@ -758,6 +885,9 @@ func (t *twirp) generateServerProtobufMethod(service *protogen.Service, method *
 	t.P()
 	t.P(`  ctx = twirp.WithRequest(ctx, reqContent)`)
 	t.addValidate(method, service)
+	if t.generateStreamCall(method) {
+		return
+	}
 	t.P(`  // Call service method`)
 	t.P(`  var respContent `, t.pkgs["proto"], `.Message`)
 	t.P(`  var ok bool`)
@@ -815,6 +945,7 @@ func (t *twirp) generateServerProtobufMethod(service *protogen.Service, method *
 	t.P(`    resp.Header().Set("Content-Type", "application/protobuf")`)
 	t.P(`  }`)
 	t.P()
+	t.P(`  respBytes = `, t.pkgs["twirp"], `.CompressResponse(ctx, resp, req, respBytes)`)
 	t.P(`  ctx = `, t.pkgs["twirp"], `.WithStatusCode(ctx, respStatus)`)
 	t.P(`  resp.WriteHeader(respStatus)`)
 	t.P(`  if n, err := resp.Write(respBytes); err != nil {`)
@@ -857,12 +988,27 @@ func (t *twirp) generateServiceMetadataAccessors(file *protogen.File, service *p
 	t.P(`func (s *`, servStruct, `) ProtocGenTwirpVersion() (string) {`)
 	t.P(`  return `, strconv.Quote(Version))
 	t.P(`}`)
+	t.P()
+	t.P(`// MethodOptions returns the options declared in the trailing comments of methods.`)
+	t.P(`func (s *`, servStruct, `) MethodOptions() map[string]string {`)
+	t.P(`  return map[string]string{`)
+	for _, method := range service.Methods {
+		matched := t.methodOptionRegexp.FindStringSubmatch(method.Comments.Trailing.String())
+		if len(matched) == 2 {
+			t.P(`    `, strconv.Quote(string(method.Desc.Name())), `: `, strconv.Quote(matched[1]), `,`)
+		}
+	}
+	t.P(`  }`)
+	t.P(`}`)
 }
 
 func (t *twirp) generateFileDescriptor(file *protogen.File) {
-	// Copied straight of of protoc-gen-go, which trims out comments.
+	// Comments are only kept with openapi=true, so that the service catalog
+	// can show them. Otherwise they are stripped like protoc-gen-go does.
 	pb := proto.Clone(file.Proto).(*descriptorpb.FileDescriptorProto)
-	pb.SourceCodeInfo = nil
+	if !t.OpenAPIEnable {
+		pb.SourceCodeInfo = nil
+	}
 
 	b, err := proto.Marshal(pb)
 	if err != nil {
@@ -0,0 +1,537 @@
+package twirp
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-kiss/sniper/cmd/sniper/twirp/templates/rule"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// twirpErrorCodes twirp 错误码，与 pkg/twirp 保持一致
+var twirpErrorCodes = []string{
+	"canceled", "unknown", "invalid_argument", "deadline_exceeded",
+	"not_found", "bad_route", "already_exists", "permission_denied",
+	"unauthenticated", "resource_exhausted", "failed_precondition", "aborted",
+	"out_of_range", "unimplemented", "internal", "unavailable", "data_loss",
+}
+
+// openAPIFileName 返回服务的 OpenAPI 文档路径，与生成代码放在同一目录
+func openAPIFileName(file *protogen.File, service *protogen.Service) string {
+	return path.Join(path.Dir(file.GeneratedFilenamePrefix), strings.ToLower(service.GoName)+".openapi.json")
+}
+
+// generateOpenAPI 为服务生成 OpenAPI 3 文档，同时内嵌到生成代码中
+func (t *twirp) generateOpenAPI(file *protogen.File, service *protogen.Service) {
+	g := &openAPI{t: t, schemas: map[string]any{}}
+	doc := g.document(file, service)
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	b = append(b, '\n')
+
+	gf := t.plugin.NewGeneratedFile(openAPIFileName(file, service), "")
+	gf.Write(b)
+
+	literal := "`" + string(b) + "`"
+	if strings.Contains(string(b), "`") {
+		literal = strconv.Quote(string(b))
+	}
+
+	servStruct := serviceStruct(service)
+	t.P(`// OpenAPI returns the OpenAPI 3 document of the service in JSON.`)
+	t.P(`func (s *`, servStruct, `) OpenAPI() []byte {`)
+	t.P(`  return []byte(`, unexported(service.GoName), `OpenAPI)`)
+	t.P(`}`)
+	t.P()
+	t.P(`const `, unexported(service.GoName), `OpenAPI = `, literal)
+	t.P()
+}
+
+type openAPI struct {
+	t       *twirp
+	schemas map[string]any
+}
+
+func (g *openAPI) document(file *protogen.File, service *protogen.Service) map[string]any {
+	fullName := string(service.Desc.FullName())
+
+	paths := map[string]any{}
+	for _, method := range service.Methods {
+		paths[g.t.pathFor(service, method)] = g.pathItem(service, method)
+	}
+
+	info := map[string]any{
+		"title":   fullName,
+		"version": string(file.Desc.Package()),
+	}
+	if c := cleanComment(service.Comments.Leading); c != "" {
+		info["description"] = c
+	}
+
+	g.schemas["twirp.Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"code", "msg"},
+		"properties": map[string]any{
+			"code": map[string]any{"type": "string", "enum": twirpErrorCodes},
+			"msg":  map[string]any{"type": "string"},
+			"meta": map[string]any{
+				"type":                 "object",
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+			"details": map[string]any{
+				"type":        "array",
+				"description": "google.protobuf.Any 格式的错误详情",
+				"items":       map[string]any{"type": "object"},
+			},
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    info,
+		"tags": []any{map[string]any{
+			"name":        service.GoName,
+			"description": cleanComment(service.Comments.Leading),
+		}},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"responses": map[string]any{
+				"TwirpError": map[string]any{
+					"description": "twirp 错误，HTTP 状态码由错误码决定",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": ref("twirp.Error")},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (g *openAPI) pathItem(service *protogen.Service, method *protogen.Method) map[string]any {
+	comment := cleanComment(method.Comments.Leading)
+	summary, _, _ := strings.Cut(comment, "\n")
+
+	options := map[string]string{}
+	if matched := g.t.methodOptionRegexp.FindStringSubmatch(method.Comments.Trailing.String()); len(matched) == 2 {
+		for _, kv := range strings.Split(matched[1], ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			options[k] = v
+		}
+	}
+
+	op := map[string]any{
+		"operationId": service.GoName + "_" + method.GoName,
+		"tags":        []string{service.GoName},
+		"responses":   g.responses(method),
+	}
+	if summary != "" {
+		op["summary"] = summary
+	}
+	if comment != summary {
+		op["description"] = comment
+	}
+	if len(options) > 0 {
+		op["x-sniper-options"] = options
+	}
+
+	input := string(method.Input.Desc.FullName())
+	g.message(method.Input)
+
+	post := copyMap(op)
+	post["requestBody"] = map[string]any{
+		"required": true,
+		"content": map[string]any{
+			"application/json":                  map[string]any{"schema": ref(input)},
+			"application/x-www-form-urlencoded": map[string]any{"schema": g.formSchema(method.Input)},
+			"application/protobuf":              map[string]any{"schema": binarySchema()},
+		},
+	}
+
+	item := map[string]any{"post": post}
+	if _, ok := options["get"]; ok {
+		get := copyMap(op)
+		get["operationId"] = service.GoName + "_" + method.GoName + "_GET"
+		get["parameters"] = g.queryParameters(method.Input)
+		item["get"] = get
+	}
+	return item
+}
+
+func (g *openAPI) responses(method *protogen.Method) map[string]any {
+	output := string(method.Output.Desc.FullName())
+	g.message(method.Output)
+
+	var content map[string]any
+	switch {
+	case method.Desc.IsStreamingServer():
+		frame := map[string]any{
+			"type":        "object",
+			"description": "每行一条消息 {\"result\": ...}，正常结束时没有额外的行，出错时最后一行为 {\"error\": ...}",
+			"properties": map[string]any{
+				"result": ref(output),
+				"error":  ref("twirp.Error"),
+			},
+		}
+		content = map[string]any{
+			"application/x-ndjson":          map[string]any{"schema": frame},
+			"text/event-stream":             map[string]any{"schema": map[string]any{"type": "string"}},
+			"application/x-protobuf-stream": map[string]any{"schema": binarySchema()},
+		}
+	case isHTTPBody(method.Output):
+		content = map[string]any{
+			"*/*": map[string]any{"schema": binarySchema()},
+		}
+	default:
+		content = map[string]any{
+			"application/json":     map[string]any{"schema": ref(output)},
+			"application/protobuf": map[string]any{"schema": binarySchema()},
+		}
+	}
+
+	errResp := map[string]any{"$ref": "#/components/responses/TwirpError"}
+	return map[string]any{
+		"200": map[string]any{"description": "OK", "content": content},
+		"4XX": errResp,
+		"5XX": errResp,
+	}
+}
+
+// isHTTPBody 判断响应是否为自定义的 http body，这类响应直接输出 data 字段
+func isHTTPBody(m *protogen.Message) bool {
+	fields := m.Desc.Fields()
+	ct := fields.ByName("content_type")
+	data := fields.ByName("data")
+	return ct != nil && ct.Kind() == protoreflect.StringKind &&
+		data != nil && data.Kind() == protoreflect.BytesKind
+}
+
+// message 生成消息及其引用的消息的 schema
+func (g *openAPI) message(m *protogen.Message) {
+	name := string(m.Desc.FullName())
+	if _, ok := g.schemas[name]; ok {
+		return
+	}
+	if s, ok := wellKnownSchema(name); ok {
+		g.schemas[name] = s
+		return
+	}
+
+	props := map[string]any{}
+	schema := map[string]any{"type": "object", "properties": props}
+	g.schemas[name] = schema
+	if c := cleanComment(m.Comments.Leading); c != "" {
+		schema["description"] = c
+	}
+
+	for _, f := range m.Fields {
+		props[string(f.Desc.Name())] = g.field(f)
+	}
+}
+
+func (g *openAPI) field(f *protogen.Field) map[string]any {
+	var s map[string]any
+	switch {
+	case f.Desc.IsMap():
+		s = map[string]any{
+			"type":                 "object",
+			"additionalProperties": g.kindSchema(f.Message.Fields[1]),
+		}
+	case f.Desc.IsList():
+		item := g.kindSchema(f)
+		s = map[string]any{"type": "array", "items": item}
+		g.addRules(f, s, item)
+	default:
+		s = g.kindSchema(f)
+		g.addRules(f, s, s)
+	}
+
+	desc := cleanComment(f.Comments.Leading)
+	if o := f.Desc.ContainingOneof(); o != nil && !o.IsSynthetic() {
+		desc = strings.TrimSpace(desc + "\n\noneof " + string(o.Name()))
+	}
+	if desc != "" {
+		if _, isRef := s["$ref"]; isRef {
+			// 3.0 中 $ref 的兄弟字段会被忽略
+			s = map[string]any{"allOf": []any{s}}
+		}
+		s["description"] = desc
+	}
+	return s
+}
+
+// kindSchema 按 protojson 的格式返回字段类型
+func (g *openAPI) kindSchema(f *protogen.Field) map[string]any {
+	switch f.Desc.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]any{"type": "integer", "format": "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]any{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]any{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]any{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]any{"type": "number", "format": "double"}
+	case protoreflect.StringKind:
+		return map[string]any{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := []string{}
+		for _, v := range f.Enum.Values {
+			values = append(values, string(v.Desc.Name()))
+		}
+		s := map[string]any{"type": "string", "enum": values}
+		if c := cleanComment(f.Enum.Comments.Leading); c != "" {
+			s["description"] = c
+		}
+		return s
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		g.message(f.Message)
+		return ref(string(f.Message.Desc.FullName()))
+	}
+	return map[string]any{}
+}
+
+// formSchema 表单只支持标量字段，与生成的 serveXForm 一致
+func (g *openAPI) formSchema(m *protogen.Message) map[string]any {
+	props := map[string]any{}
+	for _, f := range m.Fields {
+		if ft, _ := getFieldType(f.Desc.Kind()); ft == "" || f.Desc.IsMap() {
+			continue
+		}
+		props[string(f.Desc.Name())] = g.field(f)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func (g *openAPI) queryParameters(m *protogen.Message) []any {
+	params := []any{}
+	for _, f := range m.Fields {
+		if ft, _ := getFieldType(f.Desc.Kind()); ft == "" || f.Desc.IsMap() {
+			continue
+		}
+		p := map[string]any{
+			"name":   string(f.Desc.Name()),
+			"in":     "query",
+			"schema": g.field(f),
+		}
+		if f.Desc.IsList() {
+			// 同时支持 a=1&a=2 和 a=1,2
+			p["style"] = "form"
+			p["explode"] = true
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// addRules 将 validate 规则转换为 schema 关键字，repeated 字段的规则作用在元素上
+func (g *openAPI) addRules(f *protogen.Field, s, item map[string]any) {
+	if !g.t.ValidateEnable {
+		return
+	}
+	if _, isRef := item["$ref"]; isRef {
+		return
+	}
+
+	var patterns, notes []string
+	for _, r := range rule.Rules(f) {
+		v := strings.TrimSpace(r.Value)
+		switch r.Key {
+		case "len":
+			item["minLength"], item["maxLength"] = ruleNumber(v), ruleNumber(v)
+		case "min_len":
+			item["minLength"] = ruleNumber(v)
+		case "max_len":
+			item["maxLength"] = ruleNumber(v)
+		case "pattern":
+			patterns = append(patterns, ruleString(v))
+		case "prefix":
+			patterns = append(patterns, "^"+regexp.QuoteMeta(ruleString(v)))
+		case "suffix":
+			patterns = append(patterns, regexp.QuoteMeta(ruleString(v))+"$")
+		case "contains":
+			patterns = append(patterns, regexp.QuoteMeta(ruleString(v)))
+		case "not_contains":
+			notes = append(notes, "must not contain "+strconv.Quote(ruleString(v)))
+		case "eq":
+			item["enum"] = []any{ruleValue(v)}
+		case "in":
+			item["enum"] = ruleList(v)
+		case "not_in":
+			item["not"] = map[string]any{"enum": ruleList(v)}
+		case "gt":
+			item["minimum"], item["exclusiveMinimum"] = ruleNumber(v), true
+		case "gte":
+			item["minimum"] = ruleNumber(v)
+		case "lt":
+			item["maximum"], item["exclusiveMaximum"] = ruleNumber(v), true
+		case "lte":
+			item["maximum"] = ruleNumber(v)
+		case "range":
+			min, max, minIn, maxIn, ok := rule.RangeBounds(v)
+			if !ok {
+				continue
+			}
+			item["minimum"], item["maximum"] = ruleNumber(min), ruleNumber(max)
+			if !minIn {
+				item["exclusiveMinimum"] = true
+			}
+			if !maxIn {
+				item["exclusiveMaximum"] = true
+			}
+		case "min_items":
+			s["minItems"] = ruleNumber(v)
+		case "max_items":
+			s["maxItems"] = ruleNumber(v)
+		case "unique":
+			s["uniqueItems"] = v == "true"
+		case "type":
+			switch v {
+			case "url":
+				item["format"] = "uri"
+			case "ip":
+				item["format"] = "ip"
+			case "email":
+				item["format"] = "email"
+			case "phone":
+				patterns = append(patterns, `^1[3-9]\d{9}$`)
+			}
+		}
+	}
+
+	switch len(patterns) {
+	case 0:
+	case 1:
+		item["pattern"] = patterns[0]
+	default:
+		all := []any{}
+		for _, p := range patterns {
+			all = append(all, map[string]any{"pattern": p})
+		}
+		item["allOf"] = all
+	}
+	if len(notes) > 0 {
+		item["x-validate"] = notes
+	}
+}
+
+// ruleValue 规则的值为 go 字面量，字符串需要去掉引号
+func ruleValue(v string) any {
+	if s, err := strconv.Unquote(v); err == nil {
+		return s
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		return n
+	}
+	return v
+}
+
+func ruleNumber(v string) any {
+	if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+		return n
+	}
+	return v
+}
+
+func ruleString(v string) string {
+	if s, err := strconv.Unquote(v); err == nil {
+		return s
+	}
+	return v
+}
+
+func ruleList(v string) []any {
+	list := []any{}
+	for _, s := range splitRuleList(v) {
+		list = append(list, ruleValue(strings.TrimSpace(s)))
+	}
+	return list
+}
+
+// splitRuleList 解析 [1,2,3] 格式的列表，与 validate 模板的 slice 函数一致
+func splitRuleList(v string) []string {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "[") || !strings.HasSuffix(v, "]") {
+		return nil
+	}
+	return strings.Split(v[1:len(v)-1], ",")
+}
+
+// wellKnownSchema 按 protojson 的格式返回常用 well-known types
+func wellKnownSchema(name string) (map[string]any, bool) {
+	switch name {
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}, true
+	case "google.protobuf.Duration":
+		return map[string]any{"type": "string", "example": "1.5s"}, true
+	case "google.protobuf.FieldMask":
+		return map[string]any{"type": "string", "example": "a,b.c"}, true
+	case "google.protobuf.Empty", "google.protobuf.Struct":
+		return map[string]any{"type": "object"}, true
+	case "google.protobuf.Any":
+		return map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"@type": map[string]any{"type": "string"}},
+		}, true
+	case "google.protobuf.Value":
+		return map[string]any{}, true
+	case "google.protobuf.ListValue":
+		return map[string]any{"type": "array", "items": map[string]any{}}, true
+	case "google.protobuf.StringValue":
+		return map[string]any{"type": "string", "nullable": true}, true
+	case "google.protobuf.BytesValue":
+		return map[string]any{"type": "string", "format": "byte", "nullable": true}, true
+	case "google.protobuf.BoolValue":
+		return map[string]any{"type": "boolean", "nullable": true}, true
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return map[string]any{"type": "integer", "nullable": true}, true
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return map[string]any{"type": "string", "format": "int64", "nullable": true}, true
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return map[string]any{"type": "number", "nullable": true}, true
+	}
+	return nil, false
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func binarySchema() map[string]any {
+	return map[string]any{"type": "string", "format": "binary"}
+}
+
+func copyMap(m map[string]any) map[string]any {
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// cleanComment 去掉注释中的校验规则和首尾空白
+func cleanComment(c protogen.Comments) string {
+	lines := []string{}
+	for _, l := range strings.Split(string(c), "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "@") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,132 @@
+package twirp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// generate 使用 testdata/echo.textproto 生成代码，返回不含目录的文件名到内容的映射
+func generate(t *testing.T, openapi bool) map[string]string {
+	b, err := os.ReadFile("testdata/echo.textproto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal(b, fd); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fd.GetName()},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGenerator()
+	g.OptionPrefix = "sniper"
+	g.RootPackage = "github.com/go-kiss/sniper"
+	g.ValidateEnable = true
+	g.OpenAPIEnable = openapi
+	if err := g.Generate(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := plugin.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+
+	files := map[string]string{}
+	for _, f := range resp.File {
+		files[path.Base(f.GetName())] = f.GetContent()
+	}
+	return files
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	files := generate(t, true)
+
+	got, ok := files["echo.openapi.json"]
+	if !ok {
+		t.Fatal("openapi document not generated")
+	}
+
+	golden := "testdata/echo.openapi.json"
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("openapi document mismatch, run go test -update to update golden file\n%s", got)
+	}
+}
+
+func TestFileDescriptorComments(t *testing.T) {
+	for _, openapi := range []bool{true, false} {
+		fd := embeddedDescriptor(t, generate(t, openapi)["echo.twirp.go"])
+		if kept := fd.SourceCodeInfo != nil; kept != openapi {
+			t.Fatal("comments should only be kept with openapi", openapi)
+		}
+	}
+}
+
+var descriptorRE = regexp.MustCompile(`(?s)var twirpFileDescriptor\w+ = \[\]byte\{(.*?)\n\}`)
+var byteRE = regexp.MustCompile(`0x[0-9a-f]{2}`)
+
+// embeddedDescriptor 解析生成代码中内嵌的 gzip 压缩的 FileDescriptorProto
+func embeddedDescriptor(t *testing.T, src string) *descriptorpb.FileDescriptorProto {
+	matched := descriptorRE.FindStringSubmatch(src)
+	if matched == nil {
+		t.Fatal("file descriptor not found")
+	}
+
+	var gz []byte
+	for _, line := range strings.Split(matched[1], "\n") {
+		if strings.Contains(line, "//") {
+			continue
+		}
+		for _, h := range byteRE.FindAllString(line, -1) {
+			v, _ := strconv.ParseUint(h[2:], 16, 8)
+			gz = append(gz, byte(v))
+		}
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd := &descriptorpb.FileDescriptorProto{}
+	if err := proto.Unmarshal(b, fd); err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
@@ -286,6 +286,20 @@ func getTemplateInfo(field protogen.Field, r Rule) (s string) {
 	return buf.String()
 }
 
+// Rules 返回字段注释中的校验规则
+func Rules(field *protogen.Field) []Rule {
+	return getRules(field.Comments)
+}
+
+// RangeBounds 解析 range 规则，返回上下限以及是否包含边界
+func RangeBounds(value string) (min, max string, minInclusive, maxInclusive bool, ok bool) {
+	matched := regexp.MustCompile(`(\(|\[)(.+),(.+)(\)|\])`).FindStringSubmatch(value)
+	if len(matched) < 5 {
+		return
+	}
+	return strings.TrimSpace(matched[2]), strings.TrimSpace(matched[3]), matched[1] == "[", matched[4] == "]", true
+}
+
 // getRules 返回了每行符合正则的 rules 数组
 func getRules(cs protogen.CommentSet) (rs []Rule) {
 	ops := make([]string, 0, len(tienum))
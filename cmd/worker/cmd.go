@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg"
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/memdb/queue"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+)
+
+type workerInfo struct {
+	queue   string
+	handler queue.Handler
+	opts    []queue.Option
+}
+
+var workers = map[string]*workerInfo{}
+
+var port int
+
+func init() {
+	Cmd.Flags().IntVar(&port, "port", 8080, "metrics listen port")
+}
+
+// Cmd consume queue messages
+var Cmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Run queue worker",
+	Long: `You can list all queues and consume certain one once.
+If you run worker cmd WITHOUT any sub cmd, all queues will be consumed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		server := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+
+			http.HandleFunc("/monitor/ping", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("pong"))
+			})
+
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				panic(err)
+			}
+		}()
+
+		conf.OnConfigChange(func() { pkg.Reset() })
+		conf.WatchConfig()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var wg sync.WaitGroup
+		for _, w := range workers {
+			wg.Add(1)
+			go func(w *workerInfo) {
+				defer wg.Done()
+				w.run(ctx)
+			}(w)
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+		<-stop
+
+		// 停止拉取新消息，等待处理中的消息完成
+		cancel()
+		wg.Wait()
+
+		if err := server.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	},
+}
+
+var cmdList = &cobra.Command{
+	Use:   "list",
+	Short: "List all queues",
+	Long:  `List all queues.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		names := make([]string, 0, len(workers))
+		for k := range workers {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, k := range names {
+			fmt.Println(k)
+		}
+	},
+}
+
+var cmdOnce = &cobra.Command{
+	Use:   "once queue",
+	Short: "Consume queue once",
+	Long:  `Handle all available messages of the queue and exit.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		w := workers[name]
+		if w == nil {
+			fmt.Printf("queue %s not found\n", name)
+			os.Exit(1)
+		}
+
+		c, err := w.consumer()
+		if err != nil {
+			fmt.Printf("%+v\n", err)
+			os.Exit(1)
+		}
+
+		n, err := c.Once(context.Background())
+		fmt.Printf("handled %d messages of %s\n", n, name)
+		if err != nil {
+			fmt.Printf("%+v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	Cmd.AddCommand(
+		cmdList,
+		cmdOnce,
+	)
+}
+
+func (w *workerInfo) consumer() (*queue.Consumer, error) {
+	opts := append([]queue.Option{queue.WithPause(w.paused)}, w.opts...)
+	return queue.OpenConsumer(w.queue, w.handler, opts...)
+}
+
+// run 持续消费队列，直到 ctx 结束
+//
+// 创建消费者失败（比如 memdb 配置错误）或者 Run 出错（比如启动时 redis 不可用）后
+// 按指数退避重试，最长间隔 1 分钟，避免进程还在但队列已经停止消费
+func (w *workerInfo) run(ctx context.Context) {
+	var c *queue.Consumer
+	backoff := time.Second
+	for {
+		var err error
+		if c == nil {
+			c, err = w.consumer()
+		}
+		if err == nil {
+			err = c.Run(ctx)
+		}
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		log.Get(ctx).Errorf("worker %s error, retry in %v: %+v", w.queue, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// paused 暂停开关，WORKER_PAUSE 暂停所有队列，WORKER_PAUSE_queue 暂停单个队列
+func (w *workerInfo) paused() bool {
+	return conf.GetBool("WORKER_PAUSE") || conf.GetBool("WORKER_PAUSE_"+w.queue)
+}
+
+// worker 注册队列处理函数，opts 参考 queue 包的 Option
+func worker(name string, h queue.Handler, opts ...queue.Option) {
+	if _, ok := workers[name]; ok {
+		panic(name + " is used")
+	}
+
+	workers[name] = &workerInfo{queue: name, handler: h, opts: opts}
+}
@@ -0,0 +1,15 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kiss/sniper/pkg/memdb/queue"
+)
+
+func init() {
+	worker("foo", func(ctx context.Context, m *queue.Message) error {
+		fmt.Printf("handle foo %s: %s\n", m.ID, m.Payload)
+		return nil
+	}, queue.WithConcurrency(2))
+}
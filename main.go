@@ -5,6 +5,7 @@ import (
 
 	"sniper/cmd/cron"
 	"sniper/cmd/http"
+	"sniper/cmd/worker"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +16,7 @@ func main() {
 	root.AddCommand(
 		cron.Cmd,
 		http.Cmd,
+		worker.Cmd,
 	)
 
 	root.Execute()
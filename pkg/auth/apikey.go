@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+// verifyAPIKey 校验静态密钥
+//
+// 密钥通过 AUTH_API_KEYS 配置，格式为 名称:密钥，多个使用逗号分隔
+func verifyAPIKey(key string) (*Principal, error) {
+	for _, item := range strings.Split(conf.Get("AUTH_API_KEYS"), ",") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || secret == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(key)) == 1 {
+			return &Principal{Subject: name, Type: TypeAPIKey, Roles: roles(name)}, nil
+		}
+	}
+
+	return nil, invalid("unknown api key")
+}
@@ -0,0 +1,136 @@
+// Package auth 服务端认证组件
+//
+// 支持三种凭证，按请求头自动识别：
+//   - Authorization: Bearer <jwt>，HS256/384/512 或 RS256/384/512 签名
+//   - Authorization: HMAC <key>:<signature>，配合 X-Timestamp 请求头防重放
+//   - X-API-Key: <key>，静态密钥
+//
+// 使用示例：
+//
+//	p, err := auth.Authenticate(req)
+//	ctx = auth.WithPrincipal(ctx, p)
+//
+//	p, ok := auth.FromContext(ctx)
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+var (
+	// ErrNoCredentials 请求没有携带凭证
+	ErrNoCredentials = errors.New("auth: no credentials")
+	// ErrInvalidCredentials 凭证无效或者已过期
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+	// ErrPermissionDenied 调用方没有权限
+	ErrPermissionDenied = errors.New("auth: permission denied")
+)
+
+// 凭证类型
+const (
+	TypeJWT    = "jwt"
+	TypeHMAC   = "hmac"
+	TypeAPIKey = "apikey"
+)
+
+// Principal 认证通过的调用方
+type Principal struct {
+	// Subject 用户或者服务标识，jwt 为 sub，hmac 和 api key 为密钥名
+	Subject string
+	// Type 凭证类型
+	Type string
+	// Roles 角色，jwt 读取 roles 声明，其他类型读取 AUTH_ROLES_密钥名 配置
+	Roles []string
+	// Claims jwt 的全部声明
+	Claims map[string]any
+}
+
+// HasRole 判断是否拥有某个角色
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal 保存调用方
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext 读取调用方
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Authenticate 根据请求头校验凭证
+//
+// 请求没有携带任何凭证时返回 ErrNoCredentials，凭证无效时返回的错误包含 ErrInvalidCredentials
+func Authenticate(r *http.Request) (*Principal, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, cred, _ := strings.Cut(h, " ")
+		switch strings.ToLower(scheme) {
+		case "bearer":
+			return verifyJWT(strings.TrimSpace(cred))
+		case "hmac":
+			return verifyHMAC(r, strings.TrimSpace(cred))
+		}
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return verifyAPIKey(key)
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// Authorize 校验调用方是否满足策略
+//
+// 策略为空或者 any 时只要求认证通过，否则为角色列表，多个角色使用 | 分隔，
+// 调用方拥有其中任意一个角色即可。
+func Authorize(p *Principal, policy string) error {
+	if p == nil {
+		return ErrNoCredentials
+	}
+	if policy == "" || policy == "any" {
+		return nil
+	}
+
+	for _, role := range strings.Split(policy, "|") {
+		if p.HasRole(role) {
+			return nil
+		}
+	}
+	return ErrPermissionDenied
+}
+
+// invalid 包装凭证错误，方便使用 errors.Is 判断
+func invalid(reason string) error {
+	return &authError{reason: reason}
+}
+
+type authError struct {
+	reason string
+}
+
+func (e *authError) Error() string { return "auth: invalid credentials, " + e.reason }
+func (e *authError) Unwrap() error { return ErrInvalidCredentials }
+
+// roles 读取非 jwt 调用方的角色配置，多个角色使用 | 分隔
+func roles(subject string) []string {
+	v := conf.Get("AUTH_ROLES_" + subject)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, "|")
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+func sign(t *testing.T, alg string, claims map[string]any, key any) string {
+	h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest("POST", "/foo", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWT(t *testing.T) {
+	conf.Set("AUTH_JWT_HS_KEY", "secret")
+	conf.Set("AUTH_JWT_AUDIENCE", "sniper")
+	defer conf.Set("AUTH_JWT_HS_KEY", "")
+	defer conf.Set("AUTH_JWT_AUDIENCE", "")
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := sign(t, "HS256", map[string]any{
+		"sub": "u1", "exp": exp, "aud": "sniper", "roles": []string{"admin"},
+	}, []byte("secret"))
+
+	p, err := Authenticate(bearer(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "u1" || p.Type != TypeJWT || !p.HasRole("admin") {
+		t.Fatal("invalid principal", p)
+	}
+
+	cases := map[string]string{
+		"bad signature": sign(t, "HS256", map[string]any{"sub": "u1", "aud": "sniper"}, []byte("foo")),
+		"expired":       sign(t, "HS256", map[string]any{"sub": "u1", "aud": "sniper", "exp": float64(time.Now().Add(-time.Hour).Unix())}, []byte("secret")),
+		"bad audience":  sign(t, "HS256", map[string]any{"sub": "u1", "aud": "foo", "exp": exp}, []byte("secret")),
+		"alg none":      sign(t, "none", map[string]any{"sub": "u1", "aud": "sniper", "exp": exp}, nil),
+		"malformed":     "foo.bar",
+		"no exp":        sign(t, "HS256", map[string]any{"sub": "u1", "aud": "sniper"}, []byte("secret")),
+		"string exp":    sign(t, "HS256", map[string]any{"sub": "u1", "aud": "sniper", "exp": "9999999999"}, []byte("secret")),
+		"string nbf":    sign(t, "HS256", map[string]any{"sub": "u1", "aud": "sniper", "exp": exp, "nbf": "0"}, []byte("secret")),
+	}
+	for name, token := range cases {
+		if _, err := Authenticate(bearer(token)); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatal(name, err)
+		}
+	}
+
+	// 关闭 exp 校验后允许不包含 exp，但仍然拒绝格式错误的 exp
+	conf.Set("AUTH_JWT_REQUIRE_EXP", false)
+	defer conf.Set("AUTH_JWT_REQUIRE_EXP", "")
+	if _, err := Authenticate(bearer(cases["no exp"])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(bearer(cases["string exp"])); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	conf.Set("AUTH_JWT_RS_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	defer conf.Set("AUTH_JWT_RS_KEY", "")
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	p, err := Authenticate(bearer(sign(t, "RS256", map[string]any{"sub": "u2", "roles": "a b", "exp": exp}, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "u2" || !p.HasRole("b") {
+		t.Fatal("invalid principal", p)
+	}
+
+	// HS 密钥未配置，不能用公钥冒充 HS 密钥
+	token := sign(t, "HS256", map[string]any{"sub": "u2", "exp": exp}, []byte(conf.Get("AUTH_JWT_RS_KEY")))
+	if _, err := Authenticate(bearer(token)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal(err)
+	}
+
+	// 更换公钥后旧密钥签发的 jwt 失效，缓存中只保留新的公钥
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ = x509.MarshalPKIXPublicKey(&key2.PublicKey)
+	conf.Set("AUTH_JWT_RS_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if _, err := Authenticate(bearer(sign(t, "RS256", map[string]any{"sub": "u2", "exp": exp}, key))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(bearer(sign(t, "RS256", map[string]any{"sub": "u2", "exp": exp}, key2))); err != nil {
+		t.Fatal(err)
+	}
+	if !rsaCache.key.Equal(&key2.PublicKey) {
+		t.Fatal("only the current key should be cached")
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	conf.Set("AUTH_HMAC_SECRET_svc", "secret")
+	conf.Set("AUTH_ROLES_svc", "internal|ops")
+
+	body := []byte(`{"msg":"hi"}`)
+	client, _ := http.NewRequest("POST", "http://example.com/api/foo?a=1", bytes.NewReader(body))
+	if err := SignRequest(client, "svc", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟服务端收到的请求
+	newServerReq := func() *http.Request {
+		r := httptest.NewRequest("POST", "/api/foo?a=1", bytes.NewReader(body))
+		r.Header = client.Header.Clone()
+		return r
+	}
+
+	p, err := Authenticate(newServerReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "svc" || p.Type != TypeHMAC || !p.HasRole("ops") {
+		t.Fatal("invalid principal", p)
+	}
+
+	r := newServerReq()
+	r.Body = http.NoBody
+	if _, err := Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("body should be signed", err)
+	}
+
+	r = newServerReq()
+	r.Header.Set("X-Timestamp", "1")
+	if _, err := Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("timestamp should be checked", err)
+	}
+}
+
+func TestHMACNonce(t *testing.T) {
+	conf.Set("AUTH_HMAC_SECRET_svc", "secret")
+	conf.Set("MEMDB_DSN_auth_nonce", "mem://auth_nonce")
+	conf.Set("AUTH_HMAC_MEMDB", "auth_nonce")
+	defer conf.Set("AUTH_HMAC_MEMDB", "")
+
+	client, _ := http.NewRequest("POST", "http://example.com/foo", bytes.NewReader([]byte("hi")))
+	if err := SignRequest(client, "svc", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	newServerReq := func() *http.Request {
+		r := httptest.NewRequest("POST", "/foo", bytes.NewReader([]byte("hi")))
+		r.Header = client.Header.Clone()
+		return r
+	}
+
+	if _, err := Authenticate(newServerReq()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(newServerReq()); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("replayed request should be rejected", err)
+	}
+
+	// nonce 参与签名，不能替换
+	r := newServerReq()
+	r.Header.Set("X-Nonce", "foo")
+	if _, err := Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("nonce should be signed", err)
+	}
+
+	// 没有 nonce 的旧格式签名
+	r = newServerReq()
+	r.Header.Del("X-Nonce")
+	r.Header.Set("Authorization", "HMAC svc:"+mustSignature(t, r, "secret"))
+	if _, err := Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("nonce should be required", err)
+	}
+	conf.Set("AUTH_HMAC_MEMDB", "")
+	r = newServerReq()
+	r.Header.Del("X-Nonce")
+	r.Header.Set("Authorization", "HMAC svc:"+mustSignature(t, r, "secret"))
+	if _, err := Authenticate(r); err != nil {
+		t.Fatal("nonce is optional without memdb", err)
+	}
+}
+
+func TestHMACMaxBody(t *testing.T) {
+	conf.Set("AUTH_HMAC_SECRET_svc", "secret")
+	conf.Set("AUTH_HMAC_MAX_BODY", 4)
+	defer conf.Set("AUTH_HMAC_MAX_BODY", 0)
+
+	for _, c := range []struct {
+		body string
+		ok   bool
+	}{{"1234", true}, {"12345", false}} {
+		r := httptest.NewRequest("POST", "/foo", bytes.NewReader([]byte(c.body)))
+		if err := SignRequest(r, "svc", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Authenticate(r); (err == nil) != c.ok {
+			t.Fatal(c.body, err)
+		}
+	}
+}
+
+func mustSignature(t *testing.T, r *http.Request, secret string) string {
+	sig, err := signature(r, secret, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestAPIKey(t *testing.T) {
+	conf.Set("AUTH_API_KEYS", "foo:k1, bar:k2")
+
+	r := httptest.NewRequest("POST", "/foo", nil)
+	r.Header.Set("X-API-Key", "k2")
+	p, err := Authenticate(r)
+	if err != nil || p.Subject != "bar" || p.Type != TypeAPIKey {
+		t.Fatal(p, err)
+	}
+
+	r.Header.Set("X-API-Key", "k3")
+	if _, err := Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal(err)
+	}
+
+	if _, err := Authenticate(httptest.NewRequest("POST", "/foo", nil)); err != ErrNoCredentials {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p := &Principal{Subject: "u1", Roles: []string{"editor"}}
+
+	if err := Authorize(p, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Authorize(p, "admin|editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Authorize(p, "admin"); err != ErrPermissionDenied {
+		t.Fatal(err)
+	}
+	if err := Authorize(nil, "any"); err != ErrNoCredentials {
+		t.Fatal(err)
+	}
+
+	ctx := WithPrincipal(context.Background(), p)
+	if got, ok := FromContext(ctx); !ok || got != p {
+		t.Fatal("principal should be stored")
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/memdb"
+)
+
+// 默认参与签名的请求体最大字节数
+const defaultHMACMaxBody = 10 << 20
+
+// verifyHMAC 校验签名请求
+//
+// 签名密钥通过 AUTH_HMAC_SECRET_密钥名 配置。X-Timestamp 与服务器时间相差超过
+// AUTH_HMAC_SKEW（默认 5 分钟）的请求会被拒绝。
+//
+// 没有配置 AUTH_HMAC_MEMDB 时只能通过时间戳限制重放，签名后的请求在误差范围内可以重复使用。
+// 配置后请求必须携带 X-Nonce，同一个 nonce 在误差范围内只能使用一次。
+func verifyHMAC(r *http.Request, cred string) (*Principal, error) {
+	key, sig, ok := strings.Cut(cred, ":")
+	if !ok || key == "" {
+		return nil, invalid("malformed hmac credentials")
+	}
+
+	secret := conf.Get("AUTH_HMAC_SECRET_" + key)
+	if secret == "" {
+		return nil, invalid("unknown hmac key " + key)
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, invalid("bad timestamp")
+	}
+	skew := conf.GetDuration("AUTH_HMAC_SKEW")
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > skew || d < -skew {
+		return nil, invalid("timestamp out of range")
+	}
+
+	maxBody := conf.GetInt64("AUTH_HMAC_MAX_BODY")
+	if maxBody <= 0 {
+		maxBody = defaultHMACMaxBody
+	}
+	want, err := signature(r, secret, maxBody)
+	if err != nil {
+		return nil, invalid("read body: " + err.Error())
+	}
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return nil, invalid("bad hmac signature")
+	}
+
+	// 签名校验通过之后再记录 nonce，避免伪造的请求占用 nonce
+	if db := conf.Get("AUTH_HMAC_MEMDB"); db != "" {
+		nonce := r.Header.Get("X-Nonce")
+		if nonce == "" {
+			return nil, invalid("nonce required")
+		}
+		ok, err := memdb.Get(db).SetNX(r.Context(), "auth:hmac:"+key+":"+nonce, 1, 2*skew).Result()
+		if err != nil {
+			return nil, fmt.Errorf("auth: check hmac nonce: %w", err)
+		}
+		if !ok {
+			return nil, invalid("replayed request")
+		}
+	}
+
+	return &Principal{Subject: key, Type: TypeHMAC, Roles: roles(key)}, nil
+}
+
+// SignRequest 为请求签名，需要在设置完请求体和请求头之后调用
+//
+// 签名内容为请求方法、请求 URI（路径和查询参数）、时间戳、请求体摘要和 nonce，使用换行符拼接：
+//
+//	hex(hmac-sha256(secret, method + "\n" + uri + "\n" + timestamp + "\n" + hex(sha256(body)) + "\n" + nonce))
+//
+// 请求头没有 X-Nonce 时签名内容不包含最后的换行符和 nonce。
+func SignRequest(r *http.Request, key, secret string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	r.Header.Set("X-Nonce", hex.EncodeToString(b))
+	r.Header.Set("X-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+
+	sig, err := signature(r, secret, 0)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Authorization", "HMAC "+key+":"+sig)
+	return nil
+}
+
+// signature 计算签名，读取后会重新放回请求体
+//
+// maxBody 大于 0 时请求体超过 maxBody 字节返回错误
+func signature(r *http.Request, secret string, maxBody int64) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var br io.Reader = r.Body
+		if maxBody > 0 {
+			br = io.LimitReader(r.Body, maxBody+1)
+		}
+
+		var err error
+		if body, err = io.ReadAll(br); err != nil {
+			return "", err
+		}
+		if int64(len(body)) > maxBody && maxBody > 0 {
+			return "", fmt.Errorf("body exceeds %d bytes", maxBody)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	sum := sha256.Sum256(body)
+
+	// 服务端使用原始的 RequestURI，不受 http.StripPrefix 影响
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	msg := r.Method + "\n" + uri + "\n" + r.Header.Get("X-Timestamp") + "\n" + hex.EncodeToString(sum[:])
+	if nonce := r.Header.Get("X-Nonce"); nonce != "" {
+		msg += "\n" + nonce
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
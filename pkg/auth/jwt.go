@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+// 校验 exp 和 nbf 时允许的时钟误差
+const leeway = 30 * time.Second
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// 只缓存当前配置的公钥，配置变更后重新解析并替换
+var rsaCache struct {
+	sync.Mutex
+	data string
+	key  *rsa.PublicKey
+}
+
+// verifyJWT 校验 jwt
+//
+// HS 算法密钥通过 AUTH_JWT_HS_KEY 配置，RS 算法公钥通过 AUTH_JWT_RS_KEY 配置（PEM 格式，
+// 支持公钥和证书）。配置了 AUTH_JWT_ISSUER 或 AUTH_JWT_AUDIENCE 时会校验 iss 和 aud。
+// 默认要求 jwt 包含 exp，配置 AUTH_JWT_REQUIRE_EXP = false 可以关闭。
+func verifyJWT(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("malformed jwt")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed jwt header")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed jwt signature")
+	}
+
+	if err := verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("malformed jwt claims")
+	}
+
+	if err := validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return &Principal{
+		Subject: sub,
+		Type:    TypeJWT,
+		Roles:   stringList(claims["roles"]),
+		Claims:  claims,
+	}, nil
+}
+
+func verifySignature(alg, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return invalid("unsupported jwt alg " + alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return invalid("unsupported jwt alg " + alg)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		key := conf.Get("AUTH_JWT_HS_KEY")
+		if key == "" {
+			return invalid("jwt alg " + alg + " not enabled")
+		}
+		mac := hmac.New(hash.New, []byte(key))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return invalid("bad jwt signature")
+		}
+		return nil
+	case "RS":
+		key, err := rsaKey(conf.Get("AUTH_JWT_RS_KEY"))
+		if err != nil {
+			return invalid("jwt alg " + alg + " not enabled: " + err.Error())
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig); err != nil {
+			return invalid("bad jwt signature")
+		}
+		return nil
+	default:
+		return invalid("unsupported jwt alg " + alg)
+	}
+}
+
+func rsaKey(data string) (*rsa.PublicKey, error) {
+	if data == "" {
+		return nil, errors.New("no key")
+	}
+
+	rsaCache.Lock()
+	defer rsaCache.Unlock()
+	if rsaCache.data == data {
+		return rsaCache.key, nil
+	}
+
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+
+	var pub any
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not a rsa key")
+	}
+
+	rsaCache.data, rsaCache.key = data, key
+	return key, nil
+}
+
+func validateClaims(claims map[string]any) error {
+	now := time.Now()
+
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok && requireExp() {
+		return invalid("jwt without exp")
+	}
+	if ok && now.After(exp.Add(leeway)) {
+		return invalid("jwt expired")
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(nbf) {
+		return invalid("jwt not valid yet")
+	}
+
+	if iss := conf.Get("AUTH_JWT_ISSUER"); iss != "" && claims["iss"] != iss {
+		return invalid("bad jwt issuer")
+	}
+
+	if aud := conf.Get("AUTH_JWT_AUDIENCE"); aud != "" {
+		found := false
+		for _, a := range stringList(claims["aud"]) {
+			if a == aud {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalid("bad jwt audience")
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// requireExp 是否要求 jwt 包含 exp，未配置时默认要求
+func requireExp() bool {
+	return conf.Get("AUTH_JWT_REQUIRE_EXP") == "" || conf.GetBool("AUTH_JWT_REQUIRE_EXP")
+}
+
+// numericDate 读取时间声明，不存在返回 false，存在但不是数字返回 error
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	f, ok := v.(float64)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, false, invalid("malformed jwt " + name)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true, nil
+}
+
+// stringList 兼容字符串数组和空格分隔的字符串
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		l := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				l = append(l, s)
+			}
+		}
+		return l
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,407 @@
+// Package cache 基于 memdb 实现带回源功能的缓存
+//
+//	users := cache.New("user", memdb.Get("foo"), loadUser, cache.WithTTL(time.Hour))
+//	u, err := users.Get(ctx, 1)
+package cache
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound 数据不存在
+//
+// 回源函数返回 ErrNotFound 时会缓存空结果，防止缓存穿透
+var ErrNotFound = errors.New("cache: not found")
+
+type options struct {
+	prefix      string
+	ttl         time.Duration
+	negativeTTL time.Duration
+	jitter      float64
+	beta        float64
+	loadTimeout time.Duration
+	codec       Codec
+	localSize   int
+	localTTL    time.Duration
+}
+
+// Option 缓存配置
+type Option func(*options)
+
+// WithTTL 设置缓存有效期，默认一分钟
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) { o.ttl = ttl }
+}
+
+// WithNegativeTTL 设置空结果的有效期，默认 10 秒，为零则不缓存空结果
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(o *options) { o.negativeTTL = ttl }
+}
+
+// WithJitter 设置有效期随机浮动比例，默认 0.1，即上下浮动 10%
+//
+// 避免同时写入的大量缓存同时过期。取值范围为 [0, 1)，超出范围会被截断，
+// 否则有效期可能为零或者负数。
+func WithJitter(jitter float64) Option {
+	return func(o *options) { o.jitter = math.Min(math.Max(jitter, 0), maxJitter) }
+}
+
+// maxJitter 最大浮动比例，保证有效期始终大于零
+const maxJitter = 0.99
+
+// WithLoadTimeout 设置回源超时时间，默认 10 秒，为零则不限制
+//
+// 同一个 key 的并发请求共用一次回源，回源不受单个请求的 ctx 取消影响，
+// 只受该超时限制。
+func WithLoadTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.loadTimeout = timeout }
+}
+
+// WithEarlyRefresh 设置提前刷新系数，默认 1，为零则不提前刷新
+//
+// 缓存过期前会以一定概率在后台提前回源，离过期时间越近、回源耗时越长，
+// 提前刷新的概率越大，参考 https://en.wikipedia.org/wiki/Cache_stampede
+func WithEarlyRefresh(beta float64) Option {
+	return func(o *options) { o.beta = beta }
+}
+
+// WithCodec 设置序列化方式，默认为 JSON
+func WithCodec(c Codec) Option {
+	return func(o *options) { o.codec = c }
+}
+
+// WithLocal 在 memdb 前面增加一层进程内 LRU 缓存
+//
+// size 为最多缓存的数量，ttl 为本地缓存的最长有效期。
+// 调用 Set/Delete 会通过 redis pub/sub 通知其他实例删除本地缓存，
+// 回源和提前刷新不会通知，其他实例最多读到 ttl 时间之前的数据。
+// 本地缓存直接返回同一个对象，调用方不能修改返回值。
+func WithLocal(size int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.localSize = size
+		o.localTTL = ttl
+	}
+}
+
+// WithPrefix 设置缓存 key 前缀，默认为缓存名
+func WithPrefix(prefix string) Option {
+	return func(o *options) { o.prefix = prefix }
+}
+
+// Loader 带回源功能的缓存
+//
+// 缓存不存在时调用回源函数加载数据并写入缓存，
+// 同一个 key 同时只会有一个请求回源。
+type Loader[K comparable, V any] struct {
+	name string
+	db   *memdb.Client
+	load func(context.Context, K) (V, error)
+	opts options
+	sfg  singleflight.Group
+
+	local *local[V]
+	// 实例标识，忽略自己发出的失效通知
+	id string
+	ps *redis.PubSub
+}
+
+// New 创建缓存，name 用于区分监控指标，默认也作为 key 前缀
+func New[K comparable, V any](name string, db *memdb.Client,
+	load func(context.Context, K) (V, error), opts ...Option) *Loader[K, V] {
+	l := &Loader[K, V]{
+		name: name,
+		db:   db,
+		load: load,
+		opts: options{
+			prefix:      name,
+			ttl:         time.Minute,
+			negativeTTL: 10 * time.Second,
+			jitter:      0.1,
+			beta:        1,
+			loadTimeout: 10 * time.Second,
+			codec:       JSON,
+		},
+	}
+
+	for _, o := range opts {
+		o(&l.opts)
+	}
+
+	if l.opts.localSize > 0 && l.opts.localTTL > 0 {
+		l.local = newLocal[V](l.opts.localSize, l.opts.localTTL)
+		l.id = newID()
+		if db != nil {
+			l.subscribe()
+		}
+	}
+
+	return l
+}
+
+// Close 停止接收失效通知，只有开启本地缓存时需要调用
+func (l *Loader[K, V]) Close() error {
+	if l.ps == nil {
+		return nil
+	}
+	return l.ps.Close()
+}
+
+// Key 返回缓存 key，格式为 prefix:k
+func (l *Loader[K, V]) Key(k K) string {
+	return fmt.Sprintf("%s:%v", l.opts.prefix, k)
+}
+
+// Get 查询缓存，缓存不存在则回源
+//
+// 数据不存在时返回 ErrNotFound，redis 出错时直接回源
+func (l *Loader[K, V]) Get(ctx context.Context, k K) (V, error) {
+	var zero V
+	key := l.Key(k)
+
+	if l.local != nil {
+		if item, ok := l.local.get(key); ok {
+			if item.notFound {
+				cacheRequests.WithLabelValues(l.name, "local_negative_hit").Inc()
+				return zero, ErrNotFound
+			}
+			cacheRequests.WithLabelValues(l.name, "local_hit").Inc()
+			return item.v, nil
+		}
+	}
+
+	b, err := l.db.Get(ctx, key).Bytes()
+	if err == nil {
+		var e entry
+		var v V
+		if err = e.decode(b); err == nil && !e.notFound {
+			err = l.opts.codec.Unmarshal(e.data, &v)
+		}
+		if err == nil {
+			l.setLocal(key, v, e.notFound, time.Until(e.expiry))
+			if e.notFound {
+				cacheRequests.WithLabelValues(l.name, "negative_hit").Inc()
+				return zero, ErrNotFound
+			}
+			cacheRequests.WithLabelValues(l.name, "hit").Inc()
+			if l.shouldRefresh(&e) {
+				l.refresh(ctx, k, key)
+			}
+			return v, nil
+		}
+	}
+
+	if err == redis.Nil {
+		cacheRequests.WithLabelValues(l.name, "miss").Inc()
+	} else {
+		cacheRequests.WithLabelValues(l.name, "error").Inc()
+		log.Get(ctx).Warnf("[cache] name:%s, key:%s, get error:%v", l.name, key, err)
+	}
+
+	r, err, _ := l.sfg.Do(key, func() (any, error) {
+		ctx, cancel := l.loadContext(ctx)
+		defer cancel()
+		return l.loadAndSet(ctx, k, key)
+	})
+	if err == ErrNotFound && l.opts.negativeTTL > 0 {
+		l.setLocal(key, zero, true, l.opts.negativeTTL)
+	}
+	if err != nil {
+		return zero, err
+	}
+	v, _ := r.(V)
+	l.setLocal(key, v, false, l.opts.ttl)
+	return v, nil
+}
+
+// Set 直接写入缓存
+func (l *Loader[K, V]) Set(ctx context.Context, k K, v V) error {
+	data, err := l.opts.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	key := l.Key(k)
+	if err := l.set(ctx, key, &entry{data: data}, l.opts.ttl); err != nil {
+		return err
+	}
+	l.invalidate(ctx, key)
+	return nil
+}
+
+// Delete 删除缓存，一般在更新数据之后调用
+func (l *Loader[K, V]) Delete(ctx context.Context, k K) error {
+	key := l.Key(k)
+	if err := l.db.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+	l.invalidate(ctx, key)
+	return nil
+}
+
+func (l *Loader[K, V]) setLocal(key string, v V, notFound bool, ttl time.Duration) {
+	if l.local != nil {
+		l.local.set(key, v, notFound, ttl)
+	}
+}
+
+// invalidate 删除本地缓存并通知其他实例
+func (l *Loader[K, V]) invalidate(ctx context.Context, key string) {
+	if l.local == nil {
+		return
+	}
+
+	l.local.del(key)
+	err := l.db.Publish(ctx, l.channel(), l.id+" "+key).Err()
+	if err != nil {
+		log.Get(ctx).Warnf("[cache] name:%s, key:%s, publish error:%v", l.name, key, err)
+	}
+}
+
+func (l *Loader[K, V]) channel() string {
+	return "cache:invalidate:" + l.name
+}
+
+// subscribe 接收其他实例的失效通知，连接断开后 go-redis 会自动重新订阅
+func (l *Loader[K, V]) subscribe() {
+	l.ps = l.db.Subscribe(context.Background(), l.channel())
+
+	go func() {
+		for msg := range l.ps.Channel() {
+			id, key, ok := strings.Cut(msg.Payload, " ")
+			if !ok || id == l.id {
+				continue
+			}
+			l.local.del(key)
+			cacheInvalidations.WithLabelValues(l.name).Inc()
+		}
+	}()
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (l *Loader[K, V]) loadAndSet(ctx context.Context, k K, key string) (V, error) {
+	s := time.Now()
+	v, err := l.load(ctx, k)
+	d := time.Since(s)
+
+	cacheLoadDurations.WithLabelValues(l.name).Observe(d.Seconds())
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		cacheLoads.WithLabelValues(l.name, "not_found").Inc()
+		if l.opts.negativeTTL > 0 {
+			l.set(ctx, key, &entry{notFound: true, delta: d}, l.opts.negativeTTL)
+		}
+		return v, ErrNotFound
+	case err != nil:
+		cacheLoads.WithLabelValues(l.name, "error").Inc()
+		return v, err
+	}
+	cacheLoads.WithLabelValues(l.name, "ok").Inc()
+
+	data, err := l.opts.codec.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+	l.set(ctx, key, &entry{data: data, delta: d}, l.opts.ttl)
+
+	return v, nil
+}
+
+// set 写入缓存，失败只记录日志
+func (l *Loader[K, V]) set(ctx context.Context, key string, e *entry, ttl time.Duration) error {
+	if j := l.opts.jitter; j > 0 {
+		ttl = time.Duration(float64(ttl) * (1 + j*(rand.Float64()*2-1)))
+	}
+	e.expiry = time.Now().Add(ttl)
+
+	err := l.db.Set(ctx, key, e.encode(), ttl).Err()
+	if err != nil {
+		log.Get(ctx).Warnf("[cache] name:%s, key:%s, set error:%v", l.name, key, err)
+	}
+	return err
+}
+
+// shouldRefresh 判断是否需要提前刷新，即 XFetch 算法
+func (l *Loader[K, V]) shouldRefresh(e *entry) bool {
+	if l.opts.beta <= 0 || e.delta <= 0 {
+		return false
+	}
+
+	gap := -float64(e.delta) * l.opts.beta * math.Log(rand.Float64())
+	return time.Now().Add(time.Duration(gap)).After(e.expiry)
+}
+
+// refresh 在后台回源，不影响当前请求
+func (l *Loader[K, V]) refresh(ctx context.Context, k K, key string) {
+	cacheRequests.WithLabelValues(l.name, "refresh").Inc()
+
+	l.sfg.DoChan(key, func() (any, error) {
+		ctx, cancel := l.loadContext(ctx)
+		defer cancel()
+		return l.loadAndSet(ctx, k, key)
+	})
+}
+
+// loadContext 返回回源使用的 ctx
+//
+// 回源结果由多个请求共享，不能因为发起回源的请求取消而失败，
+// 所以去掉原 ctx 的取消信号，改用 loadTimeout 限制回源时间。
+func (l *Loader[K, V]) loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if l.opts.loadTimeout > 0 {
+		return context.WithTimeout(ctx, l.opts.loadTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// entry 缓存内容，在数据前面保存过期时间和回源耗时，用于提前刷新
+//
+// | notFound(1) | expiry(8) | delta(8) | data |
+type entry struct {
+	notFound bool
+	expiry   time.Time
+	delta    time.Duration
+	data     []byte
+}
+
+const headerSize = 17
+
+func (e *entry) encode() []byte {
+	b := make([]byte, headerSize, headerSize+len(e.data))
+	if e.notFound {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint64(b[1:], uint64(e.expiry.UnixNano()))
+	binary.BigEndian.PutUint64(b[9:], uint64(e.delta))
+	return append(b, e.data...)
+}
+
+func (e *entry) decode(b []byte) error {
+	if len(b) < headerSize || b[0] > 1 {
+		return errors.New("cache: invalid entry")
+	}
+	e.notFound = b[0] == 1
+	e.expiry = time.Unix(0, int64(binary.BigEndian.Uint64(b[1:])))
+	e.delta = time.Duration(binary.BigEndian.Uint64(b[9:]))
+	e.data = b[headerSize:]
+	return nil
+}
@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func newDB(t *testing.T) (*memdb.Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	name := "cache_" + t.Name()
+	conf.Set("MEMDB_DSN_"+name, "redis://"+mr.Addr())
+	return memdb.Get(name), mr
+}
+
+func TestLoader(t *testing.T) {
+	db, mr := newDB(t)
+	ctx := context.Background()
+
+	var loads int32
+	load := func(ctx context.Context, id int) (*user, error) {
+		atomic.AddInt32(&loads, 1)
+		if id == 0 {
+			return nil, ErrNotFound
+		}
+		return &user{ID: id, Name: "foo"}, nil
+	}
+
+	users := New("user", db, load, WithTTL(time.Minute), WithJitter(0.1))
+
+	for i := 0; i < 2; i++ {
+		u, err := users.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.ID != 1 || u.Name != "foo" {
+			t.Fatal("invalid user", u)
+		}
+	}
+	if loads != 1 {
+		t.Fatal("invalid loads", loads)
+	}
+
+	ttl := mr.TTL("user:1")
+	if ttl < 54*time.Second || ttl > 66*time.Second {
+		t.Fatal("invalid ttl", ttl)
+	}
+
+	if err := users.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 {
+		t.Fatal("invalid loads", loads)
+	}
+
+	// 空结果也会缓存
+	for i := 0; i < 2; i++ {
+		if _, err := users.Get(ctx, 0); err != ErrNotFound {
+			t.Fatal("user should not be found", err)
+		}
+	}
+	if loads != 3 {
+		t.Fatal("invalid loads", loads)
+	}
+
+	if n := testutil.ToFloat64(cacheRequests.WithLabelValues("user", "hit")); n != 1 {
+		t.Fatal("invalid hits", n)
+	}
+	if n := testutil.ToFloat64(cacheRequests.WithLabelValues("user", "miss")); n != 3 {
+		t.Fatal("invalid misses", n)
+	}
+	if n := testutil.ToFloat64(cacheRequests.WithLabelValues("user", "negative_hit")); n != 1 {
+		t.Fatal("invalid negative hits", n)
+	}
+}
+
+func TestStampede(t *testing.T) {
+	db, _ := newDB(t)
+	ctx := context.Background()
+
+	var loads int32
+	load := func(ctx context.Context, id string) (string, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "v" + id, nil
+	}
+
+	c := New("stampede", db, load)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get(ctx, "1"); err != nil || v != "v1" {
+				t.Error(v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatal("invalid loads", loads)
+	}
+}
+
+func TestLoadCancel(t *testing.T) {
+	db, _ := newDB(t)
+
+	started := make(chan struct{})
+	load := func(ctx context.Context, id string) (string, error) {
+		close(started)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return "v" + id, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	c := New("cancel", db, load)
+
+	// 发起回源的请求取消，不影响等待同一个回源结果的其他请求
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Get(ctx, "1")
+	<-started
+	cancel()
+
+	if v, err := c.Get(context.Background(), "1"); err != nil || v != "v1" {
+		t.Fatal(v, err)
+	}
+
+	c = New("timeout", db, func(ctx context.Context, id string) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}, WithLoadTimeout(10*time.Millisecond))
+	if _, err := c.Get(context.Background(), "1"); err != context.DeadlineExceeded {
+		t.Fatal("load should time out", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for _, c := range []struct{ in, out float64 }{
+		{-1, 0}, {0.5, 0.5}, {1, maxJitter}, {2, maxJitter},
+	} {
+		var o options
+		WithJitter(c.in)(&o)
+		if o.jitter != c.out {
+			t.Fatal("invalid jitter", c.in, o.jitter)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	db, _ := newDB(t)
+	ctx := context.Background()
+
+	users := New("msgpack", db, func(ctx context.Context, id int) (user, error) {
+		return user{ID: id, Name: "bar"}, nil
+	}, WithCodec(Msgpack))
+	users.Get(ctx, 1)
+	if u, err := users.Get(ctx, 1); err != nil || u.Name != "bar" {
+		t.Fatal(u, err)
+	}
+
+	names := New("proto", db, func(ctx context.Context, id int) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("baz"), nil
+	}, WithCodec(Proto))
+	names.Get(ctx, 1)
+	if v, err := names.Get(ctx, 1); err != nil || v.GetValue() != "baz" {
+		t.Fatal(v, err)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	l := New[int, int]("refresh", nil, nil)
+
+	e := &entry{expiry: time.Now().Add(time.Hour), delta: time.Millisecond}
+	if l.shouldRefresh(e) {
+		t.Fatal("should not refresh long before expiry")
+	}
+
+	e = &entry{expiry: time.Now().Add(time.Millisecond), delta: time.Second}
+	n := 0
+	for i := 0; i < 100; i++ {
+		if l.shouldRefresh(e) {
+			n++
+		}
+	}
+	if n < 90 {
+		t.Fatal("should refresh near expiry", n)
+	}
+}
+
+func TestEntry(t *testing.T) {
+	e := &entry{expiry: time.Unix(0, 123), delta: time.Second, data: []byte("foo")}
+
+	var d entry
+	if err := d.decode(e.encode()); err != nil {
+		t.Fatal(err)
+	}
+	if d.notFound || !d.expiry.Equal(e.expiry) || d.delta != e.delta || string(d.data) != "foo" {
+		t.Fatal("invalid entry", d)
+	}
+
+	if err := d.decode([]byte("foo")); err == nil {
+		t.Fatal("invalid entry should fail")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	c := newLocal[int](2, time.Minute)
+
+	c.set("a", 1, false, 0)
+	c.set("b", 2, false, 0)
+	c.get("a")
+	c.set("c", 3, false, 0)
+
+	if _, ok := c.get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	if item, ok := c.get("a"); !ok || item.v != 1 {
+		t.Fatal("a should be kept", item)
+	}
+	if c.len() != 2 {
+		t.Fatal("invalid size", c.len())
+	}
+
+	c.set("d", 4, false, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.get("d"); ok {
+		t.Fatal("d should be expired")
+	}
+
+	c.del("a")
+	if _, ok := c.get("a"); ok {
+		t.Fatal("a should be deleted")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	db, mr := newDB(t)
+	ctx := context.Background()
+
+	var loads int32
+	load := func(ctx context.Context, id int) (string, error) {
+		atomic.AddInt32(&loads, 1)
+		return "foo", nil
+	}
+
+	// 模拟两个服务实例
+	c1 := New("local", db, load, WithLocal(100, time.Minute))
+	defer c1.Close()
+	c2 := New("local", db, load, WithLocal(100, time.Minute))
+	defer c2.Close()
+
+	for mr.PubSubNumSub("cache:invalidate:local")["cache:invalidate:local"] != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	c1.Get(ctx, 1)
+	c2.Get(ctx, 1)
+	mr.Del("local:1")
+
+	// 本地缓存命中，不访问 redis
+	if v, err := c2.Get(ctx, 1); err != nil || v != "foo" {
+		t.Fatal(v, err)
+	}
+	if loads != 1 {
+		t.Fatal("invalid loads", loads)
+	}
+
+	if err := c1.Set(ctx, 1, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := c2.Get(ctx, 1); v == "bar" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("local cache should be invalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if v, err := c1.Get(ctx, 1); err != nil || v != "bar" {
+		t.Fatal(v, err)
+	}
+	if n := testutil.ToFloat64(cacheInvalidations.WithLabelValues("local")); n != 1 {
+		t.Fatal("invalid invalidations", n)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[string](1, time.Minute)
+	c.Set("a", "1", 0)
+	c.Set("b", "2", 0)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be evicted")
+	}
+	if v, ok := c.Get("b"); !ok || v != "2" {
+		t.Fatal("invalid value", v)
+	}
+
+	c.Delete("b")
+	if c.Len() != 0 {
+		t.Fatal("b should be deleted")
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 缓存值的序列化方式
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	// Unmarshal 反序列化到 v 中，v 为指向缓存值的指针
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON 使用 encoding/json 序列化，默认方式
+	JSON Codec = jsonCodec{}
+	// Msgpack 使用 msgpack 序列化，体积更小
+	Msgpack Codec = msgpackCodec{}
+	// Proto 使用 protobuf 序列化，缓存值必须是 proto.Message
+	Proto Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cache: %T is not proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal 的 v 一般是 **pb.Foo，需要先分配消息对象
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cache: %T is not pointer", v)
+	}
+
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	e := rv.Elem()
+	if e.Kind() == reflect.Ptr && e.IsNil() {
+		e.Set(reflect.New(e.Type().Elem()))
+	}
+	m, ok := e.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("cache: %T is not proto.Message", e.Interface())
+	}
+	return proto.Unmarshal(data, m)
+}
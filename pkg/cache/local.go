@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// local 进程内 LRU 缓存，同时限制数量和有效期
+type local[V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localItem[V any] struct {
+	key      string
+	v        V
+	notFound bool
+	expiry   time.Time
+}
+
+func newLocal[V any](size int, ttl time.Duration) *local[V] {
+	return &local[V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *local[V]) get(key string) (item localItem[V], ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return item, false
+	}
+
+	item = e.Value.(localItem[V])
+	if time.Now().After(item.expiry) {
+		c.remove(e)
+		return item, false
+	}
+
+	c.ll.MoveToFront(e)
+	return item, true
+}
+
+// set 写入缓存，有效期不超过 ttl
+func (c *local[V]) set(key string, v V, notFound bool, ttl time.Duration) {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	item := localItem[V]{key: key, v: v, notFound: notFound, expiry: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value = item
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(item)
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *local[V]) del(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *local[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// remove 删除元素，调用方需要持有锁
+func (c *local[V]) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(localItem[V]).key)
+}
+
+// LRU 进程内 LRU 缓存，不回源也不同步失效，适合不需要 memdb 的场景
+//
+//	c := cache.NewLRU[[]byte](10000, time.Minute)
+//	c.Set("foo", b, 10*time.Second)
+//	b, ok := c.Get("foo")
+type LRU[V any] struct {
+	l *local[V]
+}
+
+// NewLRU 创建 LRU 缓存，size 为最大数量，ttl 为最长有效期
+func NewLRU[V any](size int, ttl time.Duration) *LRU[V] {
+	return &LRU[V]{l: newLocal[V](size, ttl)}
+}
+
+// Get 读取缓存
+func (c *LRU[V]) Get(key string) (v V, ok bool) {
+	item, ok := c.l.get(key)
+	if !ok {
+		return v, false
+	}
+	return item.v, true
+}
+
+// Set 写入缓存，ttl 不大于 0 或者超过最长有效期时使用最长有效期
+func (c *LRU[V]) Set(key string, v V, ttl time.Duration) {
+	c.l.set(key, v, false, ttl)
+}
+
+// Delete 删除缓存
+func (c *LRU[V]) Delete(key string) {
+	c.l.del(key)
+}
+
+// Len 返回缓存数量
+func (c *LRU[V]) Len() int {
+	return c.l.len()
+}
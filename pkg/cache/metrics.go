@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1}
+
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "cache",
+	Name:      "requests_total",
+	Help:      "cache requests by result",
+}, []string{"cache", "result"})
+
+var cacheLoads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "cache",
+	Name:      "loads_total",
+	Help:      "cache loads by result",
+}, []string{"cache", "result"})
+
+var cacheLoadDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "cache",
+	Name:      "load_duration_seconds",
+	Help:      "cache load latency distributions",
+	Buckets:   defBuckets,
+}, []string{"cache"})
+
+var cacheInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "cache",
+	Name:      "invalidations_total",
+	Help:      "local cache invalidations received from other instances",
+}, []string{"cache"})
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+	prometheus.MustRegister(cacheLoads)
+	prometheus.MustRegister(cacheLoadDurations)
+	prometheus.MustRegister(cacheInvalidations)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -26,6 +27,9 @@ var (
 
 	files = map[string]*Conf{}
 
+	callbackMu sync.RWMutex
+	callbacks  []func()
+
 	defaultFile = "sniper"
 )
 
@@ -71,6 +75,7 @@ func init() {
 			panic(err)
 		}
 		v.AutomaticEnv()
+		v.OnConfigChange(func(in fsnotify.Event) { configChanged() })
 
 		name := strings.TrimSuffix(f.Name(), ".toml")
 		files[name] = &Conf{v}
@@ -91,10 +96,20 @@ func File(name string) *Conf {
 }
 
 // OnConfigChange 注册配置文件变更回调
-// 需要在 WatchConfig 之前调用
+// 可以多次注册，配置变更时按注册顺序执行
 func OnConfigChange(run func()) {
-	for _, v := range files {
-		v.OnConfigChange(func(in fsnotify.Event) { run() })
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	callbacks = append(callbacks, run)
+}
+
+func configChanged() {
+	callbackMu.RLock()
+	runs := callbacks
+	callbackMu.RUnlock()
+
+	for _, run := range runs {
+		run()
 	}
 }
 
@@ -0,0 +1,131 @@
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Adaptive 自适应并发限制，参考 Netflix concurrency-limits 的 Gradient2 算法
+//
+// 长期平均耗时 longRTT 代表系统正常状态，每个请求的耗时为 shortRTT。
+// 耗时变长说明出现排队，按 longRTT/shortRTT 的比例降低并发上限；
+// 耗时正常时并发上限每次增加 sqrt(limit)，逐步探测系统容量。
+//
+//	l := limit.NewAdaptive(10, 1000)
+//	release, ok := l.Acquire()
+//	if !ok {
+//		return errOverload
+//	}
+//	defer release(false)
+type Adaptive struct {
+	mu       sync.Mutex
+	min      float64
+	max      float64
+	limit    float64
+	inflight int
+	longRTT  float64 // 纳秒，指数加权平均
+}
+
+const (
+	// 新样本在长期平均耗时中的权重
+	rttAlpha = 0.05
+	// 耗时容忍度，shortRTT 不超过 longRTT 的 1.5 倍时不降低上限
+	tolerance = 1.5
+	// 新上限的平滑系数
+	smoothing = 0.2
+	// 丢弃请求（超时等）时的上限衰减比例
+	backoff = 0.9
+)
+
+// NewAdaptive 创建自适应并发限制，并发上限在 min 和 max 之间调整，初始值为 min
+func NewAdaptive(min, max int) *Adaptive {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+
+	return &Adaptive{
+		min:   float64(min),
+		max:   float64(max),
+		limit: float64(min),
+	}
+}
+
+// Acquire 申请执行请求，超过并发上限时返回 false
+//
+// 请求结束后必须调用 release，请求因超时等原因失败时 dropped 为 true
+func (a *Adaptive) Acquire() (release func(dropped bool), ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.inflight >= int(a.limit) {
+		return nil, false
+	}
+
+	a.inflight++
+	start := time.Now()
+
+	var once sync.Once
+	return func(dropped bool) {
+		once.Do(func() { a.release(time.Since(start), dropped) })
+	}, true
+}
+
+func (a *Adaptive) release(rtt time.Duration, dropped bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	inflight := a.inflight
+	a.inflight--
+
+	if dropped {
+		a.limit = math.Max(a.min, a.limit*backoff)
+		return
+	}
+
+	short := float64(rtt)
+	if short <= 0 {
+		short = 1
+	}
+
+	if a.longRTT == 0 {
+		a.longRTT = short
+	} else {
+		a.longRTT = a.longRTT*(1-rttAlpha) + short*rttAlpha
+	}
+
+	// 长期平均耗时远大于当前耗时，说明负载已经下降，加快恢复
+	if a.longRTT/short > 2 {
+		a.longRTT *= 0.95
+	}
+
+	// 并发远没有达到上限时样本没有参考价值
+	if float64(inflight) < a.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*a.longRTT/short))
+	limit := a.limit*gradient + math.Sqrt(a.limit)
+	limit = a.limit*(1-smoothing) + limit*smoothing
+
+	a.limit = math.Max(a.min, math.Min(a.max, limit))
+}
+
+// Limit 返回当前并发上限
+func (a *Adaptive) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return int(a.limit)
+}
+
+// Inflight 返回当前执行中的请求数
+func (a *Adaptive) Inflight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.inflight
+}
@@ -0,0 +1,110 @@
+// Package limit 进程内限流组件
+//
+// TokenBucket 与 memdb.TokenBucket 接口相同，可以按需在单机和分布式限流之间切换：
+//
+//	var l limit.Limiter = limit.NewTokenBucket(10, 20)
+//	l = memdb.NewTokenBucket(memdb.Get("foo"), "login", 10, 20)
+//	ok, err := l.Allow(ctx, uid)
+//
+// Adaptive 根据请求耗时自动调整并发上限，用于过载保护。
+package limit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter 限流器，memdb.TokenBucket 也实现了该接口
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// 最多保存的 key 数量，超过后淘汰最久未使用的 key
+const maxKeys = 100000
+
+// TokenBucket 进程内令牌桶，每个 key 使用独立的桶
+type TokenBucket struct {
+	mu    sync.Mutex
+	rate  float64
+	burst int
+	ttl   time.Duration
+
+	// 按最近使用时间排序的桶，最近使用的在前面
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	ts     time.Time
+}
+
+// NewTokenBucket 创建令牌桶，rate 为每秒生成的令牌数，burst 为桶容量
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	// 桶装满之后与新建的桶没有区别，可以直接淘汰
+	ttl := time.Duration(float64(burst) / rate * float64(time.Second))
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	return &TokenBucket{
+		rate:    rate,
+		burst:   burst,
+		ttl:     ttl,
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Allow 消耗一个令牌
+func (b *TokenBucket) Allow(ctx context.Context, key string) (bool, error) {
+	return b.AllowN(ctx, key, 1)
+}
+
+// AllowN 消耗 n 个令牌，令牌不足时不消耗并返回 false
+func (b *TokenBucket) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	bk := b.get(key, now)
+
+	bk.tokens += now.Sub(bk.ts).Seconds() * b.rate
+	if bk.tokens > float64(b.burst) {
+		bk.tokens = float64(b.burst)
+	}
+	bk.ts = now
+
+	if bk.tokens < float64(n) {
+		return false, nil
+	}
+	bk.tokens -= float64(n)
+	return true, nil
+}
+
+// get 返回 key 对应的桶，不存在则创建
+//
+// 同时淘汰超过 ttl 未使用的桶，以及超过 maxKeys 时最久未使用的桶
+func (b *TokenBucket) get(key string, now time.Time) *bucket {
+	if e, ok := b.buckets[key]; ok {
+		b.lru.MoveToFront(e)
+		return e.Value.(*bucket)
+	}
+
+	for e := b.lru.Back(); e != nil; e = b.lru.Back() {
+		if old := e.Value.(*bucket); b.lru.Len() >= maxKeys || now.Sub(old.ts) > b.ttl {
+			b.lru.Remove(e)
+			delete(b.buckets, old.key)
+			continue
+		}
+		break
+	}
+
+	bk := &bucket{key: key, tokens: float64(b.burst), ts: now}
+	b.buckets[key] = b.lru.PushFront(bk)
+	return bk
+}
@@ -0,0 +1,148 @@
+package limit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+
+	l := NewTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if ok, err := l.Allow(ctx, "a"); err != nil || !ok {
+			t.Fatal(i, ok, err)
+		}
+	}
+	if ok, _ := l.Allow(ctx, "a"); ok {
+		t.Fatal("bucket should be empty")
+	}
+	if ok, _ := l.Allow(ctx, "b"); !ok {
+		t.Fatal("keys should be independent")
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if ok, _ := l.Allow(ctx, "a"); !ok {
+		t.Fatal("token should be refilled")
+	}
+	if ok, _ := l.AllowN(ctx, "a", 2); ok {
+		t.Fatal("bucket should not have 2 tokens")
+	}
+}
+
+func TestTokenBucketEvict(t *testing.T) {
+	l := NewTokenBucket(10, 3)
+	now := time.Now()
+
+	l.get("a", now)
+	l.get("b", now.Add(500*time.Millisecond))
+	if len(l.buckets) != 2 {
+		t.Fatal("invalid buckets", len(l.buckets))
+	}
+
+	// 桶装满需要 0.3 秒，有效期最短 1 秒，a 已过期而 b 没有
+	l.get("c", now.Add(1200*time.Millisecond))
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 2 {
+		t.Fatal("idle bucket should be evicted", len(l.buckets))
+	}
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	ctx := context.Background()
+	l := NewTokenBucket(0.001, 100)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	n := 0
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := l.Allow(ctx, "a"); ok {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n != 100 {
+		t.Fatal("allowed should equal burst", n)
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	l := NewAdaptive(2, 100)
+
+	r1, ok := l.Acquire()
+	if !ok {
+		t.Fatal("should acquire")
+	}
+	r2, ok := l.Acquire()
+	if !ok {
+		t.Fatal("should acquire")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("should exceed limit")
+	}
+	if l.Inflight() != 2 {
+		t.Fatal("inflight", l.Inflight())
+	}
+
+	r1(false)
+	r1(false) // 重复调用不影响计数
+	r2(false)
+	if l.Inflight() != 0 {
+		t.Fatal("inflight", l.Inflight())
+	}
+
+	// 耗时稳定时上限逐步增加
+	for i := 0; i < 100; i++ {
+		var rs []func(bool)
+		for j := 0; j < l.Limit(); j++ {
+			r, ok := l.Acquire()
+			if !ok {
+				t.Fatal("should acquire", j)
+			}
+			rs = append(rs, r)
+		}
+		for _, r := range rs {
+			r(false)
+		}
+	}
+	if l.Limit() <= 2 {
+		t.Fatal("limit should grow", l.Limit())
+	}
+	if l.Limit() > 100 {
+		t.Fatal("limit should not exceed max", l.Limit())
+	}
+
+	// 丢弃请求时快速降低上限
+	limit := l.Limit()
+	for i := 0; i < 10; i++ {
+		r, _ := l.Acquire()
+		r(true)
+	}
+	if l.Limit() >= limit {
+		t.Fatal("limit should drop", limit, l.Limit())
+	}
+}
+
+func TestAdaptiveLatency(t *testing.T) {
+	l := NewAdaptive(10, 10)
+	l.limit = 50
+	l.max = 100
+
+	// 模拟耗时升高：先建立较短的长期耗时，再放入耗时变长的满负载请求
+	l.longRTT = float64(time.Millisecond)
+	for i := 0; i < 20; i++ {
+		l.inflight = int(l.limit)
+		l.release(20*time.Millisecond, false)
+	}
+	if l.Limit() >= 50 {
+		t.Fatal("limit should drop when latency grows", l.Limit())
+	}
+}
@@ -0,0 +1,226 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-redis/redis/v8"
+)
+
+type keyPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// keyspace 实例的 key 模板和大 key 阈值配置
+type keyspace struct {
+	patterns  []keyPattern
+	threshold int
+}
+
+// 按实例名缓存解析后的配置，避免每条命令都读取配置，配置变更后重新解析
+var keyspaces sync.Map
+
+func init() {
+	conf.OnConfigChange(func() { keyspaces.Clear() })
+}
+
+// keyspaceOf 返回实例的 keyspace 配置
+//
+// 模板读取 MEMDB_KEY_PATTERNS_name 配置，阈值优先读取 MEMDB_BIG_VALUE_name，
+// 其次读取 MEMDB_BIG_VALUE
+func keyspaceOf(name string) *keyspace {
+	if v, ok := keyspaces.Load(name); ok {
+		return v.(*keyspace)
+	}
+
+	ks := &keyspace{
+		patterns:  compilePatterns(conf.Get("MEMDB_KEY_PATTERNS_" + name)),
+		threshold: conf.GetInt("MEMDB_BIG_VALUE_" + name),
+	}
+	if ks.threshold == 0 {
+		ks.threshold = conf.GetInt("MEMDB_BIG_VALUE")
+	}
+
+	v, _ := keyspaces.LoadOrStore(name, ks)
+	return v.(*keyspace)
+}
+
+var placeholderRE = regexp.MustCompile(`\{[^}]*\}`)
+
+// compilePatterns 编译逗号分隔的 key 模板
+//
+// "user:{id}:profile" 匹配 "user:1:profile"，占位符不匹配冒号
+func compilePatterns(s string) []keyPattern {
+	var patterns []keyPattern
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		var b strings.Builder
+		b.WriteString("^")
+		last := 0
+		for _, loc := range placeholderRE.FindAllStringIndex(p, -1) {
+			b.WriteString(regexp.QuoteMeta(p[last:loc[0]]))
+			b.WriteString("[^:]+")
+			last = loc[1]
+		}
+		b.WriteString(regexp.QuoteMeta(p[last:]))
+		b.WriteString("$")
+
+		patterns = append(patterns, keyPattern{name: p, re: regexp.MustCompile(b.String())})
+	}
+	return patterns
+}
+
+// keyPatternOf 返回命令第一个 key 匹配的模板
+//
+// 未配置模板返回空字符串，都不匹配返回 other，避免监控指标的标签数量失控
+func (o observer) keyPatternOf(cmd redis.Cmder) string {
+	patterns := keyspaceOf(o.name).patterns
+	if len(patterns) == 0 {
+		return ""
+	}
+
+	key := firstKey(cmd)
+	if key == "" {
+		return ""
+	}
+
+	for _, p := range patterns {
+		if p.re.MatchString(key) {
+			return p.name
+		}
+	}
+	return "other"
+}
+
+func firstKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	switch cmd.Name() {
+	case "eval", "evalsha":
+		// eval script numkeys key ...
+		if len(args) < 4 {
+			return ""
+		}
+		return argString(args[3])
+	case "ping", "info", "multi", "exec", "select", "auth", "script",
+		"subscribe", "psubscribe", "publish", "client", "config":
+		return ""
+	}
+	return argString(args[1])
+}
+
+func argString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// 写入命令中 value 参数的位置，-1 表示从该位置开始 field/value 交替出现
+var writeValueIndex = map[string]int{
+	"set":    2,
+	"setnx":  2,
+	"getset": 2,
+	"append": 2,
+	"setex":  3,
+	"psetex": 3,
+	"hset":   -3,
+	"hmset":  -3,
+	"hsetnx": 3,
+	"mset":   -2,
+	"msetnx": -2,
+}
+
+// valueSize 返回命令写入或者读取的 value 字节数，不支持的命令返回 false
+func valueSize(cmd redis.Cmder) (int, bool) {
+	args := cmd.Args()
+	if i, ok := writeValueIndex[cmd.Name()]; ok {
+		size := 0
+		if i > 0 {
+			if i < len(args) {
+				size = len(argString(args[i]))
+			}
+			return size, true
+		}
+		for j := -i; j < len(args); j += 2 {
+			size += len(argString(args[j]))
+		}
+		return size, true
+	}
+
+	if cmd.Err() != nil {
+		return 0, false
+	}
+
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		switch cmd.Name() {
+		case "get", "getex", "getdel", "hget", "lindex", "lpop", "rpop":
+			return len(c.Val()), true
+		}
+	case *redis.SliceCmd:
+		switch cmd.Name() {
+		case "mget", "hmget":
+			size := 0
+			for _, v := range c.Val() {
+				if v != nil {
+					size += len(argString(v))
+				}
+			}
+			return size, true
+		}
+	case *redis.StringStringMapCmd:
+		if cmd.Name() == "hgetall" {
+			size := 0
+			for k, v := range c.Val() {
+				size += len(k) + len(v)
+			}
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// observeValue 统计 value 大小，超过阈值时记录大 key 日志
+//
+// 阈值单位为字节，为零则不记录。日志只记录 key 模板，避免 key 中的用户数据写入日志
+func (o observer) observeValue(ctx context.Context, cmd redis.Cmder, pattern string) {
+	size, ok := valueSize(cmd)
+	if !ok {
+		return
+	}
+
+	redisValueSizes.WithLabelValues(
+		o.name,
+		cmd.FullName(),
+		pattern,
+	).Observe(float64(size))
+
+	threshold := keyspaceOf(o.name).threshold
+	if threshold <= 0 || size < threshold {
+		return
+	}
+
+	log.Get(ctx).WithFields(log.Fields{
+		"db_name":     o.name,
+		"cmd":         cmd.FullName(),
+		"key_pattern": pattern,
+		"size":        size,
+	}).Warn("[memdb] big value")
+}
@@ -0,0 +1,121 @@
+package memdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKeyPattern(t *testing.T) {
+	conf.Set("MEMDB_KEY_PATTERNS_kp", "user:{id}:profile, order:{id}, rank.{day}")
+	o := observer{name: "kp"}
+	ctx := context.Background()
+
+	cases := []struct {
+		cmd     redis.Cmder
+		pattern string
+	}{
+		{redis.NewStringCmd(ctx, "get", "user:1:profile"), "user:{id}:profile"},
+		{redis.NewStringCmd(ctx, "get", "user:1:2:profile"), "other"},
+		{redis.NewIntCmd(ctx, "del", "order:abc"), "order:{id}"},
+		{redis.NewIntCmd(ctx, "zadd", "rank.20200101", 1, "a"), "rank.{day}"},
+		{redis.NewIntCmd(ctx, "zadd", "rankx20200101", 1, "a"), "other"},
+		{redis.NewCmd(ctx, "evalsha", "sha", 1, "order:1"), "order:{id}"},
+		{redis.NewStatusCmd(ctx, "ping"), ""},
+	}
+
+	for _, c := range cases {
+		if p := o.keyPatternOf(c.cmd); p != c.pattern {
+			t.Fatal("invalid pattern", c.cmd.Args(), p)
+		}
+	}
+
+	if p := (observer{name: "none"}).keyPatternOf(cases[0].cmd); p != "" {
+		t.Fatal("pattern should be empty without config", p)
+	}
+}
+
+func TestKeyspaceReload(t *testing.T) {
+	conf.Set("MEMDB_KEY_PATTERNS_kr", "user:{id}")
+	conf.Set("MEMDB_BIG_VALUE_kr", 100)
+	o := observer{name: "kr"}
+	cmd := redis.NewStringCmd(context.Background(), "get", "order:1")
+
+	if p := o.keyPatternOf(cmd); p != "other" {
+		t.Fatal("invalid pattern", p)
+	}
+
+	// 配置变更前使用缓存的配置
+	conf.Set("MEMDB_KEY_PATTERNS_kr", "order:{id}")
+	conf.Set("MEMDB_BIG_VALUE_kr", 200)
+	if p := o.keyPatternOf(cmd); p != "other" {
+		t.Fatal("pattern should be cached", p)
+	}
+
+	keyspaces.Clear()
+	if p := o.keyPatternOf(cmd); p != "order:{id}" {
+		t.Fatal("pattern should be reloaded", p)
+	}
+	if n := keyspaceOf("kr").threshold; n != 200 {
+		t.Fatal("threshold should be reloaded", n)
+	}
+}
+
+func TestValueSize(t *testing.T) {
+	ctx := context.Background()
+
+	get := redis.NewStringCmd(ctx, "get", "a")
+	get.SetVal("12345")
+	mget := redis.NewSliceCmd(ctx, "mget", "a", "b")
+	mget.SetVal([]any{"123", nil})
+	failed := redis.NewStringCmd(ctx, "get", "a")
+	failed.SetErr(redis.Nil)
+
+	cases := []struct {
+		cmd  redis.Cmder
+		size int
+		ok   bool
+	}{
+		{redis.NewStatusCmd(ctx, "set", "a", "123"), 3, true},
+		{redis.NewStatusCmd(ctx, "setex", "a", 10, "1234"), 4, true},
+		{redis.NewIntCmd(ctx, "hset", "h", "f1", "12", "f2", "345"), 5, true},
+		{redis.NewStatusCmd(ctx, "mset", "a", "1", "b", "22"), 3, true},
+		{get, 5, true},
+		{mget, 3, true},
+		{failed, 0, false},
+		{redis.NewIntCmd(ctx, "del", "a"), 0, false},
+	}
+
+	for _, c := range cases {
+		if size, ok := valueSize(c.cmd); size != c.size || ok != c.ok {
+			t.Fatal("invalid size", c.cmd.Args(), size, ok)
+		}
+	}
+}
+
+func TestKeyspaceMetrics(t *testing.T) {
+	conf.Set("MEMDB_DSN_ks", "mem://ks")
+	conf.Set("MEMDB_KEY_PATTERNS_ks", "user:{id}")
+	conf.Set("MEMDB_BIG_VALUE_ks", 100)
+	ctx := context.Background()
+
+	db := Get("ks")
+	db.Set(ctx, "user:1", strings.Repeat("a", 200), 0)
+	db.Get(ctx, "user:1")
+
+	for _, cmd := range []string{"set", "get"} {
+		h := redisValueSizes.WithLabelValues("ks", cmd, "user:{id}")
+		if n := testutil.CollectAndCount(h.(prometheus.Collector)); n != 1 {
+			t.Fatal("invalid value sizes", cmd, n)
+		}
+		h = redisDurations.WithLabelValues("ks", cmd, "user:{id}")
+		if n := testutil.CollectAndCount(h.(prometheus.Collector)); n != 1 {
+			t.Fatal("invalid durations", cmd, n)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package memdb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 令牌桶，tokens 和 ts 分别保存剩余令牌数和上次更新时间（毫秒）
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local v = redis.call("hmget", KEYS[1], "tokens", "ts")
+local tokens = tonumber(v[1]) or burst
+local ts = tonumber(v[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local ok = 0
+if tokens >= n then
+	tokens = tokens - n
+	ok = 1
+end
+
+redis.call("hset", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("pexpire", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return ok
+`)
+
+// 滑动窗口，有序集合保存窗口内每次请求的时间（毫秒）
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("zremrangebyscore", KEYS[1], "-inf", now - window)
+if redis.call("zcard", KEYS[1]) < limit then
+	redis.call("zadd", KEYS[1], now, ARGV[4])
+	redis.call("pexpire", KEYS[1], window)
+	return 1
+end
+return 0
+`)
+
+// TokenBucket 令牌桶限流器，允许一定的突发流量
+//
+//	l := memdb.NewTokenBucket(memdb.Get("foo"), "login", 10, 20)
+//	ok, err := l.Allow(ctx, uid)
+type TokenBucket struct {
+	db     *Client
+	prefix string
+	rate   float64
+	burst  int
+}
+
+// NewTokenBucket 创建令牌桶，rate 为每秒生成的令牌数，burst 为桶容量
+//
+// rate 和 burst 必须大于 0，否则 panic
+func NewTokenBucket(db *Client, prefix string, rate float64, burst int) *TokenBucket {
+	if rate <= 0 || burst <= 0 {
+		panic(fmt.Sprintf("memdb: invalid token bucket rate %v burst %d", rate, burst))
+	}
+	return &TokenBucket{db: db, prefix: prefix, rate: rate, burst: burst}
+}
+
+// Allow 消耗一个令牌
+func (b *TokenBucket) Allow(ctx context.Context, key string) (bool, error) {
+	return b.AllowN(ctx, key, 1)
+}
+
+// AllowN 消耗 n 个令牌，令牌不足时不消耗并返回 false
+func (b *TokenBucket) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	now := time.Now().UnixMilli()
+	ok, err := tokenBucketScript.Run(ctx, b.db, []string{"limit:" + b.prefix + ":" + key},
+		strconv.FormatFloat(b.rate, 'f', -1, 64), b.burst, now, n).Int()
+	return ok == 1, err
+}
+
+// SlidingWindow 滑动窗口限流器，严格限制任意时间窗口内的请求数
+//
+// 每次请求都会记录在有序集合中，不适合 limit 很大的场景。
+type SlidingWindow struct {
+	db     *Client
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindow 创建滑动窗口，window 时间内最多允许 limit 次请求
+//
+// limit 必须大于 0，window 不能小于 1ms，否则 panic
+func NewSlidingWindow(db *Client, prefix string, limit int, window time.Duration) *SlidingWindow {
+	if limit <= 0 || window < time.Millisecond {
+		panic(fmt.Sprintf("memdb: invalid sliding window limit %d window %v", limit, window))
+	}
+	return &SlidingWindow{db: db, prefix: prefix, limit: limit, window: window}
+}
+
+// Allow 记录一次请求，超过限制时返回 false
+func (w *SlidingWindow) Allow(ctx context.Context, key string) (bool, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return false, err
+	}
+
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(b)
+	ok, err := slidingWindowScript.Run(ctx, w.db, []string{"limit:" + w.prefix + ":" + key},
+		now, w.window.Milliseconds(), w.limit, member).Int()
+	return ok == 1, err
+}
@@ -0,0 +1,300 @@
+package memdb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrNotObtained 锁已被其他人持有
+	ErrNotObtained = errors.New("memdb: lock not obtained")
+	// ErrLockNotHeld 锁已过期或者被其他人持有
+	ErrLockNotHeld = errors.New("memdb: lock not held")
+)
+
+// minLockTTL 锁的最短有效期，redis 过期时间精度为毫秒，续期间隔为 ttl/3
+const minLockTTL = 10 * time.Millisecond
+
+// 加锁成功后递增并返回 fencing token
+var lockScript = redis.NewScript(`
+if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("incr", KEYS[2])
+end
+return 0
+`)
+
+// 多个节点加锁成功后，把每个节点的 token 推进到最终的 token，
+// 保证之后任意多数节点加锁得到的 token 都更大
+var fenceScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local v = tonumber(redis.call("get", KEYS[2]) or "0")
+if v < tonumber(ARGV[2]) then
+	redis.call("set", KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Redlock 基于多个独立 redis 实例的分布式锁，实现 Redlock 算法
+//
+// 在多数（n/2+1）实例上加锁成功，并且耗时小于有效期才算成功，
+// 少数实例故障或者主从切换丢锁不影响互斥。只有一个实例时退化为单实例锁。
+type Redlock struct {
+	nodes []*Client
+}
+
+// NewRedlock 使用多个独立的 redis 实例创建锁，实例之间不能是主从关系
+func NewRedlock(nodes ...*Client) *Redlock {
+	return &Redlock{nodes: nodes}
+}
+
+// Mutex 分布式锁，持有期间会在后台自动续期，直到调用 Unlock 或者续期失败
+type Mutex struct {
+	nodes []*Client
+	key   string
+	value string
+	token int64
+	ttl   time.Duration
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Lock 使用名为 name 的缓存实例加锁，锁被占用时会一直重试直到 ctx 结束
+//
+// 配置 MEMDB_LOCK_NODES_name 时使用其中的实例执行 Redlock 算法，多个实例名用逗号分隔，
+// 否则只使用 name 实例。
+//
+//	m, err := memdb.Lock(ctx, "foo", "job:daily", 10*time.Second)
+//	if err != nil {
+//		return err
+//	}
+//	defer m.Unlock(ctx)
+func Lock(ctx context.Context, name, key string, ttl time.Duration) (*Mutex, error) {
+	return redlock(name).Lock(ctx, key, ttl)
+}
+
+func redlock(name string) *Redlock {
+	var nodes []*Client
+	for _, n := range strings.Split(conf.Get("MEMDB_LOCK_NODES_"+name), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			nodes = append(nodes, Get(n))
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, Get(name))
+	}
+	return NewRedlock(nodes...)
+}
+
+// Lock 使用单个实例加锁，锁被占用时会一直重试直到 ctx 结束
+func (c *Client) Lock(ctx context.Context, key string, ttl time.Duration) (*Mutex, error) {
+	return NewRedlock(c).Lock(ctx, key, ttl)
+}
+
+// TryLock 使用单个实例尝试加锁一次，锁被占用时返回 ErrNotObtained
+func (c *Client) TryLock(ctx context.Context, key string, ttl time.Duration) (*Mutex, error) {
+	return NewRedlock(c).TryLock(ctx, key, ttl)
+}
+
+// Lock 加锁，锁被占用时会一直重试直到 ctx 结束
+func (r *Redlock) Lock(ctx context.Context, key string, ttl time.Duration) (*Mutex, error) {
+	backoff := 10 * time.Millisecond
+	for {
+		m, err := r.TryLock(ctx, key, ttl)
+		if err != ErrNotObtained {
+			return m, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff < ttl/2 {
+			backoff *= 2
+		}
+	}
+}
+
+// TryLock 尝试加锁一次，锁被占用时返回 ErrNotObtained
+//
+// ttl 不能小于 10ms。多数实例加锁成功，并且扣除耗时和时钟漂移后仍在有效期内才算成功，
+// 否则释放已经加上的锁。
+func (r *Redlock) TryLock(ctx context.Context, key string, ttl time.Duration) (*Mutex, error) {
+	if ttl < minLockTTL {
+		return nil, fmt.Errorf("memdb: lock ttl %v is less than %v", ttl, minLockTTL)
+	}
+	if len(r.nodes) == 0 {
+		return nil, errors.New("memdb: lock without nodes")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	// 使用 hash tag 保证集群模式下两个 key 在同一个 slot
+	m := &Mutex{
+		nodes: r.nodes,
+		key:   "lock:{" + key + "}",
+		value: hex.EncodeToString(b),
+		ttl:   ttl,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	start := time.Now()
+	tokens, errs := m.eval(ctx, lockScript, []string{m.key, m.fenceKey()},
+		m.value, ttl.Milliseconds())
+
+	ok := 0
+	for _, t := range tokens {
+		if t > 0 {
+			ok++
+			m.token = max(m.token, t)
+		}
+	}
+
+	// 只有一个实例时 incr 本身就是递增的，不需要推进 token
+	if ok >= m.quorum() && len(m.nodes) > 1 {
+		results, _ := m.eval(ctx, fenceScript, []string{m.key, m.fenceKey()}, m.value, m.token)
+		ok = count(results)
+	}
+
+	// 时钟漂移按有效期的 1% 加 2ms 估算
+	drift := ttl/100 + 2*time.Millisecond
+	if ok < m.quorum() || time.Since(start)+drift >= ttl {
+		m.release(context.WithoutCancel(ctx))
+		if err := errors.Join(errs...); err != nil && ok == 0 {
+			return nil, err
+		}
+		return nil, ErrNotObtained
+	}
+
+	go m.extend(ctx)
+
+	return m, nil
+}
+
+// Token 返回 fencing token，每次加锁成功都会递增
+//
+// 写入共享资源时带上 token，资源方拒绝比已见过的 token 更小的请求，
+// 可以避免锁过期后旧的持有者继续写入。
+func (m *Mutex) Token() int64 {
+	return m.token
+}
+
+// Done 锁被释放或者续期失败时关闭
+func (m *Mutex) Done() <-chan struct{} {
+	return m.done
+}
+
+// Unlock 释放锁，多数实例上的锁已经过期时返回 ErrLockNotHeld
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.once.Do(func() { close(m.stop) })
+	<-m.done
+
+	n, err := m.release(ctx)
+	if n >= m.quorum() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+// release 在所有实例上释放锁，返回释放成功的实例数
+func (m *Mutex) release(ctx context.Context) (int, error) {
+	results, errs := m.eval(ctx, unlockScript, []string{m.key}, m.value)
+	return count(results), errors.Join(errs...)
+}
+
+// extend 每隔 ttl/3 续期一次，多数实例续期成功才算成功
+func (m *Mutex) extend(ctx context.Context) {
+	defer close(m.done)
+
+	ctx = context.WithoutCancel(ctx)
+	t := time.NewTicker(m.ttl / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+
+		results, errs := m.eval(ctx, extendScript, []string{m.key}, m.value, m.ttl.Milliseconds())
+		if count(results) < m.quorum() {
+			log.Get(ctx).Warnf("[memdb] lock %s lost, err:%v", m.key, errors.Join(errs...))
+			return
+		}
+	}
+}
+
+// eval 在所有实例上并发执行脚本，返回每个实例的结果和错误
+//
+// 每个实例最多等待 ttl，避免故障的实例拖慢加锁
+func (m *Mutex) eval(ctx context.Context, s *redis.Script, keys []string, args ...any) ([]int64, []error) {
+	ctx, cancel := context.WithTimeout(ctx, m.ttl)
+	defer cancel()
+
+	results := make([]int64, len(m.nodes))
+	errs := make([]error, len(m.nodes))
+
+	var wg sync.WaitGroup
+	for i, db := range m.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.Run(ctx, db, keys, args...).Int64()
+		}()
+	}
+	wg.Wait()
+
+	return results, errs
+}
+
+func (m *Mutex) fenceKey() string { return m.key + ":fence" }
+
+func (m *Mutex) quorum() int { return len(m.nodes)/2 + 1 }
+
+// count 返回执行成功的实例数
+func count(results []int64) int {
+	n := 0
+	for _, r := range results {
+		if r > 0 {
+			n++
+		}
+	}
+	return n
+}
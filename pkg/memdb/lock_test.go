@@ -0,0 +1,222 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+func newTestClient(t *testing.T) (*Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	name := "test_" + t.Name()
+	conf.Set("MEMDB_DSN_"+name, "redis://"+mr.Addr())
+	return Get(name), mr
+}
+
+func TestLock(t *testing.T) {
+	db, _ := newTestClient(t)
+	ctx := context.Background()
+
+	m1, err := db.TryLock(ctx, "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.TryLock(ctx, "foo", time.Second); err != ErrNotObtained {
+		t.Fatal("lock should be held", err)
+	}
+
+	ctx1, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.Lock(ctx1, "foo", time.Second); err != context.DeadlineExceeded {
+		t.Fatal("lock should time out", err)
+	}
+
+	if err := m1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-m1.Done():
+	default:
+		t.Fatal("done should be closed after unlock")
+	}
+
+	m2, err := db.Lock(ctx, "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Unlock(ctx)
+
+	if m2.Token() <= m1.Token() {
+		t.Fatal("token should increase", m1.Token(), m2.Token())
+	}
+	for _, ttl := range []time.Duration{0, time.Nanosecond, time.Millisecond} {
+		if _, err := db.Lock(ctx, "bar", ttl); err == nil {
+			t.Fatal("ttl should be invalid", ttl)
+		}
+	}
+}
+
+func TestLockExtend(t *testing.T) {
+	db, mr := newTestClient(t)
+	ctx := context.Background()
+
+	m, err := db.TryLock(ctx, "foo", 90*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// miniredis 不会自动过期，手动快进时间，续期会重置过期时间
+	for i := 0; i < 30; i++ {
+		mr.FastForward(20 * time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !mr.Exists("lock:{foo}") {
+		t.Fatal("lock should be extended")
+	}
+
+	mr.Del("lock:{foo}")
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("done should be closed after lock lost")
+	}
+
+	if err := m.Unlock(ctx); err != ErrLockNotHeld {
+		t.Fatal("unlock should fail", err)
+	}
+}
+
+func TestRedlock(t *testing.T) {
+	ctx := context.Background()
+
+	var mrs []*miniredis.Miniredis
+	var nodes []string
+	for i := 0; i < 3; i++ {
+		mr := miniredis.RunT(t)
+		name := fmt.Sprintf("test_%s_%d", t.Name(), i)
+		conf.Set("MEMDB_DSN_"+name, "redis://"+mr.Addr())
+		mrs = append(mrs, mr)
+		nodes = append(nodes, name)
+	}
+	conf.Set("MEMDB_LOCK_NODES_"+t.Name(), strings.Join(nodes, ","))
+
+	// 少数实例被占用仍然可以加锁
+	mrs[0].Set("lock:{foo}", "other")
+	m1, err := Lock(ctx, t.Name(), "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 多数实例被占用时加锁失败，并且释放已经加上的锁
+	mrs[1].Set("lock:{foo}", "other")
+	if _, err := redlock(t.Name()).TryLock(ctx, "foo", time.Second); err != ErrNotObtained {
+		t.Fatal("lock should not be obtained", err)
+	}
+	if mrs[2].Exists("lock:{foo}") {
+		t.Fatal("partial lock should be released")
+	}
+
+	// token 推进到所有成功实例的最大值，之后任意多数实例加锁都会更大
+	mrs[0].Del("lock:{foo}")
+	mrs[1].Del("lock:{foo}")
+	mrs[2].Set("lock:{foo}:fence", "100")
+	m2, err := Lock(ctx, t.Name(), "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mrs[2].Set("lock:{foo}", "other")
+	m3, err := Lock(ctx, t.Name(), "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m3.Unlock(ctx)
+	if m2.Token() < 100 || m3.Token() <= m2.Token() {
+		t.Fatal("token should increase", m2.Token(), m3.Token())
+	}
+
+	// 多数实例上的锁丢失时释放失败
+	mrs[0].Del("lock:{foo}")
+	if err := m3.Unlock(ctx); err != ErrLockNotHeld {
+		t.Fatal("unlock should fail", err)
+	}
+}
+
+func TestInvalidLimiter(t *testing.T) {
+	db, _ := newTestClient(t)
+
+	for _, f := range []func(){
+		func() { NewTokenBucket(db, "tb", 0, 1) },
+		func() { NewTokenBucket(db, "tb", -1, 1) },
+		func() { NewTokenBucket(db, "tb", 1, 0) },
+		func() { NewSlidingWindow(db, "sw", 0, time.Second) },
+		func() { NewSlidingWindow(db, "sw", 1, 0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("should panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	db, _ := newTestClient(t)
+	ctx := context.Background()
+
+	l := NewTokenBucket(db, "tb", 10, 3)
+	for i := 0; i < 3; i++ {
+		if ok, err := l.Allow(ctx, "a"); err != nil || !ok {
+			t.Fatal(i, ok, err)
+		}
+	}
+	if ok, _ := l.Allow(ctx, "a"); ok {
+		t.Fatal("bucket should be empty")
+	}
+	if ok, _ := l.Allow(ctx, "b"); !ok {
+		t.Fatal("keys should be independent")
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if ok, _ := l.Allow(ctx, "a"); !ok {
+		t.Fatal("token should be refilled")
+	}
+	if ok, _ := l.AllowN(ctx, "a", 2); ok {
+		t.Fatal("bucket should not have 2 tokens")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	db, _ := newTestClient(t)
+	ctx := context.Background()
+
+	l := NewSlidingWindow(db, "sw", 2, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if ok, err := l.Allow(ctx, "a"); err != nil || !ok {
+			t.Fatal(i, ok, err)
+		}
+	}
+	if ok, _ := l.Allow(ctx, "a"); ok {
+		t.Fatal("window should be full")
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if ok, _ := l.Allow(ctx, "a"); !ok {
+		t.Fatal("window should slide")
+	}
+}
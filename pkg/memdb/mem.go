@@ -0,0 +1,95 @@
+package memdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	memLock    sync.Mutex
+	memServers = map[string]*memServer{}
+)
+
+// memServer 进程内的 redis 服务，所有使用该服务的客户端关闭后停止
+type memServer struct {
+	mr   *miniredis.Miniredis
+	refs int
+	stop chan struct{}
+}
+
+// memClient 关闭时释放进程内服务的客户端
+type memClient struct {
+	*redis.Client
+	once    sync.Once
+	release func()
+}
+
+func (c *memClient) Close() error {
+	err := c.Client.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// newMemClient 启动进程内的 redis 服务并返回客户端，同名服务共享数据
+//
+// 服务基于 miniredis 实现，支持字符串、哈希、列表、集合、有序集合、
+// 过期、发布订阅和 Lua 脚本等常用命令，只用于测试。
+// 服务不需要认证，dsn 中的用户名和密码会被忽略。
+func newMemClient(name string, opts *redis.UniversalOptions) (redis.UniversalClient, error) {
+	memLock.Lock()
+	defer memLock.Unlock()
+
+	s, ok := memServers[name]
+	if !ok {
+		mr := miniredis.NewMiniRedis()
+		if err := mr.Start(); err != nil {
+			return nil, err
+		}
+		s = &memServer{mr: mr, stop: make(chan struct{})}
+		memServers[name] = s
+
+		go s.tick()
+	}
+	s.refs++
+
+	opts.Addrs = []string{s.mr.Addr()}
+	opts.Username = ""
+	opts.Password = ""
+	return &memClient{
+		Client:  redis.NewClient(opts.Simple()),
+		release: func() { releaseMem(name, s) },
+	}, nil
+}
+
+// releaseMem 最后一个客户端关闭后停止服务，数据随之清空
+func releaseMem(name string, s *memServer) {
+	memLock.Lock()
+	defer memLock.Unlock()
+
+	if s.refs--; s.refs > 0 {
+		return
+	}
+	close(s.stop)
+	s.mr.Close()
+	delete(memServers, name)
+}
+
+// miniredis 不会自动过期 key，需要按实际时间快进
+func (s *memServer) tick() {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			s.mr.FastForward(now.Sub(last))
+			last = now
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package memdb
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/go-kiss/sniper/pkg/conf"
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -12,7 +14,8 @@ var (
 	sfg singleflight.Group
 	rwl sync.RWMutex
 
-	dbs = map[string]*Client{}
+	dbs        = map[string]*Client{}
+	collectors = map[string]prometheus.Collector{}
 )
 
 type nameKey struct{}
@@ -22,38 +25,74 @@ type Client struct {
 	redis.UniversalClient
 }
 
-// Get 获取缓存实例
+// Get 获取缓存实例，配置错误会 panic
 //
 // db := Get("foo")
 // db.Set(ctx, "a", "123", 0)
 func Get(name string) *Client {
+	db, err := Open(name)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// Open 获取缓存实例，配置错误时返回 error
+func Open(name string) (*Client, error) {
 	rwl.RLock()
 	if db, ok := dbs[name]; ok {
 		rwl.RUnlock()
-		return db
+		return db, nil
 	}
 	rwl.RUnlock()
 
-	v, _, _ := sfg.Do(name, func() (any, error) {
-		opts := &redis.UniversalOptions{}
-
+	v, err, _ := sfg.Do(name, func() (any, error) {
 		dsn := conf.Get("MEMDB_DSN_" + name)
-		setOptions(opts, dsn)
-
-		rdb := redis.NewUniversalClient(opts)
+		rdb, err := newClient(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("memdb %s: %w", name, err)
+		}
 
 		rdb.AddHook(observer{name: name})
 
-		registerStats(name, rdb)
-
 		db := &Client{rdb}
 
 		rwl.Lock()
 		defer rwl.Unlock()
 		dbs[name] = db
+		collectors[name] = registerStats(name, rdb)
 
 		return db, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*Client), nil
+}
+
+// Remove 关闭缓存实例，清理实例缓存和监控指标
+//
+// 之后调用 Get 会重新读取配置创建实例。mem:// 实例的所有客户端都关闭后，
+// 进程内的 redis 服务也会停止。
+func Remove(name string) error {
+	rwl.Lock()
+	db, ok := dbs[name]
+	collector := collectors[name]
+	delete(dbs, name)
+	delete(collectors, name)
+	rwl.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	prometheus.Unregister(collector)
+	labels := prometheus.Labels{"db_name": name}
+	redisDurations.DeletePartialMatch(labels)
+	redisErrors.DeletePartialMatch(labels)
+	redisPipelineSize.DeletePartialMatch(labels)
+	redisValueSizes.DeletePartialMatch(labels)
 
-	return v.(*Client)
+	return db.Close()
 }
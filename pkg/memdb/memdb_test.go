@@ -3,12 +3,15 @@ package memdb
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestMemDb(t *testing.T) {
-	conf.Set("MEMDB_DSN_foo", "redis://localhost:6379/")
+	conf.Set("MEMDB_DSN_foo", "mem://foo")
 
 	ctx := context.Background()
 	db := Get("foo")
@@ -25,3 +28,84 @@ func TestMemDb(t *testing.T) {
 		t.Fatal("invalid string: " + v)
 	}
 }
+
+func TestMem(t *testing.T) {
+	conf.Set("MEMDB_DSN_mem1", "mem://shared")
+	conf.Set("MEMDB_DSN_mem2", "mem://shared?DB=1")
+	conf.Set("MEMDB_DSN_mem3", "mem://other")
+
+	ctx := context.Background()
+	db1, db2, db3 := Get("mem1"), Get("mem2"), Get("mem3")
+
+	if err := db1.Set(ctx, "a", "1", 50*time.Millisecond).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db2.Exists(ctx, "a").Val(); n != 0 {
+		t.Fatal("different db should be isolated")
+	}
+	if n := db3.Exists(ctx, "a").Val(); n != 0 {
+		t.Fatal("different server should be isolated")
+	}
+
+	db1.HSet(ctx, "h", "f", "v")
+	db1.ZAdd(ctx, "z", &redis.Z{Score: 1, Member: "m"})
+	if v := db1.HGet(ctx, "h", "f").Val(); v != "v" {
+		t.Fatal("invalid hash", v)
+	}
+	if v := db1.ZScore(ctx, "z", "m").Val(); v != 1 {
+		t.Fatal("invalid zset", v)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := db1.Exists(ctx, "a").Val(); n != 0 {
+		t.Fatal("key should be expired")
+	}
+
+	// 命令同样经过 observer
+	if n := testutil.ToFloat64(redisErrors.WithLabelValues("mem1", "incr")); n != 0 {
+		t.Fatal(n)
+	}
+	db1.Set(ctx, "s", "abc", 0)
+	if err := db1.Incr(ctx, "s").Err(); err == nil {
+		t.Fatal("incr string should fail")
+	}
+	if n := testutil.ToFloat64(redisErrors.WithLabelValues("mem1", "incr")); n != 1 {
+		t.Fatal("invalid errors", n)
+	}
+}
+
+func TestMemRemove(t *testing.T) {
+	// 密码会被忽略
+	conf.Set("MEMDB_DSN_rm1", "mem://user:pw@removed")
+	conf.Set("MEMDB_DSN_rm2", "mem://removed?DB=1")
+	ctx := context.Background()
+
+	db1, db2 := Get("rm1"), Get("rm2")
+	if err := db1.Set(ctx, "a", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Remove("rm1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db2.Ping(ctx).Err(); err != nil {
+		t.Fatal("server should still be running", err)
+	}
+
+	if err := Remove("rm2"); err != nil {
+		t.Fatal(err)
+	}
+	memLock.Lock()
+	_, ok := memServers["removed"]
+	memLock.Unlock()
+	if ok {
+		t.Fatal("server should be stopped")
+	}
+
+	// 重新创建的实例使用新的服务
+	db1 = Get("rm1")
+	defer Remove("rm1")
+	if n := db1.Exists(ctx, "a").Val(); n != 0 {
+		t.Fatal("data should be cleared", n)
+	}
+}
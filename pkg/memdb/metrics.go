@@ -13,10 +13,40 @@ var redisDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Name:      "commands_duration_seconds",
 	Help:      "commands latency distributions",
 	Buckets:   defBuckets,
+}, []string{"db_name", "cmd", "key_pattern"})
+
+var sizeBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 500, 1000}
+
+var redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "memdb",
+	Name:      "commands_errors_total",
+	Help:      "commands errors excluding redis.Nil",
 }, []string{"db_name", "cmd"})
 
+var redisPipelineSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "memdb",
+	Name:      "pipeline_size",
+	Help:      "commands per pipeline distributions",
+	Buckets:   sizeBuckets,
+}, []string{"db_name"})
+
+var valueBuckets = prometheus.ExponentialBuckets(64, 4, 9) // 64B ~ 4MB
+
+var redisValueSizes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "memdb",
+	Name:      "value_size_bytes",
+	Help:      "value size distributions of get/set commands",
+	Buckets:   valueBuckets,
+}, []string{"db_name", "cmd", "key_pattern"})
+
 func init() {
 	prometheus.MustRegister(redisDurations)
+	prometheus.MustRegister(redisErrors)
+	prometheus.MustRegister(redisPipelineSize)
+	prometheus.MustRegister(redisValueSizes)
 }
 
 type StatsCollector struct {
@@ -36,7 +66,7 @@ const (
 	subsystem = "memdb_connections"
 )
 
-func registerStats(dbName string, db redis.UniversalClient) {
+func registerStats(dbName string, db redis.UniversalClient) prometheus.Collector {
 	labels := prometheus.Labels{"db_name": dbName}
 	s := &StatsCollector{
 		db: db,
@@ -79,7 +109,7 @@ func registerStats(dbName string, db redis.UniversalClient) {
 	}
 
 	prometheus.MustRegister(s)
-	return
+	return s
 }
 
 // Describe implements the prometheus.Collector interface.
@@ -31,24 +31,83 @@ func (o observer) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
 	if err := cmd.Err(); err != nil && err != redis.Nil {
 		ext.Error.Set(span, true)
 		ext.LogError(span, err)
+		redisErrors.WithLabelValues(o.name, cmd.FullName()).Inc()
 	}
 	span.Finish()
 
 	d := trace.GetDuration(span)
 	log.Get(ctx).Debugf("[memdb] %s, cost:%v", rediscmd.CmdString(cmd), d)
 
+	pattern := o.keyPatternOf(cmd)
 	redisDurations.WithLabelValues(
 		o.name,
 		cmd.FullName(),
+		pattern,
 	).Observe(d.Seconds())
 
+	o.observeValue(ctx, cmd, pattern)
+
 	return nil
 }
 
 func (o observer) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, pipelineName(cmds))
+
+	summary, stmt := rediscmd.CmdsString(cmds)
+	ext.Component.Set(span, "memdb")
+	ext.DBInstance.Set(span, o.name)
+	ext.DBStatement.Set(span, stmt)
+	span.SetTag("db.redis.cmds", summary)
+	span.SetTag("db.redis.num_cmd", len(cmds))
+
 	return ctx, nil
 }
 
 func (o observer) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	span := opentracing.SpanFromContext(ctx)
+
+	// 每个命令记录一条 span 日志，方便定位出错的命令
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		if err == nil || err == redis.Nil {
+			span.LogKV("cmd", cmd.FullName())
+			continue
+		}
+
+		ext.Error.Set(span, true)
+		span.LogKV("cmd", cmd.FullName(), "error", err.Error())
+		redisErrors.WithLabelValues(o.name, cmd.FullName()).Inc()
+	}
+	span.Finish()
+
+	for _, cmd := range cmds {
+		o.observeValue(ctx, cmd, o.keyPatternOf(cmd))
+	}
+
+	name := pipelineName(cmds)
+	d := trace.GetDuration(span)
+	_, stmt := rediscmd.CmdsString(cmds)
+	log.Get(ctx).Debugf("[memdb] %s: %s, cost:%v", name, stmt, d)
+
+	redisDurations.WithLabelValues(
+		o.name,
+		name,
+		"",
+	).Observe(d.Seconds())
+
+	size := len(cmds)
+	if name == "multi" {
+		size -= 2 // 去掉 multi/exec
+	}
+	redisPipelineSize.WithLabelValues(o.name).Observe(float64(size))
+
 	return nil
 }
+
+// 事务流水线会被 go-redis 包上 multi/exec 命令
+func pipelineName(cmds []redis.Cmder) string {
+	if len(cmds) > 0 && cmds[0].Name() == "multi" {
+		return "multi"
+	}
+	return "pipeline"
+}
@@ -0,0 +1,56 @@
+package memdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	o := observer{name: "pipeline"}
+
+	get := redis.NewStringCmd(ctx, "get", "a")
+	get.SetErr(redis.Nil)
+	incr := redis.NewIntCmd(ctx, "incr", "b")
+	incr.SetErr(errors.New("ERR value is not an integer"))
+	cmds := []redis.Cmder{
+		redis.NewStatusCmd(ctx, "multi"),
+		get,
+		incr,
+		redis.NewSliceCmd(ctx, "exec"),
+	}
+
+	ctx, err := o.BeforeProcessPipeline(ctx, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.AfterProcessPipeline(ctx, cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.ToFloat64(redisErrors.WithLabelValues("pipeline", "incr")); n != 1 {
+		t.Fatal("invalid incr errors", n)
+	}
+	if n := testutil.ToFloat64(redisErrors.WithLabelValues("pipeline", "get")); n != 0 {
+		t.Fatal("redis.Nil should not be counted", n)
+	}
+
+	h := redisDurations.WithLabelValues("pipeline", "multi", "")
+	if n := testutil.CollectAndCount(h.(prometheus.Collector)); n != 1 {
+		t.Fatal("invalid durations", n)
+	}
+
+	m := &dto.Metric{}
+	if err := redisPipelineSize.WithLabelValues("pipeline").(prometheus.Histogram).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if h := m.Histogram; h.GetSampleCount() != 1 || h.GetSampleSum() != 2 {
+		t.Fatal("invalid pipeline size", h)
+	}
+}
@@ -0,0 +1,562 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// Handler 消息处理函数，返回 error 会按退避策略重试
+type Handler func(ctx context.Context, m *Message) error
+
+// 把到期的延迟消息移动到 stream
+var moveScript = redis.NewScript(`
+local items = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, v in ipairs(items) do
+	redis.call("xadd", KEYS[2], "*", "data", v)
+	redis.call("zrem", KEYS[1], v)
+end
+return #items
+`)
+
+// 裁剪所有消费组都已经确认的消息，返回裁剪的消息数
+//
+// 每个消费组保留最早的未确认消息，没有未确认消息时保留最后投递的消息及之后的消息。
+// 没有消费组时不裁剪。go-redis v8 无法解析 redis 7 的 XINFO GROUPS 返回值，所以在脚本中计算。
+var trimScript = redis.NewScript(`
+if redis.call("exists", KEYS[1]) == 0 then
+	return 0
+end
+
+local function less(a, b)
+	local am, as = string.match(a, "(%d+)-(%d+)")
+	local bm, bs = string.match(b, "(%d+)-(%d+)")
+	if tonumber(am) ~= tonumber(bm) then
+		return tonumber(am) < tonumber(bm)
+	end
+	return tonumber(as) < tonumber(bs)
+end
+
+local minid
+for _, g in ipairs(redis.call("xinfo", "groups", KEYS[1])) do
+	local info = {}
+	for i = 1, #g, 2 do
+		info[g[i]] = g[i + 1]
+	end
+
+	local id = info["last-delivered-id"]
+	if tonumber(info["pending"]) > 0 then
+		id = redis.call("xpending", KEYS[1], info["name"])[2]
+	end
+	if minid == nil or less(id, minid) then
+		minid = id
+	end
+end
+
+if minid == nil then
+	return 0
+end
+return redis.call("xtrim", KEYS[1], "MINID", "~", minid)
+`)
+
+// 每次认领每个 stream 最多认领的消息数
+const claimBatch = 10
+
+// delivery 从 stream 读取到的消息
+type delivery struct {
+	stream string
+	msg    redis.XMessage
+	// count 投递次数，首次投递为 1，每次被认领加 1
+	count int64
+}
+
+type options struct {
+	group       string
+	concurrency int
+	maxAttempts int
+	backoff     func(attempt int) time.Duration
+	block       time.Duration
+	claimIdle   time.Duration
+	paused      func() bool
+}
+
+// Option 消费者配置
+type Option func(*options)
+
+// WithGroup 设置消费组，默认为服务名 conf.App
+//
+// 同一个消费组内的消费者分摊消息，不同消费组各自消费全部消息
+func WithGroup(group string) Option {
+	return func(o *options) { o.group = group }
+}
+
+// WithConcurrency 设置并发数，默认为 1
+func WithConcurrency(n int) Option {
+	return func(o *options) { o.concurrency = n }
+}
+
+// WithMaxAttempts 设置最大处理次数，默认为 5，超过后移入死信队列
+func WithMaxAttempts(n int) Option {
+	return func(o *options) { o.maxAttempts = n }
+}
+
+// WithBackoff 设置重试间隔，attempt 为已经重试的次数
+//
+// 默认从 1 秒开始指数增长，最长 1 小时
+func WithBackoff(f func(attempt int) time.Duration) Option {
+	return func(o *options) { o.backoff = f }
+}
+
+// WithClaimIdle 设置消息被认领的超时时间，默认 5 分钟
+//
+// 消费者崩溃后未确认的消息，超过这个时间会被其他消费者重新处理
+func WithClaimIdle(d time.Duration) Option {
+	return func(o *options) { o.claimIdle = d }
+}
+
+// WithPause 设置暂停开关，返回 true 时暂停拉取新消息
+func WithPause(f func() bool) Option {
+	return func(o *options) { o.paused = f }
+}
+
+func defaultBackoff(attempt int) time.Duration {
+	d := time.Second << attempt
+	if d <= 0 || d > time.Hour {
+		return time.Hour
+	}
+	return d
+}
+
+// Consumer 消费者
+type Consumer struct {
+	queue string
+	name  string
+	db    *memdb.Client
+	h     Handler
+	opts  options
+
+	// claimed 认领到的消息，交给处理协程处理
+	claimed chan delivery
+}
+
+// NewConsumer 创建消费者，配置错误会 panic
+func NewConsumer(queue string, h Handler, opts ...Option) *Consumer {
+	c, err := OpenConsumer(queue, h, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// OpenConsumer 创建消费者，memdb 实例配置错误时返回 error
+func OpenConsumer(queue string, h Handler, opts ...Option) (*Consumer, error) {
+	db, err := openDB(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Consumer{
+		queue: queue,
+		name:  fmt.Sprintf("%s-%d-%s", conf.Host, os.Getpid(), nonce()),
+		db:    db,
+		h:     h,
+		// 每次认领的消息不会超过缓冲区大小，认领时不会阻塞
+		claimed: make(chan delivery, 2*claimBatch),
+		opts: options{
+			group:       conf.App,
+			concurrency: 1,
+			maxAttempts: 5,
+			backoff:     defaultBackoff,
+			block:       time.Second,
+			claimIdle:   5 * time.Minute,
+			paused:      func() bool { return false },
+		},
+	}
+
+	for _, o := range opts {
+		o(&c.opts)
+	}
+
+	return c, nil
+}
+
+// Run 持续消费消息，ctx 结束后等待处理中的消息完成再返回
+func (c *Consumer) Run(ctx context.Context) error {
+	if err := c.createGroup(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.loop(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.maintain(ctx)
+	}()
+
+	wg.Wait()
+	return nil
+}
+
+// Once 处理当前所有可以消费的消息后返回，返回处理的消息数
+func (c *Consumer) Once(ctx context.Context) (int, error) {
+	if err := c.createGroup(ctx); err != nil {
+		return 0, err
+	}
+	if err := c.moveDelayed(ctx); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		msgs, err := c.read(ctx, 10, -1)
+		if err != nil {
+			return n, err
+		}
+		if len(msgs) == 0 {
+			return n, nil
+		}
+		for _, d := range msgs {
+			c.handle(ctx, d)
+			n++
+		}
+	}
+}
+
+// streams 返回消费组需要读取的 stream：队列 stream 和本消费组的重试 stream
+func (c *Consumer) streams() []string {
+	return []string{streamKey(c.queue), retryKey(c.queue, c.opts.group)}
+}
+
+// 消费组不存在则创建，从 stream 开头消费
+func (c *Consumer) createGroup(ctx context.Context) error {
+	for _, s := range c.streams() {
+		err := c.db.XGroupCreateMkStream(ctx, s, c.opts.group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) read(ctx context.Context, count int64, block time.Duration) ([]delivery, error) {
+	streams, err := c.db.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.opts.group,
+		Consumer: c.name,
+		Streams:  append(c.streams(), ">", ">"),
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []delivery
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			ds = append(ds, delivery{stream: s.Stream, msg: m, count: 1})
+		}
+	}
+	return ds, nil
+}
+
+// claim 认领超过 claimIdle 未确认的消息
+//
+// XAUTOCLAIM 不返回投递次数，所以先用 XPENDING 查询再 XCLAIM，
+// 投递次数会计入处理次数，反复导致消费者崩溃的消息最终会移入死信队列
+func (c *Consumer) claim(ctx context.Context) ([]delivery, error) {
+	var ds []delivery
+	for _, s := range c.streams() {
+		pending, err := c.db.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: s,
+			Group:  c.opts.group,
+			Idle:   c.opts.claimIdle,
+			Start:  "-",
+			End:    "+",
+			Count:  claimBatch,
+		}).Result()
+		if err != nil {
+			return ds, err
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		ids := make([]string, 0, len(pending))
+		counts := make(map[string]int64, len(pending))
+		for _, p := range pending {
+			ids = append(ids, p.ID)
+			counts[p.ID] = p.RetryCount
+		}
+
+		msgs, err := c.db.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   s,
+			Group:    c.opts.group,
+			Consumer: c.name,
+			MinIdle:  c.opts.claimIdle,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			return ds, err
+		}
+		for _, m := range msgs {
+			// XCLAIM 会把投递次数加 1
+			ds = append(ds, delivery{stream: s, msg: m, count: counts[m.ID] + 1})
+		}
+	}
+	return ds, nil
+}
+
+func (c *Consumer) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		if c.opts.paused() {
+			sleep(ctx, c.opts.block)
+			continue
+		}
+
+		// 优先处理认领到的消息
+		select {
+		case d := <-c.claimed:
+			c.handle(context.Background(), d)
+			continue
+		default:
+		}
+
+		// 不使用 ctx 读取，保证退出时已经读到的消息能处理完
+		msgs, err := c.read(context.Background(), 1, c.opts.block)
+		if err != nil {
+			log.Get(ctx).Errorf("[queue] %s read error: %v", c.queue, err)
+			sleep(ctx, c.opts.block)
+			continue
+		}
+		for _, d := range msgs {
+			c.handle(context.Background(), d)
+		}
+	}
+}
+
+// maintain 定期移动延迟消息，裁剪已经确认的消息，并认领崩溃的消费者未确认的消息
+//
+// 认领到的消息交给处理协程，不会阻塞延迟消息的移动。
+// 退出时还没处理的消息超过 claimIdle 后会被再次认领。
+func (c *Consumer) maintain(ctx context.Context) {
+	t := time.NewTicker(c.opts.block)
+	defer t.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if err := c.moveDelayed(ctx); err != nil {
+			log.Get(ctx).Errorf("[queue] %s move delayed error: %v", c.queue, err)
+		}
+
+		if time.Since(last) < c.opts.claimIdle/2 {
+			continue
+		}
+		last = time.Now()
+
+		if err := c.trim(ctx); err != nil {
+			log.Get(ctx).Errorf("[queue] %s trim error: %v", c.queue, err)
+		}
+
+		// 上次认领的消息还没处理完时不再认领
+		if c.opts.paused() || len(c.claimed) > 0 {
+			continue
+		}
+
+		msgs, err := c.claim(ctx)
+		if err != nil {
+			log.Get(ctx).Errorf("[queue] %s claim error: %v", c.queue, err)
+		}
+		for _, d := range msgs {
+			c.claimed <- d
+		}
+	}
+}
+
+// trim 裁剪队列 stream 和本消费组的重试 stream 中所有消费组都已经确认的消息
+func (c *Consumer) trim(ctx context.Context) error {
+	for _, s := range c.streams() {
+		n, err := trimScript.Run(ctx, c.db, []string{s}).Int64()
+		if err != nil {
+			return err
+		}
+		queueTrimmed.WithLabelValues(c.queue).Add(float64(n))
+	}
+	return nil
+}
+
+// moveDelayed 把到期的延迟消息移动到队列 stream，到期的重试消息移动到本消费组的重试 stream
+func (c *Consumer) moveDelayed(ctx context.Context) error {
+	now := time.Now().UnixMilli()
+
+	err := moveScript.Run(ctx, c.db, []string{delayedKey(c.queue), streamKey(c.queue)},
+		now, 100).Err()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{retryDelayedKey(c.queue, c.opts.group), retryKey(c.queue, c.opts.group)}
+	return moveScript.Run(ctx, c.db, keys, now, 100).Err()
+}
+
+func (c *Consumer) handle(ctx context.Context, d delivery) {
+	xm := d.msg
+	m := &Message{}
+	data, _ := xm.Values["data"].(string)
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		log.Get(ctx).Errorf("[queue] %s invalid message %s: %v", c.queue, xm.ID, err)
+		c.dead(ctx, d.stream, xm.ID, data, err)
+		return
+	}
+	m.ID = xm.ID
+	m.Queue = c.queue
+	m.Attempt += int(d.count - 1)
+
+	// 消费者 span 跟随生产者 span
+	var span opentracing.Span
+	tracer := opentracing.GlobalTracer()
+	parent, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(m.Header))
+	if err == nil {
+		span = tracer.StartSpan("Dequeue", opentracing.FollowsFrom(parent))
+	} else {
+		span = tracer.StartSpan("Dequeue")
+	}
+	defer span.Finish()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	span.SetTag("queue", c.queue)
+	span.SetTag("message_id", m.ID)
+	span.SetTag("attempt", m.Attempt)
+
+	logger := log.Get(ctx).WithFields(log.Fields{
+		"queue":      c.queue,
+		"message_id": m.ID,
+		"attempt":    m.Attempt,
+	})
+
+	// 被认领多次的消息可能会导致消费者崩溃，超过最大处理次数后不再处理
+	if m.Attempt >= c.opts.maxAttempts {
+		err = fmt.Errorf("delivered %d times, exceeds max attempts %d", d.count, c.opts.maxAttempts)
+		ext.Error.Set(span, true)
+		ext.LogError(span, err)
+		logger.Errorf("[queue] message dead: %v", err)
+		queueHandled.WithLabelValues(c.queue, "dead").Inc()
+		c.dead(ctx, d.stream, m.ID, data, err)
+		return
+	}
+
+	s := time.Now()
+	err = c.call(ctx, m)
+	queueDurations.WithLabelValues(c.queue).Observe(time.Since(s).Seconds())
+
+	if err == nil {
+		queueHandled.WithLabelValues(c.queue, "ok").Inc()
+		c.ack(ctx, d.stream, m.ID)
+		return
+	}
+
+	ext.Error.Set(span, true)
+	ext.LogError(span, err)
+
+	if m.Attempt+1 >= c.opts.maxAttempts {
+		logger.Errorf("[queue] message dead: %v", err)
+		queueHandled.WithLabelValues(c.queue, "dead").Inc()
+		c.dead(ctx, d.stream, m.ID, data, err)
+		return
+	}
+
+	logger.Warnf("[queue] message retry: %v", err)
+	queueHandled.WithLabelValues(c.queue, "retry").Inc()
+	c.retry(ctx, d.stream, m)
+}
+
+func (c *Consumer) call(ctx context.Context, m *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("%+v stack: %s", r, string(debug.Stack())))
+		}
+	}()
+
+	return c.h(ctx, m)
+}
+
+func (c *Consumer) ack(ctx context.Context, stream, id string) {
+	if err := c.db.XAck(ctx, stream, c.opts.group, id).Err(); err != nil {
+		log.Get(ctx).Errorf("[queue] %s ack %s error: %v", c.queue, id, err)
+	}
+}
+
+// retry 放入本消费组的延迟集合并确认原消息，到期后只有本消费组会再次处理
+func (c *Consumer) retry(ctx context.Context, stream string, m *Message) {
+	id := m.ID
+	m.Attempt++
+	m.Nonce = nonce()
+	at := time.Now().Add(c.opts.backoff(m.Attempt))
+
+	_, err := c.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		p.ZAdd(ctx, retryDelayedKey(c.queue, c.opts.group),
+			&redis.Z{Score: float64(at.UnixMilli()), Member: data})
+		p.XAck(ctx, stream, c.opts.group, id)
+		return nil
+	})
+	if err != nil {
+		log.Get(ctx).Errorf("[queue] %s retry %s error: %v", c.queue, id, err)
+	}
+}
+
+// dead 移入死信队列并确认原消息
+func (c *Consumer) dead(ctx context.Context, stream, id, data string, cause error) {
+	_, err := c.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, &redis.XAddArgs{
+			Stream: deadKey(c.queue),
+			MaxLen: maxLen(c.queue),
+			Approx: true,
+			Values: []any{"data", data, "id", id, "group", c.opts.group, "error", cause.Error()},
+		})
+		p.XAck(ctx, stream, c.opts.group, id)
+		return nil
+	})
+	if err != nil {
+		log.Get(ctx).Errorf("[queue] %s dead %s error: %v", c.queue, id, err)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 5, 10, 60}
+
+var queueEnqueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "queue",
+	Name:      "enqueued_total",
+	Help:      "messages enqueued",
+}, []string{"queue"})
+
+var queueHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "queue",
+	Name:      "handled_total",
+	Help:      "messages handled by result",
+}, []string{"queue", "result"})
+
+var queueDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "queue",
+	Name:      "handle_duration_seconds",
+	Help:      "message handle latency distributions",
+	Buckets:   defBuckets,
+}, []string{"queue"})
+
+var queueTrimmed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "queue",
+	Name:      "trimmed_total",
+	Help:      "acknowledged messages trimmed from streams",
+}, []string{"queue"})
+
+func init() {
+	prometheus.MustRegister(queueEnqueued)
+	prometheus.MustRegister(queueHandled)
+	prometheus.MustRegister(queueDurations)
+	prometheus.MustRegister(queueTrimmed)
+}
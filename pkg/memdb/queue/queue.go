@@ -0,0 +1,178 @@
+// Package queue 基于 redis stream 实现的可靠消息队列
+//
+//	queue.Enqueue(ctx, "email", payload)
+//
+//	c := queue.NewConsumer("email", func(ctx context.Context, m *queue.Message) error {
+//		return send(m.Payload)
+//	}, queue.WithConcurrency(4))
+//	c.Run(ctx)
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/memdb"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+)
+
+// Message 队列消息
+type Message struct {
+	// ID stream 消息编号，重试后会变化
+	ID string `json:"-"`
+	// Queue 队列名
+	Queue string `json:"-"`
+	// Payload 消息内容
+	Payload []byte `json:"payload"`
+	// Attempt 已经处理过的次数，首次投递为 0
+	//
+	// 包括处理失败后的重试，以及消费者崩溃后被其他消费者认领的重新投递
+	Attempt int `json:"attempt"`
+	// Header 消息头，包含链路追踪信息
+	Header map[string]string `json:"header,omitempty"`
+	// Enqueued 首次入队时间
+	Enqueued time.Time `json:"enqueued"`
+	// Nonce 保证延迟消息在有序集合中不重复
+	Nonce string `json:"nonce"`
+}
+
+// 同一个队列的 key 使用相同的 hash tag，保证集群模式下 Lua 脚本可以执行
+func streamKey(queue string) string  { return "queue:{" + queue + "}" }
+func delayedKey(queue string) string { return "queue:{" + queue + "}:delayed" }
+func deadKey(queue string) string    { return "queue:{" + queue + "}:dead" }
+
+// 重试的消息只投递给处理失败的消费组，每个消费组有自己的延迟集合和重试 stream
+func retryKey(queue, group string) string { return "queue:{" + queue + "}:retry:" + group }
+func retryDelayedKey(queue, group string) string {
+	return "queue:{" + queue + "}:delayed:" + group
+}
+
+// 默认死信 stream 最多保留的消息数
+const defaultMaxLen = 1000000
+
+// maxLen 返回死信 stream 最多保留的消息数，为零则不裁剪
+//
+// 队列 stream 和重试 stream 只裁剪所有消费组都已经确认的消息，不受这个配置影响。
+//
+// 优先读取 QUEUE_MAXLEN_queue，其次读取 QUEUE_MAXLEN，默认为 defaultMaxLen，
+// 配置为负数则不裁剪
+func maxLen(queue string) int64 {
+	n := conf.GetInt64("QUEUE_MAXLEN_" + queue)
+	if n == 0 {
+		n = conf.GetInt64("QUEUE_MAXLEN")
+	}
+	if n == 0 {
+		return defaultMaxLen
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// db 返回队列使用的 memdb 实例，配置错误会 panic
+func db(queue string) *memdb.Client {
+	db, err := openDB(queue)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// openDB 返回队列使用的 memdb 实例，配置错误时返回 error
+//
+// 实例名优先读取 QUEUE_MEMDB_queue，其次读取 QUEUE_MEMDB，默认为 default
+func openDB(queue string) (*memdb.Client, error) {
+	name := conf.Get("QUEUE_MEMDB_" + queue)
+	if name == "" {
+		name = conf.Get("QUEUE_MEMDB")
+	}
+	if name == "" {
+		name = "default"
+	}
+	return memdb.Open(name)
+}
+
+// Enqueue 发送消息，返回消息编号
+func Enqueue(ctx context.Context, queue string, payload []byte) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Enqueue")
+	defer span.Finish()
+	span.SetTag("queue", queue)
+
+	m := newMessage(ctx, payload)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := db(queue).XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(queue),
+		Values: []any{"data", data},
+	}).Result()
+	if err != nil {
+		return "", err
+	}
+
+	queueEnqueued.WithLabelValues(queue).Inc()
+	return id, nil
+}
+
+// EnqueueIn 发送延迟消息，delay 之后才能被消费
+func EnqueueIn(ctx context.Context, queue string, payload []byte, delay time.Duration) error {
+	return EnqueueAt(ctx, queue, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt 发送定时消息，at 之后才能被消费
+//
+// 延迟消息先保存在有序集合中，由消费者定期移动到 stream，实际投递时间可能晚于 at
+func EnqueueAt(ctx context.Context, queue string, payload []byte, at time.Time) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "EnqueueAt")
+	defer span.Finish()
+	span.SetTag("queue", queue)
+
+	if err := schedule(ctx, db(queue), queue, newMessage(ctx, payload), at); err != nil {
+		return err
+	}
+
+	queueEnqueued.WithLabelValues(queue).Inc()
+	return nil
+}
+
+func schedule(ctx context.Context, db *memdb.Client, queue string, m *Message, at time.Time) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return db.ZAdd(ctx, delayedKey(queue), &redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+func newMessage(ctx context.Context, payload []byte) *Message {
+	m := &Message{
+		Payload:  payload,
+		Header:   map[string]string{},
+		Enqueued: time.Now(),
+		Nonce:    nonce(),
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		opentracing.GlobalTracer().Inject(span.Context(),
+			opentracing.TextMap, opentracing.TextMapCarrier(m.Header))
+	}
+
+	return m
+}
+
+func nonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,392 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+func setup(t *testing.T, queue string) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	name := "test_" + t.Name()
+	conf.Set("MEMDB_DSN_"+name, "redis://"+mr.Addr())
+	conf.Set("QUEUE_MEMDB_"+queue, name)
+	return mr
+}
+
+func TestQueue(t *testing.T) {
+	setup(t, "foo")
+	ctx := context.Background()
+
+	if _, err := Enqueue(ctx, "foo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	c := NewConsumer("foo", func(ctx context.Context, m *Message) error {
+		got = append(got, string(m.Payload))
+		if m.Queue != "foo" || m.Attempt != 0 {
+			t.Fatal("invalid message", m)
+		}
+		return nil
+	})
+
+	n, err := c.Once(ctx)
+	if err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if len(got) != 1 || got[0] != "hello" {
+		t.Fatal("invalid payload", got)
+	}
+
+	pending, err := c.db.XPending(ctx, streamKey("foo"), c.opts.group).Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatal("message should be acked", pending, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	setup(t, "bar")
+	ctx := context.Background()
+
+	if err := EnqueueIn(ctx, "bar", []byte("later"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnqueueAt(ctx, "bar", []byte("now"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	c := NewConsumer("bar", func(ctx context.Context, m *Message) error {
+		got = append(got, string(m.Payload))
+		return nil
+	})
+
+	n, err := c.Once(ctx)
+	if err != nil || n != 1 || got[0] != "now" {
+		t.Fatal(n, err, got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	setup(t, "baz")
+	ctx := context.Background()
+
+	if _, err := Enqueue(ctx, "baz", []byte("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	c := NewConsumer("baz", func(ctx context.Context, m *Message) error {
+		if m.Attempt != attempts {
+			t.Fatal("invalid attempt", m.Attempt)
+		}
+		attempts++
+		if attempts == 2 {
+			panic("boom")
+		}
+		return errors.New("boom")
+	}, WithMaxAttempts(3), WithBackoff(func(int) time.Duration { return 0 }))
+
+	for i := 0; i < 3; i++ {
+		n, err := c.Once(ctx)
+		if err != nil || n != 1 {
+			t.Fatal(i, n, err)
+		}
+	}
+	if attempts != 3 {
+		t.Fatal("invalid attempts", attempts)
+	}
+
+	if n, _ := c.Once(ctx); n != 0 {
+		t.Fatal("message should be dead", n)
+	}
+
+	msgs, err := c.db.XRange(ctx, deadKey("baz"), "-", "+").Result()
+	if err != nil || len(msgs) != 1 {
+		t.Fatal(msgs, err)
+	}
+	if msgs[0].Values["error"] != "boom" {
+		t.Fatal("invalid error", msgs[0].Values)
+	}
+}
+
+func TestRun(t *testing.T) {
+	setup(t, "qux")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan string, 1)
+	c := NewConsumer("qux", func(ctx context.Context, m *Message) error {
+		done <- string(m.Payload)
+		return nil
+	}, WithConcurrency(2))
+	c.opts.block = 10 * time.Millisecond
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+
+	if _, err := Enqueue(ctx, "qux", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-done:
+		if s != "hi" {
+			t.Fatal("invalid payload", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not handled")
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupRetry(t *testing.T) {
+	setup(t, "grp")
+	ctx := context.Background()
+
+	if _, err := Enqueue(ctx, "grp", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	var a, b []int
+	ca := NewConsumer("grp", func(ctx context.Context, m *Message) error {
+		a = append(a, m.Attempt)
+		if m.Attempt == 0 {
+			return errors.New("boom")
+		}
+		return nil
+	}, WithGroup("a"), WithBackoff(func(int) time.Duration { return 0 }))
+	cb := NewConsumer("grp", func(ctx context.Context, m *Message) error {
+		b = append(b, m.Attempt)
+		return nil
+	}, WithGroup("b"))
+
+	for i := 0; i < 2; i++ {
+		if n, err := ca.Once(ctx); err != nil || n != 1 {
+			t.Fatal(i, n, err)
+		}
+		if n, err := cb.Once(ctx); err != nil || n != 1-i {
+			t.Fatal(i, n, err)
+		}
+	}
+
+	if len(a) != 2 || a[0] != 0 || a[1] != 1 {
+		t.Fatal("group a should retry once", a)
+	}
+	if len(b) != 1 || b[0] != 0 {
+		t.Fatal("group b should not see the retry", b)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	setup(t, "clm")
+	ctx := context.Background()
+
+	if _, err := Enqueue(ctx, "clm", []byte("crash")); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts []int
+	c := NewConsumer("clm", func(ctx context.Context, m *Message) error {
+		attempts = append(attempts, m.Attempt)
+		return nil
+	}, WithMaxAttempts(2), WithClaimIdle(10*time.Millisecond))
+	if err := c.createGroup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取后不处理，模拟消费者崩溃
+	if ds, err := c.read(ctx, 1, -1); err != nil || len(ds) != 1 {
+		t.Fatal(ds, err)
+	}
+
+	// 认领后同样不处理，每次认领投递次数加 1
+	var d delivery
+	for _, want := range []int64{2, 3} {
+		time.Sleep(20 * time.Millisecond)
+		ds, err := c.claim(ctx)
+		if err != nil || len(ds) != 1 || ds[0].count != want {
+			t.Fatal(want, ds, err)
+		}
+		d = ds[0]
+	}
+
+	// 投递 3 次超过了最大处理次数，不再调用处理函数
+	c.handle(ctx, d)
+	if len(attempts) != 0 {
+		t.Fatal("message should not be handled", attempts)
+	}
+
+	msgs, err := c.db.XRange(ctx, deadKey("clm"), "-", "+").Result()
+	if err != nil || len(msgs) != 1 {
+		t.Fatal(msgs, err)
+	}
+	pending, err := c.db.XPending(ctx, streamKey("clm"), c.opts.group).Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatal("message should be acked", pending, err)
+	}
+}
+
+func TestRunClaim(t *testing.T) {
+	setup(t, "rclm")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Enqueue(ctx, "rclm", []byte("crash")); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan *Message, 1)
+	c := NewConsumer("rclm", func(ctx context.Context, m *Message) error {
+		handled <- m
+		return nil
+	}, WithClaimIdle(20*time.Millisecond))
+	c.opts.block = 10 * time.Millisecond
+	if err := c.createGroup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取后不处理，模拟消费者崩溃
+	if ds, err := c.read(ctx, 1, -1); err != nil || len(ds) != 1 {
+		t.Fatal(ds, err)
+	}
+
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	select {
+	case m := <-handled:
+		if m.Attempt != 1 {
+			t.Fatal("invalid attempt", m.Attempt)
+		}
+	case <-ctx.Done():
+		t.Fatal("claimed message should be handled")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenConsumer(t *testing.T) {
+	conf.Set("MEMDB_DSN_test_"+t.Name(), "")
+	conf.Set("QUEUE_MEMDB_open", "test_"+t.Name())
+
+	h := func(ctx context.Context, m *Message) error { return nil }
+	if _, err := OpenConsumer("open", h); err == nil {
+		t.Fatal("invalid dsn should fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewConsumer should panic")
+		}
+	}()
+	NewConsumer("open", h)
+}
+
+func TestTrim(t *testing.T) {
+	setup(t, "trim")
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := Enqueue(ctx, "trim", []byte("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	h := func(ctx context.Context, m *Message) error { return nil }
+	c1 := NewConsumer("trim", h, WithGroup("g1"))
+	c2 := NewConsumer("trim", h, WithGroup("g2"))
+
+	// 没有消费组时不裁剪
+	if err := c1.trim(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c1.db.XLen(ctx, streamKey("trim")).Result(); n != 3 {
+		t.Fatal("stream should not be trimmed", n)
+	}
+
+	if n, err := c1.Once(ctx); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	if err := c2.createGroup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// g2 读取两条消息，只确认第一条
+	ds, err := c2.read(ctx, 2, -1)
+	if err != nil || len(ds) != 2 {
+		t.Fatal(ds, err)
+	}
+	c2.ack(ctx, ds[0].stream, ds[0].msg.ID)
+
+	// 保留 g2 最早未确认的消息
+	if err := c1.trim(ctx); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c1.db.XRange(ctx, streamKey("trim"), "-", "+").Result()
+	if err != nil || len(msgs) != 2 || msgs[0].ID != ids[1] {
+		t.Fatal("unacked messages should be kept", msgs, err)
+	}
+
+	// 全部确认后只保留最后投递的消息
+	c2.ack(ctx, ds[1].stream, ds[1].msg.ID)
+	if n, err := c2.Once(ctx); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if err := c2.trim(ctx); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = c1.db.XRange(ctx, streamKey("trim"), "-", "+").Result()
+	if err != nil || len(msgs) != 1 || msgs[0].ID != ids[2] {
+		t.Fatal("acked messages should be trimmed", msgs, err)
+	}
+}
+
+func TestMaxLen(t *testing.T) {
+	setup(t, "dmax")
+	conf.Set("QUEUE_MAXLEN_dmax", 2)
+	defer conf.Set("QUEUE_MAXLEN_dmax", 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := Enqueue(ctx, "dmax", []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewConsumer("dmax", func(ctx context.Context, m *Message) error {
+		return errors.New("failed")
+	}, WithMaxAttempts(1))
+	if n, err := c.Once(ctx); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+
+	// 只有死信 stream 按数量裁剪，miniredis 会精确裁剪，真实的 redis 使用 ~ 近似裁剪
+	if n, err := c.db.XLen(ctx, deadKey("dmax")).Result(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if n, err := c.db.XLen(ctx, streamKey("dmax")).Result(); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+
+	if maxLen("foo") != defaultMaxLen {
+		t.Fatal("invalid default maxlen", maxLen("foo"))
+	}
+	conf.Set("QUEUE_MAXLEN_dmax", -1)
+	if maxLen("dmax") != 0 {
+		t.Fatal("negative maxlen should disable trimming")
+	}
+}
@@ -0,0 +1,113 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+// ErrTooManyQueries 排队等待执行的 sql 超过上限
+var ErrTooManyQueries = errors.New("sqldb: too many queries waiting")
+
+// limiter 限制同时执行的 sql 数量
+//
+// 超过 SQLDB_MAX_INFLIGHT_name 的请求需要排队，
+// 排队数量超过 SQLDB_MAX_QUEUE_name 则直接返回 ErrTooManyQueries，
+// 防止一个慢库拖垮整个服务。排队数量默认与 SQLDB_MAX_INFLIGHT_name 相同。
+type limiter struct {
+	name     string
+	sem      chan struct{}
+	waiting  int64
+	maxQueue int64
+}
+
+func newLimiter(name string) *limiter {
+	l := &limiter{name: name}
+
+	if n := conf.GetInt("SQLDB_MAX_INFLIGHT_" + name); n > 0 {
+		l.sem = make(chan struct{}, n)
+		l.maxQueue = conf.GetInt64("SQLDB_MAX_QUEUE_" + name)
+		if l.maxQueue <= 0 {
+			l.maxQueue = int64(n)
+		}
+	}
+
+	return l
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil || l.sem == nil {
+		return nil
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		sqlInflight.WithLabelValues(l.name).Inc()
+		return nil
+	default:
+	}
+
+	if w := atomic.AddInt64(&l.waiting, 1); w > l.maxQueue {
+		atomic.AddInt64(&l.waiting, -1)
+		return ErrTooManyQueries
+	}
+	sqlWaiting.WithLabelValues(l.name).Inc()
+	defer func() {
+		atomic.AddInt64(&l.waiting, -1)
+		sqlWaiting.WithLabelValues(l.name).Dec()
+	}()
+
+	select {
+	case l.sem <- struct{}{}:
+		sqlInflight.WithLabelValues(l.name).Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	if l == nil || l.sem == nil {
+		return
+	}
+
+	<-l.sem
+	sqlInflight.WithLabelValues(l.name).Dec()
+}
+
+// limit 申请执行名额并设置默认超时
+//
+// sql 执行完成之后必须调用 release 释放名额，不再使用 ctx 之后调用 cancel。
+// query 执行完成即可释放名额，但超时需要持续到 rows 关闭。
+// 超时时间读取 SQLDB_TIMEOUT_name，只会缩短 ctx 已有的超时时间。
+func (o observer) limit(ctx context.Context) (_ context.Context, release, cancel func(), err error) {
+	if err := o.limiter.acquire(ctx); err != nil {
+		return ctx, nil, nil, err
+	}
+
+	cancel = func() {}
+	if d := conf.GetDuration("SQLDB_TIMEOUT_" + o.name); d > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	}
+
+	return ctx, o.limiter.release, cancel, nil
+}
+
+// setPool 使用配置调整连接池参数，未配置的保持 database/sql 默认值
+func setPool(db *sql.DB, name string) {
+	if n := conf.GetInt("SQLDB_MAX_OPEN_CONNS_" + name); n > 0 {
+		db.SetMaxOpenConns(n)
+	}
+	if n := conf.GetInt("SQLDB_MAX_IDLE_CONNS_" + name); n > 0 {
+		db.SetMaxIdleConns(n)
+	}
+	if d := conf.GetDuration("SQLDB_CONN_MAX_LIFETIME_" + name); d > 0 {
+		db.SetConnMaxLifetime(d)
+	}
+	if d := conf.GetDuration("SQLDB_CONN_MAX_IDLE_TIME_" + name); d > 0 {
+		db.SetConnMaxIdleTime(d)
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1}
 
+var rowBuckets = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000}
+
 var sqlDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Namespace: "sniper",
 	Subsystem: "sqldb",
@@ -14,6 +16,48 @@ var sqlDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Buckets:   defBuckets,
 }, []string{"db_name", "table", "cmd"})
 
+var sqlErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "sniper",
+	Subsystem: "sqldb",
+	Name:      "sql_errors_total",
+	Help:      "sql errors by class",
+}, []string{"db_name", "table", "cmd", "class"})
+
+var sqlRowsAffected = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "sqldb",
+	Name:      "sql_rows_affected",
+	Help:      "rows affected by exec distributions",
+	Buckets:   rowBuckets,
+}, []string{"db_name", "table", "cmd"})
+
+var sqlRowsReturned = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "sqldb",
+	Name:      "sql_rows_returned",
+	Help:      "rows returned by query distributions",
+	Buckets:   rowBuckets,
+}, []string{"db_name", "table", "cmd"})
+
+var sqlInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "sniper",
+	Subsystem: "sqldb",
+	Name:      "sql_inflight",
+	Help:      "sql executing under concurrency limit",
+}, []string{"db_name"})
+
+var sqlWaiting = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "sniper",
+	Subsystem: "sqldb",
+	Name:      "sql_waiting",
+	Help:      "sql waiting for concurrency limit",
+}, []string{"db_name"})
+
 func init() {
 	prometheus.MustRegister(sqlDurations)
+	prometheus.MustRegister(sqlErrors)
+	prometheus.MustRegister(sqlRowsAffected)
+	prometheus.MustRegister(sqlRowsReturned)
+	prometheus.MustRegister(sqlInflight)
+	prometheus.MustRegister(sqlWaiting)
 }
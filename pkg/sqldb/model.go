@@ -70,6 +70,15 @@ func (db *DB) Update(m Modeler) (sql.Result, error) {
 	return db.UpdateContext(context.Background(), m)
 }
 
+// UpsertContext 生成并执行 upsert 语句，主键冲突时更新其他字段
+func (db *DB) UpsertContext(ctx context.Context, m Modeler) (sql.Result, error) {
+	return upsert(ctx, db, m)
+}
+
+func (db *DB) Upsert(m Modeler) (sql.Result, error) {
+	return db.UpsertContext(context.Background(), m)
+}
+
 // InsertContext 生成并执行 insert 语句
 func (tx *Tx) InsertContext(ctx context.Context, m Modeler) (sql.Result, error) {
 	return insert(ctx, tx, m)
@@ -88,6 +97,27 @@ func (tx *Tx) Update(m Modeler) (sql.Result, error) {
 	return tx.UpdateContext(context.Background(), m)
 }
 
+// UpsertContext 生成并执行 upsert 语句，主键冲突时更新其他字段
+func (tx *Tx) UpsertContext(ctx context.Context, m Modeler) (sql.Result, error) {
+	return upsert(ctx, tx, m)
+}
+
+func (tx *Tx) Upsert(m Modeler) (sql.Result, error) {
+	return tx.UpsertContext(context.Background(), m)
+}
+
+// Quote 按数据库方言给表名或者字段名加引号，用于手写的 sql
+//
+//	q := db.Rebind("SELECT * FROM " + db.Quote("order") + " WHERE id = ?")
+func (db *DB) Quote(name string) string {
+	return quote(db, name)
+}
+
+// Quote 按数据库方言给表名或者字段名加引号，用于手写的 sql
+func (tx *Tx) Quote(name string) string {
+	return quote(tx, name)
+}
+
 // 添加 GetMapper 方法，方便与 Tx 统一
 func (db *DB) GetMapper() *reflectx.Mapper {
 	return db.Mapper
@@ -121,18 +151,22 @@ func insert(ctx context.Context, db mapExecer, m Modeler) (sql.Result, error) {
 		names = append(names[:k], names[k+1:]...)
 	}
 	marks = marks[:len(marks)-1]
-	query := "INSERT INTO " + m.TableName() + "(" + strings.Join(names, ",") + ") VALUES (" + marks + ")"
+	query := "INSERT INTO " + quote(db, m.TableName()) + "(" + quoteAll(db, names) + ") VALUES (" + marks + ")"
 	query = db.Rebind(query)
 	return db.ExecContext(ctx, query, args...)
 }
 
 func update(ctx context.Context, db mapExecer, m Modeler) (sql.Result, error) {
+	if m.KeyName() == "" {
+		return nil, fmt.Errorf("sqldb: update %s without primary key", m.TableName())
+	}
+
 	names, args, err := bindModeler(m, db.GetMapper())
 	if err != nil {
 		return nil, err
 	}
 
-	query := "UPDATE " + m.TableName() + " set "
+	query := "UPDATE " + quote(db, m.TableName()) + " set "
 	var id any
 	for i := 0; i < len(names); i++ {
 		name := names[i]
@@ -141,14 +175,91 @@ func update(ctx context.Context, db mapExecer, m Modeler) (sql.Result, error) {
 			args = append(args[:i], args[i+1:]...)
 			continue
 		}
-		query += name + "=?,"
+		query += quote(db, name) + "=?,"
 	}
-	query = query[:len(query)-1] + " WHERE " + m.KeyName() + " = ?"
+	query = query[:len(query)-1] + " WHERE " + quote(db, m.KeyName()) + " = ?"
 	query = db.Rebind(query)
 	args = append(args, id)
 	return db.ExecContext(ctx, query, args...)
 }
 
+// upsert 根据数据库方言生成语句
+//
+// mysql 使用 ON DUPLICATE KEY UPDATE，sqlite 和 postgres 使用 ON CONFLICT
+func upsert(ctx context.Context, db mapExecer, m Modeler) (sql.Result, error) {
+	if m.KeyName() == "" {
+		return nil, fmt.Errorf("sqldb: upsert %s without primary key", m.TableName())
+	}
+
+	names, args, err := bindModeler(m, db.GetMapper())
+	if err != nil {
+		return nil, err
+	}
+
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	query := "INSERT INTO " + quote(db, m.TableName()) + "(" + quoteAll(db, names) + ") VALUES (" + marks + ")"
+
+	var sets []string
+	key := quote(db, m.KeyName())
+	isMySQL := dialect(db.DriverName()) == dialectMySQL
+	for _, name := range names {
+		if name == m.KeyName() {
+			continue
+		}
+		name = quote(db, name)
+		if isMySQL {
+			sets = append(sets, name+"=VALUES("+name+")")
+		} else {
+			sets = append(sets, name+"=excluded."+name)
+		}
+	}
+
+	switch {
+	case isMySQL && len(sets) == 0:
+		query += " ON DUPLICATE KEY UPDATE " + key + "=" + key
+	case isMySQL:
+		query += " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+	case len(sets) == 0:
+		query += " ON CONFLICT(" + key + ") DO NOTHING"
+	default:
+		query += " ON CONFLICT(" + key + ") DO UPDATE SET " + strings.Join(sets, ",")
+	}
+
+	query = db.Rebind(query)
+	return db.ExecContext(ctx, query, args...)
+}
+
+// quote 按数据库方言给表名或者字段名加引号，避免与关键字冲突
+//
+// mysql 使用反引号，sqlite 和 postgres 使用双引号。
+// 带 schema 的表名分段加引号，已经带引号的名称保持不变。
+//
+// "public.users" => "public"."users"
+func quote(db mapExecer, name string) string {
+	if strings.ContainsAny(name, "`\"") {
+		return name
+	}
+
+	q := `"`
+	if dialect(db.DriverName()) == dialectMySQL {
+		q = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = q + p + q
+	}
+	return strings.Join(parts, ".")
+}
+
+func quoteAll(db mapExecer, names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quote(db, name)
+	}
+	return strings.Join(quoted, ",")
+}
+
 func bindModeler(arg any, m *reflectx.Mapper) ([]string, []any, error) {
 	t := reflect.TypeOf(arg)
 	names := []string{}
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"time"
 
+	"github.com/go-kiss/sniper/pkg/conf"
 	"github.com/go-kiss/sniper/pkg/log"
 	"github.com/ngrok/sqlmw"
 	"github.com/opentracing/opentracing-go"
@@ -14,7 +15,8 @@ import (
 // 观察所有 sql 执行情况
 type observer struct {
 	sqlmw.NullInterceptor
-	name string
+	name    string
+	limiter *limiter
 }
 
 var _ sqlmw.Interceptor = observer{}
@@ -30,6 +32,15 @@ func (o observer) ConnExecContext(ctx context.Context,
 	ext.DBInstance.Set(span, o.name)
 	ext.DBStatement.Set(span, query)
 
+	ctx, release, cancel, err := o.limit(ctx)
+	if err != nil {
+		table, cmd := parseSQL(query)
+		o.fail(span, table, cmd, err)
+		return nil, err
+	}
+	defer cancel()
+	defer release()
+
 	s := time.Now()
 	result, err := conn.ExecContext(ctx, query, args)
 	d := time.Since(s)
@@ -44,6 +55,9 @@ func (o observer) ConnExecContext(ctx context.Context,
 		cmd,
 	).Observe(d.Seconds())
 
+	o.fail(span, table, cmd, err)
+	o.observeExec(ctx, query, args, d, table, cmd, result, err)
+
 	return result, err
 }
 
@@ -58,9 +72,18 @@ func (o observer) ConnQueryContext(ctx context.Context,
 	ext.DBInstance.Set(span, o.name)
 	ext.DBStatement.Set(span, query)
 
+	ctx, release, cancel, err := o.limit(ctx)
+	if err != nil {
+		table, cmd := parseSQL(query)
+		o.fail(span, table, cmd, err)
+		return ctx, nil, err
+	}
+
 	s := time.Now()
 	rows, err := conn.QueryContext(ctx, query, args)
 	d := time.Since(s)
+	// 读取 rows 期间不占用名额，避免遍历 rows 时嵌套查询死锁
+	release()
 
 	log.Get(ctx).Debugf("[sqldb] name:%s, query: %s, args: %v, cost: %v",
 		o.name, query, values(args), d)
@@ -72,6 +95,13 @@ func (o observer) ConnQueryContext(ctx context.Context,
 		cmd,
 	).Observe(d.Seconds())
 
+	o.fail(span, table, cmd, err)
+	if err != nil {
+		cancel()
+		return ctx, rows, err
+	}
+	ctx = o.observeQuery(ctx, query, args, d, table, cmd, cancel)
+
 	return ctx, rows, err
 }
 
@@ -100,6 +130,8 @@ func (o observer) ConnPrepareContext(ctx context.Context,
 		"prepare",
 	).Observe(d.Seconds())
 
+	o.fail(span, table, "prepare", err)
+
 	return ctx, stmt, err
 }
 
@@ -114,6 +146,15 @@ func (o observer) StmtExecContext(ctx context.Context,
 	ext.DBInstance.Set(span, o.name)
 	ext.DBStatement.Set(span, query)
 
+	ctx, release, cancel, err := o.limit(ctx)
+	if err != nil {
+		table, cmd := parseSQL(query)
+		o.fail(span, table, cmd+"-prepared", err)
+		return nil, err
+	}
+	defer cancel()
+	defer release()
+
 	s := time.Now()
 	result, err := stmt.ExecContext(ctx, args)
 	d := time.Since(s)
@@ -128,6 +169,9 @@ func (o observer) StmtExecContext(ctx context.Context,
 		cmd+"-prepared",
 	).Observe(d.Seconds())
 
+	o.fail(span, table, cmd+"-prepared", err)
+	o.observeExec(ctx, query, args, d, table, cmd+"-prepared", result, err)
+
 	return result, err
 }
 
@@ -142,9 +186,17 @@ func (o observer) StmtQueryContext(ctx context.Context,
 	ext.DBInstance.Set(span, o.name)
 	ext.DBStatement.Set(span, query)
 
+	ctx, release, cancel, err := o.limit(ctx)
+	if err != nil {
+		table, cmd := parseSQL(query)
+		o.fail(span, table, cmd+"-prepared", err)
+		return ctx, nil, err
+	}
+
 	s := time.Now()
 	rows, err := stmt.QueryContext(ctx, args)
 	d := time.Since(s)
+	release()
 
 	log.Get(ctx).Debugf("[sqldb] name:%s, prepared query: %s, args: %v, cost: %v",
 		o.name, query, values(args), d)
@@ -156,6 +208,13 @@ func (o observer) StmtQueryContext(ctx context.Context,
 		cmd+"-prepared",
 	).Observe(d.Seconds())
 
+	o.fail(span, table, cmd+"-prepared", err)
+	if err != nil {
+		cancel()
+		return ctx, rows, err
+	}
+	ctx = o.observeQuery(ctx, query, args, d, table, cmd+"-prepared", cancel)
+
 	return ctx, rows, err
 }
 
@@ -180,6 +239,8 @@ func (o observer) ConnBeginTx(ctx context.Context, conn driver.ConnBeginTx,
 		"begin",
 	).Observe(d.Seconds())
 
+	o.fail(span, "", "begin", err)
+
 	return ctx, tx, err
 }
 
@@ -202,6 +263,8 @@ func (o observer) TxCommit(ctx context.Context, tx driver.Tx) error {
 		"commit",
 	).Observe(d.Seconds())
 
+	o.fail(span, "", "commit", err)
+
 	return err
 }
 
@@ -224,5 +287,117 @@ func (o observer) TxRollback(ctx context.Context, tx driver.Tx) error {
 		"rollback",
 	).Observe(d.Seconds())
 
+	o.fail(span, "", "rollback", err)
+
+	return err
+}
+
+func (o observer) RowsNext(ctx context.Context, rows driver.Rows, dest []driver.Value) error {
+	err := rows.Next(dest)
+	if q, ok := ctx.Value(queryKey{}).(*queryInfo); ok && err == nil {
+		q.rows++
+	}
 	return err
 }
+
+func (o observer) RowsClose(ctx context.Context, rows driver.Rows) error {
+	err := rows.Close()
+	if q, ok := ctx.Value(queryKey{}).(*queryInfo); ok {
+		q.cancel()
+		record(o.name, q.query, q.cost, q.rows)
+		sqlRowsReturned.WithLabelValues(
+			o.name,
+			q.table,
+			q.cmd,
+		).Observe(float64(q.rows))
+	}
+	return err
+}
+
+type queryKey struct{}
+
+// 查询结果需要等 rows 关闭之后才能统计行数
+type queryInfo struct {
+	query string
+	table string
+	cmd   string
+	cost  time.Duration
+	rows  int64
+	// 取消超时 ctx
+	cancel func()
+}
+
+func (o observer) observeExec(ctx context.Context, query string,
+	args []driver.NamedValue, d time.Duration,
+	table, cmd string, result driver.Result, err error) {
+	// 驱动不支持直接执行，database/sql 会改用 prepare 重试
+	if err == driver.ErrSkip {
+		return
+	}
+
+	o.slowLog(ctx, query, args, d)
+
+	var rows int64
+	if err == nil {
+		rows, _ = result.RowsAffected()
+		sqlRowsAffected.WithLabelValues(
+			o.name,
+			table,
+			cmd,
+		).Observe(float64(rows))
+	}
+	record(o.name, query, d, rows)
+}
+
+func (o observer) observeQuery(ctx context.Context, query string,
+	args []driver.NamedValue, d time.Duration, table, cmd string,
+	cancel func()) context.Context {
+	o.slowLog(ctx, query, args, d)
+
+	return context.WithValue(ctx, queryKey{}, &queryInfo{
+		query:  query,
+		table:  table,
+		cmd:    cmd,
+		cost:   d,
+		cancel: cancel,
+	})
+}
+
+// fail 标记 span 并按错误类型统计次数
+func (o observer) fail(span opentracing.Span, table, cmd string, err error) {
+	if err == nil || err == driver.ErrSkip {
+		return
+	}
+
+	ext.Error.Set(span, true)
+	ext.LogError(span, err)
+
+	sqlErrors.WithLabelValues(
+		o.name,
+		table,
+		cmd,
+		errClass(err),
+	).Inc()
+}
+
+// slowLog 记录慢查询
+//
+// 阈值优先读取 SQLDB_SLOW_name，其次读取 SQLDB_SLOW，为零则不记录
+func (o observer) slowLog(ctx context.Context, query string,
+	args []driver.NamedValue, d time.Duration) {
+	threshold := conf.GetDuration("SQLDB_SLOW_" + o.name)
+	if threshold == 0 {
+		threshold = conf.GetDuration("SQLDB_SLOW")
+	}
+	if threshold <= 0 || d < threshold {
+		return
+	}
+
+	log.Get(ctx).WithFields(log.Fields{
+		"db_name": o.name,
+		"sql":     normalize(query),
+		"args":    redact(args),
+		"caller":  caller(),
+		"cost":    d.Seconds(),
+	}).Warn("[sqldb] slow sql")
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/go-kiss/sniper/pkg/conf"
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/ngrok/sqlmw"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sync/singleflight"
@@ -21,7 +22,8 @@ var (
 	sfg singleflight.Group
 	rwl sync.RWMutex
 
-	dbs = map[string]*DB{}
+	dbs        = map[string]*DB{}
+	collectors = map[string]prometheus.Collector{}
 )
 
 type nameKey struct{}
@@ -50,31 +52,108 @@ func Get(ctx context.Context, name string) *DB {
 
 	v, _, _ := sfg.Do(name, func() (any, error) {
 		dsn := conf.Get("SQLDB_DSN_" + name)
-		isSqlite := strings.HasPrefix(dsn, "file:") || dsn == ":memory:"
 		var driverName string
-		var driver driver.Driver
-		if isSqlite {
+		var drv driver.Driver
+		o := observer{name: name, limiter: newLimiter(name)}
+		switch {
+		case strings.HasPrefix(dsn, "file:") || dsn == ":memory:":
 			driverName = "db-sqlite:" + name
-			driver = sqlmw.Driver(&sqlite.Driver{}, observer{name: name})
-		} else {
+			drv = sqlmw.Driver(&sqlite.Driver{}, o)
+		case strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://"):
+			driverName = "db-postgres:" + name
+			drv = sqlmw.Driver(&pq.Driver{}, o)
+			// sqlx 无法从自定义的驱动名识别占位符类型
+			sqlx.BindDriver(driverName, sqlx.DOLLAR)
+		default:
 			driverName = "db-mysql:" + name
-			driver = sqlmw.Driver(mysql.MySQLDriver{}, observer{name: name})
+			drv = sqlmw.Driver(mysql.MySQLDriver{}, o)
 		}
 
-		sql.Register(driverName, driver)
-		sdb := sqlx.MustOpen(driverName, dsn)
+		// 不使用 sql.Register 全局注册驱动，同名实例可以删除后重新创建
+		c, err := drv.(driver.DriverContext).OpenConnector(dsn)
+		if err != nil {
+			panic(err)
+		}
+		sdb := sqlx.NewDb(sql.OpenDB(c), driverName)
+		setPool(sdb.DB, name)
 
 		db := &DB{sdb}
-
-		rwl.Lock()
-		defer rwl.Unlock()
-		dbs[name] = db
-
-		collector := sqlstats.NewStatsCollector(name, db)
-		prometheus.MustRegister(collector)
+		attach(name, db)
 
 		return db, nil
 	})
 
 	return v.(*DB)
 }
+
+// attach 缓存实例并注册连接池监控
+func attach(name string, db *DB) {
+	rwl.Lock()
+	defer rwl.Unlock()
+	dbs[name] = db
+
+	collector := sqlstats.NewStatsCollector(name, db)
+	prometheus.MustRegister(collector)
+	collectors[name] = collector
+}
+
+// Detach 从实例缓存中移除实例但不关闭，返回被移除的实例，不存在则返回 nil
+//
+// 之后调用 Get 会重新读取配置创建实例，已经拿到原实例的代码不受影响。
+// 原实例可以通过 Attach 放回缓存，主要用于测试中临时替换实例。
+func Detach(name string) *DB {
+	rwl.Lock()
+	db := dbs[name]
+	collector := collectors[name]
+	delete(dbs, name)
+	delete(collectors, name)
+	rwl.Unlock()
+
+	if collector != nil {
+		prometheus.Unregister(collector)
+	}
+	return db
+}
+
+// Attach 将 Detach 返回的实例放回缓存，已有的同名实例会被关闭
+func Attach(name string, db *DB) error {
+	err := Remove(name)
+	attach(name, db)
+	return err
+}
+
+// Remove 关闭数据库实例，清理实例缓存、监控指标和 sql 统计
+//
+// 之后调用 Get 会重新读取配置创建实例，主要用于测试
+func Remove(name string) error {
+	db := Detach(name)
+	if db == nil {
+		return nil
+	}
+
+	labels := prometheus.Labels{"db_name": name}
+	sqlDurations.DeletePartialMatch(labels)
+	sqlErrors.DeletePartialMatch(labels)
+	sqlRowsAffected.DeletePartialMatch(labels)
+	sqlRowsReturned.DeletePartialMatch(labels)
+	sqlInflight.DeletePartialMatch(labels)
+	sqlWaiting.DeletePartialMatch(labels)
+	removeStatements(name)
+
+	return db.Close()
+}
+
+// 数据库方言，对应 driverName 中的驱动类型
+const (
+	dialectSQLite   = "sqlite"
+	dialectMySQL    = "mysql"
+	dialectPostgres = "postgres"
+)
+
+// dialect 根据 driverName 返回数据库方言
+//
+// "db-postgres:foo" => postgres
+func dialect(driverName string) string {
+	d, _, _ := strings.Cut(strings.TrimPrefix(driverName, "db-"), ":")
+	return d
+}
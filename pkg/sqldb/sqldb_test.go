@@ -2,10 +2,18 @@ package sqldb
 
 import (
 	"context"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 )
 
 var schema = `
@@ -17,6 +25,49 @@ CREATE TABLE IF NOT EXISTS users (
 )
 `
 
+// 各方言的 users 表结构
+var schemas = map[string]string{
+	dialectSQLite: schema,
+	dialectMySQL: `
+CREATE TABLE IF NOT EXISTS users (
+  id integer primary key auto_increment,
+  age integer,
+  name varchar(30),
+  created datetime(6) default CURRENT_TIMESTAMP(6)
+)
+`,
+	dialectPostgres: `
+CREATE TABLE IF NOT EXISTS users (
+  id serial primary key,
+  age integer,
+  name varchar(30),
+  created timestamptz default CURRENT_TIMESTAMP
+)
+`,
+}
+
+// testDB 返回名为 name 的测试数据库，并重建 users 表，测试结束后删除实例
+//
+// 默认使用临时的 sqlite 文件，设置 SQLDB_TEST_DSN 环境变量后，
+// 全部测试改为在对应的数据库上执行，比如
+//
+//	SQLDB_TEST_DSN=postgres://postgres@localhost/test?sslmode=disable
+//	SQLDB_TEST_DSN='root@tcp(localhost:3306)/test?parseTime=true&loc=Local'
+func testDB(t *testing.T, name string) *DB {
+	dsn := os.Getenv("SQLDB_TEST_DSN")
+	if dsn == "" {
+		dsn = "file:" + t.TempDir() + "/" + name + ".db"
+	}
+	conf.Set("SQLDB_DSN_"+name, dsn)
+	t.Cleanup(func() { Remove(name) })
+
+	ctx := context.Background()
+	db := Get(ctx, name)
+	db.MustExecContext(ctx, "DROP TABLE IF EXISTS users")
+	db.MustExecContext(ctx, schemas[dialect(db.DriverName())])
+	return db
+}
+
 type user struct {
 	ID      int
 	Name    string
@@ -28,20 +79,18 @@ func (u *user) TableName() string { return "users" }
 func (u *user) KeyName() string   { return "id" }
 
 func TestSqlDb(t *testing.T) {
-	conf.Set("SQLDB_DSN_foo", ":memory:")
 	ctx := context.Background()
+	db := testDB(t, "foo")
 
-	db := Get(ctx, "foo")
-	db.MustExecContext(ctx, schema)
-
-	result, err := db.ExecContext(ctx,
-		"insert into users(name,age) values (?,?)", "a", 1)
+	// postgres 不支持 LastInsertId，直接指定主键
+	id := 1
+	_, err := db.ExecContext(ctx,
+		db.Rebind("insert into users(id,name,age) values (?,?,?)"), id, "a", 1)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	id, _ := result.LastInsertId()
-	row := db.QueryRowxContext(ctx, "select * from users where id = ?", id)
+	row := db.QueryRowxContext(ctx, db.Rebind("select * from users where id = ?"), id)
 	var u1 user
 	if err := row.StructScan(&u1); err != nil {
 		t.Fatal(err)
@@ -55,7 +104,7 @@ func TestSqlDb(t *testing.T) {
 	tx.Exec("delete from users")
 	tx.Rollback()
 
-	stmt, err := db.PreparexContext(ctx, "select * from users where id = ?")
+	stmt, err := db.PreparexContext(ctx, db.Rebind("select * from users where id = ?"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,23 +121,22 @@ func TestSqlDb(t *testing.T) {
 }
 
 func TestModel(t *testing.T) {
-	conf.Set("SQLDB_DSN_foo", ":memory:")
-	ctx := context.Background()
-
-	db := Get(ctx, "foo")
-	db.MustExecContext(ctx, schema)
+	db := testDB(t, "model")
 
-	now := time.Now()
+	now := time.Now().Truncate(time.Microsecond)
 	u1 := &user{Name: "foo", Age: 18, Created: now}
-	result, err := db.Insert(u1)
+	_, err := db.Insert(u1)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	id, _ := result.LastInsertId()
+	var id int
+	if err := db.Get(&id, db.Rebind("select id from users where name = ?"), "foo"); err != nil {
+		t.Fatal(err)
+	}
 
 	u1.Name = "bar"
-	u1.ID = int(id)
+	u1.ID = id
 
 	_, err = db.Update(u1)
 	if err != nil {
@@ -96,7 +144,7 @@ func TestModel(t *testing.T) {
 	}
 
 	var u2 user
-	err = db.Get(&u2, "select * from users where id = ?", id)
+	err = db.Get(&u2, db.Rebind("select * from users where id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,7 +160,7 @@ func TestModel(t *testing.T) {
 	}
 
 	var u4 user
-	err = db.Get(&u4, "select * from users where id = ?", u3.ID)
+	err = db.Get(&u4, db.Rebind("select * from users where id = ?"), u3.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,3 +200,321 @@ func TestName(t *testing.T) {
 		t.Fatal("invalid id", id1, id2)
 	}
 }
+
+func TestStatements(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t, "stats")
+
+	ResetStatements()
+
+	for i := 0; i < 3; i++ {
+		db.MustExecContext(ctx, db.Rebind("insert into users(name,age) values (?,?)"), "a", i)
+	}
+
+	var us []user
+	if err := db.SelectContext(ctx, &us, "select * from users where age >= 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var insert, query *Statement
+	for _, s := range Statements(0, "count") {
+		s := s
+		if s.DB != "stats" {
+			continue
+		}
+		switch s.Fingerprint {
+		case "insert into users(name,age) values (?+)":
+			insert = &s
+		case "select * from users where age >= ?":
+			query = &s
+		}
+	}
+
+	if insert == nil || insert.Count != 3 || insert.Rows != 3 {
+		t.Fatal("invalid insert stats", insert)
+	}
+	if query == nil || query.Count != 1 || query.Rows != 3 {
+		t.Fatal("invalid query stats", query)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	defer conf.Set("SQLDB_STATS_TOKEN", "")
+
+	for _, c := range []struct {
+		token, auth string
+		code        int
+	}{
+		{"", "", 404},
+		{"", "Bearer ", 404},
+		{"secret", "", 401},
+		{"secret", "Bearer foo", 401},
+		{"secret", "secret", 401},
+		{"secret", "Bearer secret", 200},
+	} {
+		conf.Set("SQLDB_STATS_TOKEN", c.token)
+		r := httptest.NewRequest("GET", "/monitor/sqldb", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		StatsHandler(w, r)
+		if w.Code != c.code {
+			t.Fatal(c, w.Code)
+		}
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	conf.Set("SQLDB_SLOW_slow", "1ns")
+	ctx := context.Background()
+
+	hook := test.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+	db := testDB(t, "slow")
+	hook.Reset()
+
+	var us []user
+	query := db.Rebind("select * from users where name = ? and age in (?, ?)")
+	if err := db.SelectContext(ctx, &us, query, "secret", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	e := hook.LastEntry()
+	if e == nil || e.Level != logrus.WarnLevel || e.Message != "[sqldb] slow sql" {
+		t.Fatal("slow sql should be logged", e)
+	}
+	if e.Data["db_name"] != "slow" || e.Data["sql"] != "select * from users where name = ? and age in (?+)" {
+		t.Fatal("invalid slow log", e.Data)
+	}
+	args, _ := e.Data["args"].([]string)
+	if len(args) != 3 || args[0] != "string(6)" || args[1] != "int64" {
+		t.Fatal("args should be redacted", e.Data["args"])
+	}
+	if e.Data["caller"] == "" {
+		t.Fatal("caller should be logged")
+	}
+
+	// 未达到阈值不记录
+	conf.Set("SQLDB_SLOW_slow", "1h")
+	hook.Reset()
+	if err := db.SelectContext(ctx, &us, "select * from users"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range hook.AllEntries() {
+		if e.Level == logrus.WarnLevel {
+			t.Fatal("fast sql should not be logged", e.Data)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	db := testDB(t, "errors")
+
+	u := &user{ID: 1, Name: "a", Age: 1, Created: time.Now()}
+	if _, err := db.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Insert(u); errClass(err) != errDuplicate {
+		t.Fatal("duplicate key should fail", err)
+	}
+
+	c := sqlErrors.WithLabelValues("errors", "users", "insert", errDuplicate)
+	if n := testutil.ToFloat64(c); n != 1 {
+		t.Fatal("invalid error count", n)
+	}
+
+	h := sqlRowsAffected.WithLabelValues("errors", "users", "insert")
+	if n := testutil.CollectAndCount(h.(prometheus.Collector)); n != 1 {
+		t.Fatal("invalid rows affected", n)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	conf.Set("SQLDB_MAX_INFLIGHT_limit", 1)
+	conf.Set("SQLDB_MAX_QUEUE_limit", 1)
+	ctx := context.Background()
+
+	l := newLimiter("limit")
+	if err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(ctx) }()
+
+	// 等待第二个请求进入队列
+	for atomic.LoadInt64(&l.waiting) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := l.acquire(ctx); err != ErrTooManyQueries {
+		t.Fatal("queue should be full", err)
+	}
+
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatal("acquire should time out", err)
+	}
+	l.release()
+}
+
+func TestLimiterDefaultQueue(t *testing.T) {
+	conf.Set("SQLDB_MAX_INFLIGHT_queue", 2)
+	l := newLimiter("queue")
+	if l.maxQueue != 2 {
+		t.Fatal("queue should default to inflight", l.maxQueue)
+	}
+}
+
+func TestLimiterNestedQuery(t *testing.T) {
+	conf.Set("SQLDB_MAX_INFLIGHT_nested", 1)
+	conf.Set("SQLDB_MAX_QUEUE_nested", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	db := testDB(t, "nested")
+	db.MustExecContext(ctx, "insert into users(name,age) values ('a',1),('b',2)")
+
+	rows, err := db.QueryxContext(ctx, "select id from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	// 遍历 rows 期间不占用名额，嵌套查询不会死锁
+	for rows.Next() {
+		var id, age int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.GetContext(ctx, &age, db.Rebind("select age from users where id = ?"), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t, "timeout")
+	conf.Set("SQLDB_TIMEOUT_timeout", "10ms")
+	defer conf.Set("SQLDB_TIMEOUT_timeout", "")
+
+	var n int
+	err := db.GetContext(ctx, &n, `
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c)
+SELECT count(*) FROM c`)
+	if err == nil {
+		t.Fatal("query should time out")
+	}
+
+	if err := db.GetContext(ctx, &n, "select 1"); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db := testDB(t, "upsert")
+
+	u := &user{ID: 1, Name: "a", Age: 1, Created: time.Now()}
+	if _, err := db.Upsert(u); err != nil {
+		t.Fatal(err)
+	}
+	u.Name = "b"
+	if _, err := db.Upsert(u); err != nil {
+		t.Fatal(err)
+	}
+
+	var us []user
+	if err := db.Select(&us, "select * from users"); err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 1 || us[0].Name != "b" {
+		t.Fatal("invalid users", us)
+	}
+}
+
+// order 表名和字段名都是关键字
+type order struct {
+	ID    int
+	Group string
+}
+
+func (o *order) TableName() string { return "order" }
+func (o *order) KeyName() string   { return "id" }
+
+func TestQuotedModel(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t, "quoted")
+	db.MustExecContext(ctx, "DROP TABLE IF EXISTS "+quote(db, "order"))
+	db.MustExecContext(ctx, "CREATE TABLE "+quote(db, "order")+" (id integer primary key, "+quote(db, "group")+" varchar(30))")
+
+	o := &order{ID: 1, Group: "a"}
+	if _, err := db.Insert(o); err != nil {
+		t.Fatal(err)
+	}
+	o.Group = "b"
+	if _, err := db.Update(o); err != nil {
+		t.Fatal(err)
+	}
+	o.Group = "c"
+	if _, err := db.Upsert(o); err != nil {
+		t.Fatal(err)
+	}
+
+	var group string
+	if err := db.Get(&group, "SELECT "+db.Quote("group")+" FROM "+db.Quote("order")); err != nil || group != "c" {
+		t.Fatal(group, err)
+	}
+
+	// 没有主键的模型不能生成 update 语句
+	if _, err := db.Update(&noKey{Name: "a"}); err == nil {
+		t.Fatal("update without key should fail")
+	}
+}
+
+type noKey struct {
+	Name string
+}
+
+func (*noKey) TableName() string { return "logs" }
+func (*noKey) KeyName() string   { return "" }
+
+func TestDialect(t *testing.T) {
+	conf.Set("SQLDB_DSN_pg", "postgres://localhost/sniper")
+	ctx := context.Background()
+
+	db := Get(ctx, "pg")
+	defer Remove("pg")
+	if d := dialect(db.DriverName()); d != dialectPostgres {
+		t.Fatal("invalid dialect", d)
+	}
+	if q := db.Rebind("select * from users where id = ? and age = ?"); q != "select * from users where id = $1 and age = $2" {
+		t.Fatal("invalid rebind", q)
+	}
+
+	for _, c := range []struct {
+		driver, name, quoted string
+	}{
+		{"db-postgres:pg", "users", `"users"`},
+		{"db-postgres:pg", "public.users", `"public"."users"`},
+		{"db-sqlite:foo", "order", `"order"`},
+		{"db-mysql:foo", "shop.order", "`shop`.`order`"},
+		{"db-mysql:foo", "`order`", "`order`"},
+	} {
+		db := &DB{sqlx.NewDb(nil, c.driver)}
+		if q := quote(db, c.name); q != c.quoted {
+			t.Fatal("invalid quote", c.driver, c.name, q)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package sqldbtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load 加载测试数据，支持 sql 和 yaml 两种格式
+//
+// sql 文件会直接执行，可以包含多条语句。
+// yaml 文件以表名为键，每个表对应一组记录：
+//
+//	users:
+//	  - id: 1
+//	    name: foo
+//	  - id: 2
+//	    name: bar
+func Load(t testing.TB, db *DB, files ...string) {
+	t.Helper()
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch filepath.Ext(f) {
+		case ".sql":
+			if _, err := db.ExecContext(context.Background(), string(b)); err != nil {
+				t.Fatalf("load %s: %v", f, err)
+			}
+		case ".yaml", ".yml":
+			if err := loadYAML(db, b); err != nil {
+				t.Fatalf("load %s: %v", f, err)
+			}
+		default:
+			t.Fatalf("load %s: unknown fixture format", f)
+		}
+	}
+}
+
+func loadYAML(db *DB, b []byte) error {
+	var fixtures map[string][]map[string]any
+	if err := yaml.Unmarshal(b, &fixtures); err != nil {
+		return err
+	}
+
+	// 按表名排序，保证每次插入顺序相同
+	tables := make([]string, 0, len(fixtures))
+	for table := range fixtures {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	ctx := context.Background()
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		for _, row := range fixtures[table] {
+			cols := make([]string, 0, len(row))
+			for col := range row {
+				cols = append(cols, col)
+			}
+			sort.Strings(cols)
+
+			args := make([]any, 0, len(cols))
+			for _, col := range cols {
+				args = append(args, row[col])
+			}
+
+			if _, err := tx.ExecContext(ctx, insertSQL(table, cols), args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertSQL(table string, cols []string) string {
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+	return "INSERT INTO " + table + "(" + strings.Join(cols, ",") + ") VALUES (" + marks + ")"
+}
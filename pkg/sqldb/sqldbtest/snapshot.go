@@ -0,0 +1,180 @@
+package sqldbtest
+
+import (
+	"context"
+	"testing"
+)
+
+// Snap 数据库快照，保存了所有表的结构和数据
+type Snap struct {
+	db     *DB
+	schema []schemaObject
+	tables map[string]*tableData
+}
+
+// schemaObject 表、索引、视图或者触发器的定义
+type schemaObject struct {
+	Type string `db:"type"`
+	Name string `db:"name"`
+	SQL  string `db:"sql"`
+}
+
+type tableData struct {
+	cols []string
+	rows [][]any
+}
+
+// Snapshot 保存数据库当前所有表的结构和数据，一般配合子测试使用
+//
+//	s := sqldbtest.Snapshot(t, db)
+//	t.Run("foo", func(t *testing.T) {
+//		defer s.Restore(t)
+//		...
+//	})
+func Snapshot(t testing.TB, db *DB) *Snap {
+	t.Helper()
+
+	ctx := context.Background()
+	s := &Snap{db: db, schema: listSchema(t, db), tables: map[string]*tableData{}}
+
+	for _, table := range listTables(t, db) {
+		rows, err := db.QueryxContext(ctx, "SELECT * FROM "+quote(table))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := &tableData{}
+		if d.cols, err = rows.Columns(); err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			row, err := rows.SliceScan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.rows = append(d.rows, row)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+
+		s.tables[table] = d
+	}
+
+	return s
+}
+
+// Restore 恢复到快照时的结构和数据
+//
+// 所有表、索引、视图和触发器都会删除后按快照重建，
+// 快照之后新建的表会被删除，删除或者修改过的表会恢复原样。
+func (s *Snap) Restore(t testing.TB) {
+	t.Helper()
+
+	ctx := context.Background()
+	current := listSchema(t, s.db)
+	seq := hasSequence(t, s.db)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// 先删视图再删表，索引和触发器随表一起删除
+	for _, typ := range []string{"view", "table"} {
+		for _, o := range current {
+			if o.Type != typ {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "DROP "+typ+" IF EXISTS "+quote(o.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, o := range s.schema {
+		if _, err := tx.ExecContext(ctx, o.SQL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insert := func(table string) {
+		d := s.tables[table]
+		for _, row := range d.rows {
+			if _, err := tx.ExecContext(ctx, insertSQL(quote(table), d.cols), row...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for table := range s.tables {
+		if table != "sqlite_sequence" {
+			insert(table)
+		}
+	}
+
+	// sqlite_sequence 由 sqlite 维护，无法删除重建。插入数据时会更新自增状态，
+	// 所以最后清空并恢复快照时的内容
+	if seq {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM sqlite_sequence"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := s.tables["sqlite_sequence"]; ok {
+		insert("sqlite_sequence")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 查询所有用户定义的表、索引、视图和触发器，按创建顺序排列
+//
+// 自动创建的索引没有 sql，会随表一起重建
+func listSchema(t testing.TB, db *DB) []schemaObject {
+	t.Helper()
+
+	var objects []schemaObject
+	err := db.SelectContext(context.Background(), &objects, `
+SELECT type, name, sql FROM sqlite_master
+WHERE name NOT LIKE 'sqlite_%' AND sql IS NOT NULL
+ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+// 查询所有用户表，sqlite_sequence 保存了自增主键的状态，也需要恢复
+func listTables(t testing.TB, db *DB) []string {
+	t.Helper()
+
+	var tables []string
+	err := db.SelectContext(context.Background(), &tables, `
+SELECT name FROM sqlite_master
+WHERE type = 'table' AND (name NOT LIKE 'sqlite_%' OR name = 'sqlite_sequence')
+ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+// sqlite_sequence 在第一次创建自增主键表时才会创建
+func hasSequence(t testing.TB, db *DB) bool {
+	t.Helper()
+
+	var n int
+	err := db.GetContext(context.Background(), &n,
+		"SELECT count(*) FROM sqlite_master WHERE name = 'sqlite_sequence'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func quote(name string) string {
+	return `"` + name + `"`
+}
@@ -0,0 +1,89 @@
+// Package sqldbtest 为 sqldb 测试提供相互隔离的 sqlite 数据库
+//
+//	func TestFoo(t *testing.T) {
+//		db := sqldbtest.New(t, schema)
+//		sqldbtest.Load(t, db, "testdata/users.yaml")
+//		...
+//	}
+package sqldbtest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/sqldb"
+)
+
+var (
+	seq    int64
+	nameRE = regexp.MustCompile(`\W+`)
+)
+
+// New 创建独立的 sqlite 数据库并执行建表语句
+//
+// 实例名根据测试名生成，保证每个测试都不相同，可以通过 db.Name 获取。
+// 测试结束后会自动关闭数据库，并清理 sqldb.Get 缓存和监控指标。
+func New(t testing.TB, schemas ...string) *DB {
+	t.Helper()
+
+	name := fmt.Sprintf("test_%s_%d",
+		nameRE.ReplaceAllString(t.Name(), "_"), atomic.AddInt64(&seq, 1))
+	return open(t, name, schemas)
+}
+
+// Replace 用独立的 sqlite 数据库替换配置名为 name 的实例
+//
+// 被测代码通过 sqldb.Get(ctx, name) 拿到的是测试数据库。
+// 原实例不会被关闭，已经持有原实例的代码可以继续使用，
+// 测试结束后恢复原有配置和实例。使用同名实例的测试不能并行执行。
+func Replace(t testing.TB, name string, schemas ...string) *DB {
+	t.Helper()
+
+	return open(t, name, schemas)
+}
+
+// DB 测试数据库实例
+type DB struct {
+	*sqldb.DB
+	// Name 实例名，即 sqldb.Get 的参数
+	Name string
+}
+
+func open(t testing.TB, name string, schemas []string) *DB {
+	t.Helper()
+
+	key := "SQLDB_DSN_" + name
+	old := conf.Get(key)
+
+	// 使用文件而非 :memory:，连接池里的多个连接才能看到相同的数据
+	path := filepath.Join(t.TempDir(), "test.db")
+	conf.Set(key, "file:"+path+"?_pragma=busy_timeout(5000)")
+	orig := sqldb.Detach(name)
+
+	t.Cleanup(func() {
+		if err := sqldb.Remove(name); err != nil {
+			t.Error(err)
+		}
+		conf.Set(key, old)
+		if orig != nil {
+			if err := sqldb.Attach(name, orig); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	ctx := context.Background()
+	db := &DB{DB: sqldb.Get(ctx, name), Name: name}
+	for _, s := range schemas {
+		if _, err := db.ExecContext(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
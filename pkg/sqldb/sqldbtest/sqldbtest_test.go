@@ -0,0 +1,119 @@
+package sqldbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+	"github.com/go-kiss/sniper/pkg/sqldb"
+)
+
+func count(t *testing.T, db *DB, table string) int {
+	var n int
+	if err := db.Get(&n, "select count(*) from "+table); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNew(t *testing.T) {
+	db1 := New(t)
+	db2 := New(t)
+	if db1.Name == db2.Name {
+		t.Fatal("duplicate name", db1.Name)
+	}
+
+	Load(t, db1, "testdata/schema.sql", "testdata/users.yaml")
+	if n := count(t, db1, "users"); n != 2 {
+		t.Fatal("invalid users", n)
+	}
+	if n := count(t, db1, "posts"); n != 1 {
+		t.Fatal("invalid posts", n)
+	}
+
+	if db := sqldb.Get(context.Background(), db1.Name); db != db1.DB {
+		t.Fatal("sqldb.Get should return test db")
+	}
+
+	Load(t, db2, "testdata/schema.sql")
+	if n := count(t, db2, "users"); n != 0 {
+		t.Fatal("databases should be isolated", n)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	dsn := "file:" + t.TempDir() + "/foo.db"
+	conf.Set("SQLDB_DSN_replace", dsn)
+	defer sqldb.Remove("replace")
+
+	orig := sqldb.Get(context.Background(), "replace")
+	orig.MustExec("create table bar(id integer)")
+
+	t.Run("replace", func(t *testing.T) {
+		db := Replace(t, "replace", "create table foo(id integer)")
+		db.MustExec("insert into foo values (1)")
+
+		var n int
+		if err := sqldb.Get(context.Background(), "replace").Get(&n, "select count(*) from foo"); err != nil || n != 1 {
+			t.Fatal(n, err)
+		}
+
+		// 原实例没有关闭，持有原实例的代码可以继续使用
+		if _, err := orig.Exec("insert into bar values (1)"); err != nil {
+			t.Fatal("original db should not be closed", err)
+		}
+	})
+
+	if got := conf.Get("SQLDB_DSN_replace"); got != dsn {
+		t.Fatal("config should be restored", got)
+	}
+	if db := sqldb.Get(context.Background(), "replace"); db != orig {
+		t.Fatal("original db should be restored")
+	}
+	if err := orig.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	db := New(t)
+	Load(t, db, "testdata/schema.sql", "testdata/users.yaml")
+
+	s := Snapshot(t, db)
+
+	t.Run("modify", func(t *testing.T) {
+		defer s.Restore(t)
+
+		db.MustExec("delete from users")
+		db.MustExec("insert into posts(user_id, title) values (2, 'world')")
+		db.MustExec("create table tmp(id integer)")
+		db.MustExec("create index users_name on users(name)")
+		db.MustExec("alter table users add column email varchar(30)")
+		db.MustExec("drop table posts")
+	})
+
+	if n := count(t, db, "users"); n != 2 {
+		t.Fatal("users should be restored", n)
+	}
+	if n := count(t, db, "posts"); n != 1 {
+		t.Fatal("posts should be restored", n)
+	}
+
+	// 自增主键也应该恢复
+	r := db.MustExec("insert into posts(user_id, title) values (2, 'world')")
+	if id, _ := r.LastInsertId(); id != 2 {
+		t.Fatal("invalid post id", id)
+	}
+
+	var tables []string
+	db.Select(&tables, "select name from sqlite_master where name in ('tmp', 'users_name')")
+	if len(tables) != 0 {
+		t.Fatal("new table and index should be dropped", tables)
+	}
+
+	var cols []string
+	db.Select(&cols, "select name from pragma_table_info('users')")
+	if len(cols) != 3 {
+		t.Fatal("altered table should be restored", cols)
+	}
+}
@@ -0,0 +1,10 @@
+CREATE TABLE users (
+  id integer primary key autoincrement,
+  name varchar(30),
+  age integer
+);
+CREATE TABLE posts (
+  id integer primary key autoincrement,
+  user_id integer,
+  title varchar(100)
+);
@@ -0,0 +1,195 @@
+package sqldb
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/conf"
+)
+
+const (
+	// 最多统计的 sql 指纹数量，超出后淘汰执行次数最少的
+	maxStatements = 1000
+	// 每个指纹保留最近的耗时样本数量，用于计算分位数
+	maxSamples = 256
+)
+
+// Statement 某一类 sql 的执行统计，类似 pg_stat_statements
+type Statement struct {
+	DB          string        `json:"db_name"`
+	Fingerprint string        `json:"fingerprint"`
+	Count       int64         `json:"count"`
+	Total       time.Duration `json:"total"`
+	Max         time.Duration `json:"max"`
+	P50         time.Duration `json:"p50"`
+	P99         time.Duration `json:"p99"`
+	Rows        int64         `json:"rows"`
+
+	samples []time.Duration
+	next    int
+}
+
+type statKey struct {
+	db, fingerprint string
+}
+
+var (
+	stmtLock sync.Mutex
+	stmts    = map[statKey]*Statement{}
+)
+
+// 记录一次 sql 执行，rows 为影响或者返回的行数
+func record(db, query string, d time.Duration, rows int64) {
+	k := statKey{db: db, fingerprint: normalize(query)}
+
+	stmtLock.Lock()
+	defer stmtLock.Unlock()
+
+	s, ok := stmts[k]
+	if !ok {
+		if len(stmts) >= maxStatements {
+			evict()
+		}
+		s = &Statement{DB: k.db, Fingerprint: k.fingerprint}
+		stmts[k] = s
+	}
+
+	s.Count++
+	s.Total += d
+	s.Rows += rows
+	if d > s.Max {
+		s.Max = d
+	}
+
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, d)
+	} else {
+		s.samples[s.next] = d
+		s.next = (s.next + 1) % maxSamples
+	}
+}
+
+// 淘汰执行次数最少的指纹，调用方需要持有锁
+func evict() {
+	var min statKey
+	var n int64 = -1
+	for k, s := range stmts {
+		if n < 0 || s.Count < n {
+			min, n = k, s.Count
+		}
+	}
+	delete(stmts, min)
+}
+
+// Statements 返回总耗时最高的 n 类 sql
+//
+// by 支持 total/count/p99/rows，默认按 total 排序
+func Statements(n int, by string) []Statement {
+	stmtLock.Lock()
+	result := make([]Statement, 0, len(stmts))
+	for _, s := range stmts {
+		c := *s
+		c.samples = append([]time.Duration(nil), s.samples...)
+		result = append(result, c)
+	}
+	stmtLock.Unlock()
+
+	for i := range result {
+		s := &result[i]
+		sort.Slice(s.samples, func(i, j int) bool { return s.samples[i] < s.samples[j] })
+		s.P50 = percentile(s.samples, 0.5)
+		s.P99 = percentile(s.samples, 0.99)
+		s.samples = nil
+	}
+
+	less := func(a, b *Statement) bool { return a.Total > b.Total }
+	switch by {
+	case "count":
+		less = func(a, b *Statement) bool { return a.Count > b.Count }
+	case "p99":
+		less = func(a, b *Statement) bool { return a.P99 > b.P99 }
+	case "rows":
+		less = func(a, b *Statement) bool { return a.Rows > b.Rows }
+	}
+	sort.Slice(result, func(i, j int) bool { return less(&result[i], &result[j]) })
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// ResetStatements 清空 sql 统计
+func ResetStatements() {
+	stmtLock.Lock()
+	defer stmtLock.Unlock()
+
+	stmts = map[statKey]*Statement{}
+}
+
+// 删除某个数据库的 sql 统计
+func removeStatements(db string) {
+	stmtLock.Lock()
+	defer stmtLock.Unlock()
+
+	for k := range stmts {
+		if k.db == db {
+			delete(stmts, k)
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+// StatsHandler 以 json 格式输出 sql 统计
+//
+// 支持参数 n (默认 20) 和 sort (total/count/p99/rows)
+// 耗时单位为纳秒，POST 请求会清空统计
+//
+// 统计结果包含 sql 和业务代码信息，需要配置 SQLDB_STATS_TOKEN 才能访问，
+// 请求头为 Authorization: Bearer <token>。未配置时返回 404。
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	token := conf.Get("SQLDB_STATS_TOKEN")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		ResetStatements()
+		w.Write([]byte("ok"))
+		return
+	}
+
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil || n <= 0 {
+		n = 20
+	}
+
+	buf, err := json.Marshal(Statements(n, r.FormValue("sort")))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.Write(buf)
+}
@@ -1,9 +1,19 @@
 package sqldb
 
 import (
+	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
 	"regexp"
+	"runtime"
 	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 func values(args []driver.NamedValue) []driver.Value {
@@ -14,15 +24,21 @@ func values(args []driver.NamedValue) []driver.Value {
 	return values
 }
 
+// 表名，支持引号和 schema 前缀，比如 "public"."foo"、`db`.`foo`
+const tableRE = "(?P<table>(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?\\w+[`\"]?)"
+
 var sqlreg = regexp.MustCompile(`(?i)` +
-	`(?P<cmd>select)\s+.+?from\s+(?P<table>\w+)\s*|` +
-	`(?P<cmd>update)\s+(?P<table>\w+)\s+|` +
-	`(?P<cmd>delete)\s+from\s+(?P<table>\w+)\s*|` +
-	`(?P<cmd>insert)\s+into\s+(?P<table>\w+)`)
+	`(?P<cmd>select)\s+.+?from\s+` + tableRE + `\s*|` +
+	`(?P<cmd>update)\s+` + tableRE + `\s+|` +
+	`(?P<cmd>delete)\s+from\s+` + tableRE + `\s*|` +
+	`(?P<cmd>insert)\s+into\s+` + tableRE)
+
+var quoteReplacer = strings.NewReplacer("`", "", `"`, "")
 
-// 提取 sql 的表名和指令
+// 提取 sql 的表名和指令，表名会去掉引号
 //
 // "select * from foo ..." => foo,select
+// `select * from "public"."foo" ...` => public.foo,select
 func parseSQL(sql string) (table, cmd string) {
 	matches := sqlreg.FindStringSubmatch(sql)
 
@@ -34,7 +50,166 @@ func parseSQL(sql string) (table, cmd string) {
 		}
 	}
 
-	table = strings.ToLower(results["table"])
+	table = strings.ToLower(quoteReplacer.Replace(results["table"]))
 	cmd = strings.ToLower(results["cmd"])
 	return
 }
+
+var (
+	strLitRE = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	numLitRE = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	dollarRE = regexp.MustCompile(`\$\d+`)
+	listRE   = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+	spaceRE  = regexp.MustCompile(`\s+`)
+)
+
+// 归一化 sql，去掉字面量和多余空白，相同结构的 sql 得到相同结果
+//
+// "select * from foo where id in (1, 2, 3) and name = 'a'"
+// => "select * from foo where id in (?+) and name = ?"
+func normalize(sql string) string {
+	sql = strLitRE.ReplaceAllString(sql, "?")
+	sql = dollarRE.ReplaceAllString(sql, "?")
+	sql = numLitRE.ReplaceAllString(sql, "?")
+	sql = listRE.ReplaceAllString(sql, "(?+)")
+	sql = spaceRE.ReplaceAllString(sql, " ")
+	return strings.TrimSpace(sql)
+}
+
+// 隐藏参数内容，只保留类型和长度，避免敏感数据写入日志
+//
+// [1, "abc", nil] => [int64 string(3) nil]
+func redact(args []driver.NamedValue) []string {
+	vs := make([]string, 0, len(args))
+	for _, a := range args {
+		switch v := a.Value.(type) {
+		case nil:
+			vs = append(vs, "nil")
+		case string:
+			vs = append(vs, fmt.Sprintf("string(%d)", len(v)))
+		case []byte:
+			vs = append(vs, fmt.Sprintf("bytes(%d)", len(v)))
+		default:
+			vs = append(vs, fmt.Sprintf("%T", v))
+		}
+	}
+	return vs
+}
+
+// 这些包的调用栈都属于框架内部，查找调用方时需要跳过
+var skipPkgs = []string{
+	"runtime.",
+	"database/sql.",
+	"github.com/jmoiron/sqlx.",
+	"github.com/ngrok/sqlmw.",
+	"github.com/go-kiss/sniper/pkg/sqldb.",
+}
+
+// 查找业务代码中发起 sql 调用的位置
+func caller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		skip := false
+		for _, p := range skipPkgs {
+			if strings.HasPrefix(f.Function, p) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			return fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// 错误分类，用于监控指标
+const (
+	errDeadlock   = "deadlock"
+	errDuplicate  = "duplicate"
+	errTimeout    = "timeout"
+	errConnection = "connection"
+	errOverload   = "overload"
+	errOther      = "other"
+)
+
+// 根据驱动返回的错误判断错误类型
+func errClass(err error) string {
+	if errors.Is(err, ErrTooManyQueries) {
+		return errOverload
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errTimeout
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return errConnection
+	}
+
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		switch me.Number {
+		case 1213: // ER_LOCK_DEADLOCK
+			return errDeadlock
+		case 1062, 1586: // ER_DUP_ENTRY, ER_DUP_ENTRY_WITH_KEY_NAME
+			return errDuplicate
+		case 1205, 3024: // ER_LOCK_WAIT_TIMEOUT, ER_QUERY_TIMEOUT
+			return errTimeout
+		}
+		return errOther
+	}
+
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		switch se.Code() {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return errDeadlock
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return errDuplicate
+		case sqlite3.SQLITE_INTERRUPT: // ctx 超时或者取消
+			return errTimeout
+		}
+		return errOther
+	}
+
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		switch pe.Code {
+		case "40P01": // deadlock_detected
+			return errDeadlock
+		case "23505": // unique_violation
+			return errDuplicate
+		case "57014", "55P03": // query_canceled, lock_not_available
+			return errTimeout
+		}
+		if pe.Code.Class() == "08" { // connection_exception
+			return errConnection
+		}
+		return errOther
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return errTimeout
+		}
+		return errConnection
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "deadlock"):
+		return errDeadlock
+	case strings.Contains(msg, "duplicate"), strings.Contains(msg, "unique constraint"):
+		return errDuplicate
+	case strings.Contains(msg, "timeout"):
+		return errTimeout
+	case strings.Contains(msg, "connection"):
+		return errConnection
+	}
+	return errOther
+}
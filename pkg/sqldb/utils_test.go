@@ -1,6 +1,15 @@
 package sqldb
 
-import "testing"
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
 
 func TestParseSQL(t *testing.T) {
 	cases := [][]string{
@@ -10,6 +19,9 @@ func TestParseSQL(t *testing.T) {
 		{"DELETE from foo where", "foo", "delete"},
 		{"select * from foo", "foo", "select"},
 		{"DELETE from foo", "foo", "delete"},
+		{`select * from "public"."foo" where`, "public.foo", "select"},
+		{"insert into `db`.`foo` (a) values", "db.foo", "insert"},
+		{`UPDATE "Foo" set`, "foo", "update"},
 	}
 
 	for _, c := range cases {
@@ -19,3 +31,54 @@ func TestParseSQL(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalize(t *testing.T) {
+	cases := [][]string{
+		{"select * from foo where id = 1", "select * from foo where id = ?"},
+		{"select *\n  from foo where name = 'a''b' and age > 1.5", "select * from foo where name = ? and age > ?"},
+		{"select * from foo where id in (1, 2, 3)", "select * from foo where id in (?+)"},
+		{"select * from foo where id in (?,?)", "select * from foo where id in (?+)"},
+		{"update foo set a = $1 where id = $2", "update foo set a = ? where id = ?"},
+		{"select * from t1", "select * from t1"},
+	}
+
+	for _, c := range cases {
+		if s := normalize(c[0]); s != c[1] {
+			t.Fatal("invalid fingerprint", s)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	args := []driver.NamedValue{{Value: int64(1)}, {Value: "abc"}, {Value: nil}, {Value: []byte("ab")}}
+	s := fmt.Sprint(redact(args))
+	if s != "[int64 string(3) nil bytes(2)]" {
+		t.Fatal("invalid args", s)
+	}
+}
+
+func TestErrClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{context.DeadlineExceeded, errTimeout},
+		{fmt.Errorf("wrap: %w", driver.ErrBadConn), errConnection},
+		{&mysql.MySQLError{Number: 1213}, errDeadlock},
+		{&mysql.MySQLError{Number: 1062}, errDuplicate},
+		{&mysql.MySQLError{Number: 1064}, errOther},
+		{&pq.Error{Code: "40P01"}, errDeadlock},
+		{&pq.Error{Code: "23505"}, errDuplicate},
+		{&pq.Error{Code: "57014"}, errTimeout},
+		{&pq.Error{Code: "08006"}, errConnection},
+		{ErrTooManyQueries, errOverload},
+		{errors.New("UNIQUE constraint failed: users.id"), errDuplicate},
+		{errors.New("boom"), errOther},
+	}
+
+	for _, c := range cases {
+		if class := errClass(c.err); class != c.class {
+			t.Fatal("invalid class", c.err, class)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package twirp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported for request and response bodies.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// DefaultCompressMinSize is the smallest response body that is compressed
+// unless WithCompressMinSize says otherwise. Compressing smaller bodies costs
+// more CPU than it saves bandwidth.
+const DefaultCompressMinSize = 1024
+
+// WithMaxBodySize limits the size of request bodies read by generated
+// servers. The limit applies to the body before and after decompression, and
+// also bounds the zstd decoder window. A size of 0 or less means no limit.
+func WithMaxBodySize(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, MaxBodySizeKey, n)
+}
+
+// MaxBodySize returns the request body limit set by WithMaxBodySize.
+func MaxBodySize(ctx context.Context) (int64, bool) {
+	n, ok := ctx.Value(MaxBodySizeKey).(int64)
+	return n, ok && n > 0
+}
+
+// WithCompressMinSize sets the smallest response body that generated servers
+// compress. A negative size disables response compression.
+func WithCompressMinSize(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, CompressMinSizeKey, n)
+}
+
+// decodedBody marks a request body that has already been decoded.
+type decodedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (decodedBody) Close() error { return nil }
+
+// ReadRequestBody reads the request body, decompressing it according to the
+// Content-Encoding header and enforcing the limit set by WithMaxBodySize.
+//
+// Bodies over the limit fail with a resource_exhausted error, which is sent
+// with HTTP status 413. Unsupported encodings fail with invalid_argument.
+func ReadRequestBody(ctx context.Context, req *http.Request) ([]byte, error) {
+	if err := DecodeRequestBody(ctx, req); err != nil {
+		return nil, err
+	}
+	return req.Body.(decodedBody).data, nil
+}
+
+// DecodeRequestBody is like ReadRequestBody, but puts the decoded body back
+// into the request, so that it can be read again. The Content-Encoding header
+// is removed. Calling it more than once is cheap.
+func DecodeRequestBody(ctx context.Context, req *http.Request) error {
+	if _, ok := req.Body.(decodedBody); ok {
+		return nil
+	}
+
+	limit, _ := MaxBodySize(ctx)
+
+	var r io.Reader = req.Body
+	if r == nil {
+		r = http.NoBody
+	}
+	if limit > 0 {
+		r = &limitedReader{r: r, n: limit}
+	}
+
+	encoding := strings.TrimSpace(strings.ToLower(req.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return bodyError(err, "invalid gzip request body")
+		}
+		defer zr.Close()
+		r = zr
+	case EncodingZstd:
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if limit > 0 {
+			// A tiny body can declare a huge window, and the decoder allocates
+			// the window before anything is read. Bound it by the body limit.
+			window := uint64(min(max(limit, zstd.MinWindowSize), zstd.MaxWindowSize))
+			opts = append(opts, zstd.WithDecoderMaxMemory(window), zstd.WithDecoderMaxWindow(window))
+		}
+		zr, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return bodyError(err, "invalid zstd request body")
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return NewError(InvalidArgument, "unsupported Content-Encoding "+strconv.Quote(encoding))
+	}
+
+	if limit > 0 && encoding != "" && encoding != "identity" {
+		r = &limitedReader{r: r, n: limit}
+	}
+
+	data, err := io.ReadAll(r)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		err = &http.MaxBytesError{Limit: limit}
+	}
+	if err != nil {
+		return bodyError(err, "failed to read request body")
+	}
+
+	req.Body = decodedBody{Reader: bytes.NewReader(data), data: data}
+	req.ContentLength = int64(len(data))
+	req.Header.Del("Content-Encoding")
+	return nil
+}
+
+func bodyError(err error, msg string) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		twerr := NewError(ResourceExhausted, "request body too large, max "+strconv.FormatInt(mbe.Limit, 10)+" bytes")
+		return WrapError(twerr, err)
+	}
+
+	var twerr Error
+	if errors.As(err, &twerr) {
+		return twerr
+	}
+
+	if errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, zstd.ErrMagicMismatch) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return WrapError(NewError(InvalidArgument, msg+": "+err.Error()), err)
+	}
+
+	return InternalErrorWith(wrapErr(err, msg))
+}
+
+// limitedReader fails with *http.MaxBytesError once more than n bytes are
+// read, unlike io.LimitReader which silently stops.
+type limitedReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read > l.n {
+		return 0, &http.MaxBytesError{Limit: l.n}
+	}
+	if int64(len(p)) > l.n-l.read+1 {
+		p = p[:l.n-l.read+1]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.n {
+		return 0, &http.MaxBytesError{Limit: l.n}
+	}
+	return n, err
+}
+
+// CompressResponse compresses a response body if the client accepts it, the
+// content type is compressible and the body is large enough. It sets the
+// Content-Encoding and Vary headers, so it must be called before WriteHeader.
+// Generated servers call it for every successful unary response.
+func CompressResponse(ctx context.Context, resp http.ResponseWriter, req *http.Request, body []byte) []byte {
+	min := DefaultCompressMinSize
+	if n, ok := ctx.Value(CompressMinSizeKey).(int); ok {
+		min = n
+	}
+	if min < 0 || len(body) < min {
+		return body
+	}
+
+	h := resp.Header()
+	if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return body
+	}
+
+	encoding := acceptedEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return body
+	}
+
+	var out []byte
+	switch encoding {
+	case EncodingZstd:
+		out = zstdEncoder().EncodeAll(body, nil)
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(&buf)
+		zw.Write(body)
+		zw.Close()
+		gzipWriters.Put(zw)
+		out = buf.Bytes()
+	}
+
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("Content-Encoding", encoding)
+	h.Del("Content-Length")
+	return out
+}
+
+// CompressRequestBody compresses body with the given encoding.
+func CompressRequestBody(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingZstd:
+		return zstdEncoder().EncodeAll(body, nil), nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, errors.New("twirp: unsupported encoding " + strconv.Quote(encoding))
+	}
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+)
+
+// zstdEncoder returns a shared encoder, EncodeAll is safe for concurrent use.
+func zstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	})
+	return zstdEnc
+}
+
+func compressible(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		mt == "application/json",
+		mt == "application/protobuf",
+		mt == "application/javascript",
+		mt == "application/xml",
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	return false
+}
+
+// acceptedEncoding picks zstd over gzip, ignoring encodings with q=0.
+func acceptedEncoding(header string) string {
+	var gz, zs bool
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case EncodingGzip:
+			gz = true
+		case EncodingZstd:
+			zs = true
+		}
+	}
+
+	switch {
+	case zs:
+		return EncodingZstd
+	case gz:
+		return EncodingGzip
+	}
+	return ""
+}
@@ -0,0 +1,207 @@
+package twirp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newBodyRequest(body []byte, encoding string) *http.Request {
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	return req
+}
+
+func TestReadRequestBody(t *testing.T) {
+	body := []byte(strings.Repeat(`{"value":"hello"}`, 100))
+	ctx := context.Background()
+
+	for _, encoding := range []string{"", "identity", EncodingGzip, EncodingZstd} {
+		data := body
+		if encoding == EncodingGzip || encoding == EncodingZstd {
+			var err error
+			if data, err = CompressRequestBody(encoding, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		req := newBodyRequest(data, encoding)
+		got, err := ReadRequestBody(ctx, req)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Fatal(encoding, err)
+		}
+		if req.Header.Get("Content-Encoding") != "" || req.ContentLength != int64(len(body)) {
+			t.Fatal(encoding, req.Header, req.ContentLength)
+		}
+
+		// The decoded body can be read again.
+		got, err = ReadRequestBody(ctx, req)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Fatal(encoding, err)
+		}
+		if got, _ = io.ReadAll(req.Body); !bytes.Equal(got, body) {
+			t.Fatal(encoding)
+		}
+	}
+
+	_, err := ReadRequestBody(ctx, newBodyRequest(body, "br"))
+	if twerr, ok := err.(Error); !ok || twerr.Code() != InvalidArgument {
+		t.Fatal(err)
+	}
+
+	_, err = ReadRequestBody(ctx, newBodyRequest(body, EncodingGzip))
+	if twerr, ok := err.(Error); !ok || twerr.Code() != InvalidArgument {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	ctx := WithMaxBodySize(context.Background(), 1024)
+
+	if _, err := ReadRequestBody(ctx, newBodyRequest(make([]byte, 1024), "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Too large before decoding, and too large after decoding. A kilobyte of
+	// zeros compresses to a few bytes.
+	large := make([]byte, 1025)
+	zipped, _ := CompressRequestBody(EncodingGzip, large)
+	zstded, _ := CompressRequestBody(EncodingZstd, large)
+	for _, req := range []*http.Request{
+		newBodyRequest(large, ""),
+		newBodyRequest(zipped, EncodingGzip),
+		newBodyRequest(zstded, EncodingZstd),
+	} {
+		_, err := ReadRequestBody(ctx, req)
+		if twerr, ok := err.(Error); !ok || twerr.Code() != ResourceExhausted {
+			t.Fatal(req.Header, err)
+		}
+
+		w := httptest.NewRecorder()
+		var hooks *ServerHooks
+		hooks.WriteError(ctx, w, err)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatal(w.Code)
+		}
+	}
+}
+
+func TestZstdWindow(t *testing.T) {
+	ctx := WithMaxBodySize(context.Background(), 1024)
+
+	// A frame without a content size declares its window up front, so a small
+	// body can ask the decoder for megabytes of memory. The header below has no
+	// flags and a window of 1 MiB, followed by a last raw block of 100 bytes.
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x50, 0x21, 0x03, 0x00}
+	frame = append(frame, make([]byte, 100)...)
+
+	_, err := ReadRequestBody(ctx, newBodyRequest(frame, EncodingZstd))
+	if twerr, ok := err.(Error); !ok || twerr.Code() != ResourceExhausted {
+		t.Fatal(err)
+	}
+
+	// Without a limit the window is not bounded.
+	body, err := ReadRequestBody(context.Background(), newBodyRequest(frame, EncodingZstd))
+	if err != nil || len(body) != 100 {
+		t.Fatal(len(body), err)
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	body := []byte(strings.Repeat(`{"value":"hello"}`, 100))
+	ctx := context.Background()
+
+	compress := func(ctx context.Context, contentType, accept string, body []byte) (*httptest.ResponseRecorder, []byte) {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", contentType)
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		return w, CompressResponse(ctx, w, req, body)
+	}
+
+	w, out := compress(ctx, "application/json", "gzip, deflate", body)
+	if w.Header().Get("Content-Encoding") != EncodingGzip || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(w.Header())
+	}
+	req := newBodyRequest(out, EncodingGzip)
+	if got, err := ReadRequestBody(ctx, req); err != nil || !bytes.Equal(got, body) {
+		t.Fatal(err)
+	}
+
+	w, out = compress(ctx, "application/protobuf", "gzip;q=0.5, zstd", body)
+	if w.Header().Get("Content-Encoding") != EncodingZstd {
+		t.Fatal(w.Header())
+	}
+	dec, _ := zstd.NewReader(nil)
+	defer dec.Close()
+	if got, err := dec.DecodeAll(out, nil); err != nil || !bytes.Equal(got, body) {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ctx         context.Context
+		contentType string
+		accept      string
+		body        []byte
+	}{
+		{ctx, "application/json", "", body},
+		{ctx, "application/json", "gzip;q=0, zstd;q=0", body},
+		{ctx, "image/png", "gzip", body},
+		{ctx, "application/json", "gzip", body[:100]},
+		{WithCompressMinSize(ctx, -1), "application/json", "gzip", body},
+	} {
+		w, out := compress(c.ctx, c.contentType, c.accept, c.body)
+		if w.Header().Get("Content-Encoding") != "" || !bytes.Equal(out, c.body) {
+			t.Fatal(c.contentType, c.accept, len(c.body))
+		}
+	}
+
+	if _, out = compress(WithCompressMinSize(ctx, 10), "application/json", "gzip", body[:100]); bytes.Equal(out, body[:100]) {
+		t.Fatal("should compress")
+	}
+}
+
+func TestClientRequestCompression(t *testing.T) {
+	var encoding string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		body, err := ReadRequestBody(r.Context(), r)
+		if err != nil {
+			var hooks *ServerHooks
+			hooks.WriteError(r.Context(), w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/protobuf")
+		w.Write(CompressResponse(r.Context(), w, r, body))
+	}))
+	defer s.Close()
+
+	for _, c := range []struct {
+		in       string
+		encoding string
+	}{
+		{strings.Repeat("ping", 1000), EncodingZstd},
+		{"ping", ""},
+	} {
+		opts := NewClientOptions(WithRequestCompression(EncodingZstd, 1024))
+		out := &wrapperspb.StringValue{}
+		err := DoProtobufRequestWithHooks(context.Background(), http.DefaultClient, opts.Hooks, s.URL, wrapperspb.String(c.in), out)
+		if err != nil || !proto.Equal(out, wrapperspb.String(c.in)) {
+			t.Fatal(err)
+		}
+		if encoding != c.encoding {
+			t.Fatal(encoding)
+		}
+	}
+}
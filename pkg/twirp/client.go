@@ -11,6 +11,7 @@ import (
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // HTTPClient is the interface used by generated clients to send HTTP requests.
@@ -26,75 +27,112 @@ type HTTPClient interface {
 }
 
 // DoProtobufRequest is common code to make a request to the remote twirp service.
-func DoProtobufRequest(ctx context.Context, client HTTPClient, url string, in, out proto.Message) (err error) {
+func DoProtobufRequest(ctx context.Context, client HTTPClient, url string, in, out proto.Message) error {
+	return DoProtobufRequestWithHooks(ctx, client, nil, url, in, out)
+}
+
+// DoProtobufRequestWithHooks is like DoProtobufRequest, but calls the client
+// hooks around the request.
+func DoProtobufRequestWithHooks(ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, in, out proto.Message) error {
 	reqBodyBytes, err := proto.Marshal(in)
 	if err != nil {
 		return clientError("failed to marshal proto request", err)
 	}
-	reqBody := bytes.NewBuffer(reqBodyBytes)
-	if err = ctx.Err(); err != nil {
-		return clientError("aborted because context was done", err)
-	}
 
-	req, err := newRequest(ctx, url, reqBody, "application/protobuf")
-	if err != nil {
-		return clientError("could not build request", err)
-	}
-	resp, err := client.Do(req)
+	return doRequest(ctx, client, hooks, url, reqBodyBytes, "application/protobuf", func(body []byte) Error {
+		if err := proto.Unmarshal(body, out); err != nil {
+			return clientError("failed to unmarshal proto response", err)
+		}
+		return nil
+	})
+}
+
+// DoJSONRequest is common code to make a request to the remote twirp service.
+func DoJSONRequest(ctx context.Context, client HTTPClient, url string, in, out proto.Message) error {
+	return DoJSONRequestWithHooks(ctx, client, nil, url, in, out)
+}
+
+// DoJSONRequestWithHooks is like DoJSONRequest, but calls the client hooks
+// around the request.
+func DoJSONRequestWithHooks(ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, in, out proto.Message) error {
+	marshaler := protojson.MarshalOptions{UseProtoNames: true}
+	buf, err := marshaler.Marshal(in)
 	if err != nil {
-		return clientError("failed to do request", err)
+		return clientError("failed to marshal json request", err)
 	}
 
-	defer func() {
-		cerr := resp.Body.Close()
-		if err == nil && cerr != nil {
-			err = clientError("failed to close response body", cerr)
+	return doRequest(ctx, client, hooks, url, buf, "application/json", func(body []byte) Error {
+		unmarshaler := protojson.UnmarshalOptions{}
+		if err := unmarshaler.Unmarshal(body, out); err != nil {
+			return clientError("failed to unmarshal json response", err)
 		}
-	}()
+		return nil
+	})
+}
 
-	if err = ctx.Err(); err != nil {
+// doRequest sends the encoded request body, calls the hooks and passes the
+// response body to decode.
+func doRequest(ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, body []byte, contentType string, decode func([]byte) Error) error {
+	if err := ctx.Err(); err != nil {
 		return clientError("aborted because context was done", err)
 	}
 
-	if resp.StatusCode != 200 {
-		return errorFromResponse(resp)
+	ctx, req, twerr := prepareRequest(ctx, hooks, url, body, contentType, contentType)
+	if twerr != nil {
+		return twerr
 	}
 
-	respBodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return clientError("failed to read response body", err)
-	}
-	if err = ctx.Err(); err != nil {
-		return clientError("aborted because context was done", err)
+	ctx, twerr = sendRequest(ctx, client, req, decode)
+	if twerr != nil {
+		hooks.callError(ctx, twerr)
+		return twerr
 	}
 
-	if err = proto.Unmarshal(respBodyBytes, out); err != nil {
-		return clientError("failed to unmarshal proto response", err)
-	}
+	hooks.callResponseReceived(ctx)
 	return nil
 }
 
-// DoJSONRequest is common code to make a request to the remote twirp service.
-func DoJSONRequest(ctx context.Context, client HTTPClient, url string, in, out proto.Message) (err error) {
-	marshaler := protojson.MarshalOptions{UseProtoNames: true}
-	var buf []byte
-	if buf, err = marshaler.Marshal(in); err != nil {
-		return clientError("failed to marshal json request", err)
-	}
-	if err = ctx.Err(); err != nil {
-		return clientError("aborted because context was done", err)
+// prepareRequest builds the request and calls the RequestPrepared hook. The
+// Error hook is called if the request can not be sent.
+func prepareRequest(ctx context.Context, hooks *ClientHooks, url string, body []byte, contentType, accept string) (context.Context, *http.Request, Error) {
+	req, err := newRequest(ctx, url, bytes.NewReader(body), contentType)
+	if err != nil {
+		return ctx, nil, clientError("could not build request", err)
 	}
+	req.Header.Set("Accept", accept)
 
-	reqBody := bytes.NewReader(buf)
-
-	req, err := newRequest(ctx, url, reqBody, "application/json")
+	ctx, err = hooks.callRequestPrepared(ctx, req)
 	if err != nil {
-		return clientError("could not build request", err)
+		twerr, ok := err.(Error)
+		if !ok {
+			twerr = clientError("request prepared hook failed", err)
+		}
+		hooks.callError(ctx, twerr)
+		return ctx, nil, twerr
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return clientError("failed to do request", err)
+	req = req.WithContext(ctx)
+
+	// Propagate the remaining budget, and don't bother sending a request the
+	// server would reject anyway.
+	if budget, ok := remainingBudget(ctx); ok {
+		if budget <= 0 {
+			twerr := NewError(DeadlineExceeded, "deadline exceeded before sending request")
+			hooks.callError(ctx, twerr)
+			return ctx, nil, twerr
+		}
+		req.Header.Set(TimeoutHeader, FormatTimeout(budget))
+	}
+
+	return ctx, req, nil
+}
+
+// sendRequest returns a context carrying the response status code, if any.
+func sendRequest(ctx context.Context, client HTTPClient, req *http.Request, decode func([]byte) Error) (_ context.Context, err Error) {
+	resp, rerr := client.Do(req)
+	if rerr != nil {
+		return ctx, clientError("failed to do request", rerr)
 	}
+	ctx = WithStatusCode(ctx, resp.StatusCode)
 
 	defer func() {
 		cerr := resp.Body.Close()
@@ -103,26 +141,23 @@ func DoJSONRequest(ctx context.Context, client HTTPClient, url string, in, out p
 		}
 	}()
 
-	if err = ctx.Err(); err != nil {
-		return clientError("aborted because context was done", err)
+	if cerr := ctx.Err(); cerr != nil {
+		return ctx, clientError("aborted because context was done", cerr)
 	}
 
 	if resp.StatusCode != 200 {
-		return errorFromResponse(resp)
+		return ctx, errorFromResponse(resp)
 	}
 
-	unmarshaler := protojson.UnmarshalOptions{}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return clientError("failed to read response body", err)
+	respBodyBytes, rerr := io.ReadAll(resp.Body)
+	if rerr != nil {
+		return ctx, clientError("failed to read response body", rerr)
 	}
-	if err = unmarshaler.Unmarshal(body, out); err != nil {
-		return clientError("failed to unmarshal json response", err)
+	if cerr := ctx.Err(); cerr != nil {
+		return ctx, clientError("aborted because context was done", cerr)
 	}
-	if err = ctx.Err(); err != nil {
-		return clientError("aborted because context was done", err)
-	}
-	return nil
+
+	return ctx, decode(respBodyBytes)
 }
 
 // newRequest makes an http.Request from a client, adding common headers.
@@ -161,9 +196,11 @@ func getCustomHTTPReqHeaders(ctx context.Context) http.Header {
 	return copied
 }
 
-// clientError adds consistency to errors generated in the client
+// clientError wraps errors that happen on the client side. Errors registered
+// with RegisterError, such as context.DeadlineExceeded, keep their codes;
+// everything else is an internal error.
 func clientError(desc string, err error) Error {
-	return InternalErrorWith(wrapErr(err, desc))
+	return ConvertError(wrapErr(err, desc))
 }
 
 // wrappedError implements the github.com/pkg/errors.Causer interface, allowing errors to be
@@ -176,6 +213,7 @@ type wrappedError struct {
 func wrapErr(err error, msg string) error { return &wrappedError{msg: msg, cause: err} }
 func (e *wrappedError) Cause() error      { return e.cause }
 func (e *wrappedError) Error() string     { return e.msg + ": " + e.cause.Error() }
+func (e *wrappedError) Unwrap() error     { return e.cause }
 
 // errorFromResponse builds a Error from a non-200 HTTP response.
 // If the response has a valid serialized Twirp error, then it's returned.
@@ -198,19 +236,36 @@ func errorFromResponse(resp *http.Response) Error {
 	if err != nil {
 		return clientError("failed to read server error response body", err)
 	}
-	var tj twerr
+	var tj twerrJSON
 	if err := json.Unmarshal(respBodyBytes, &tj); err != nil {
 		// Invalid JSON response; it must be an error from an intermediary.
 		msg := fmt.Sprintf("Error from intermediary with HTTP status code %d %q", statusCode, statusText)
 		return twirpErrorFromIntermediary(statusCode, msg, string(respBodyBytes))
 	}
 
-	if !IsValidErrorCode(tj.Code()) {
-		msg := "invalid type returned from server error response: " + string(tj.Code())
+	return tj.toError()
+}
+
+// toError converts an error decoded from a server response into a twirp.Error.
+func (tj *twerrJSON) toError() Error {
+	code := ErrorCode(tj.Code)
+	if !IsValidErrorCode(code) || code == NoError {
+		msg := "invalid type returned from server error response: " + tj.Code
 		return InternalError(msg)
 	}
 
-	return &tj
+	e := &twerr{code: code, msg: tj.Msg, meta: tj.Meta}
+	for _, raw := range tj.Details {
+		a := &anypb.Any{}
+		// Details of types unknown to the client can not be decoded from
+		// JSON, they are dropped.
+		if err := protojson.Unmarshal(raw, a); err != nil {
+			continue
+		}
+		e.details = append(e.details, a)
+	}
+
+	return e
 }
 
 // twirpErrorFromIntermediary maps HTTP errors from non-twirp sources to twirp errors.
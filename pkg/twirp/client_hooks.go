@@ -0,0 +1,174 @@
+package twirp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// ClientHooks is a container for callbacks that can instrument a
+// Twirp-generated client. These callbacks all accept a context and some return
+// a context. They can use this to add to the context, appending values or
+// deadlines to it.
+//
+// The RequestPrepared hook is special because it can return errors. If it
+// returns a non-nil error, the request will be aborted before it is sent and
+// the Error hook will be called.
+//
+// Exactly one of ResponseReceived and Error is called for every request that
+// passed RequestPrepared.
+type ClientHooks struct {
+	// RequestPrepared is called as soon as a request has been created and
+	// before it has been sent to the Twirp server. The request headers may be
+	// modified. The returned context is attached to the request.
+	RequestPrepared func(context.Context, *http.Request) (context.Context, error)
+
+	// ResponseReceived is called after a request has finished sending and a
+	// successful response has been decoded.
+	ResponseReceived func(context.Context)
+
+	// Error hook is called whenever an error occurs during the sending of a
+	// request. The Error is passed as an argument to the hook. If the server
+	// responded, the HTTP status code is available through StatusCode.
+	Error func(context.Context, Error)
+}
+
+func (h *ClientHooks) callRequestPrepared(ctx context.Context, req *http.Request) (context.Context, error) {
+	if h == nil || h.RequestPrepared == nil {
+		return ctx, nil
+	}
+	return h.RequestPrepared(ctx, req)
+}
+
+func (h *ClientHooks) callResponseReceived(ctx context.Context) {
+	if h == nil || h.ResponseReceived == nil {
+		return
+	}
+	h.ResponseReceived(ctx)
+}
+
+func (h *ClientHooks) callError(ctx context.Context, err Error) {
+	if h == nil || h.Error == nil {
+		return
+	}
+	h.Error(ctx, err)
+}
+
+// ChainClientHooks creates a new *ClientHooks which chains the callbacks in
+// each of the constituent hooks passed in. Each hook function will be
+// called in the order of the ClientHooks values passed in.
+//
+// For the erroring hook, RequestPrepared, any returned errors prevent
+// processing by later hooks.
+func ChainClientHooks(hooks ...*ClientHooks) *ClientHooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+	if len(hooks) == 1 {
+		return hooks[0]
+	}
+	return &ClientHooks{
+		RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+			var err error
+			for _, h := range hooks {
+				if h != nil && h.RequestPrepared != nil {
+					ctx, err = h.RequestPrepared(ctx, req)
+					if err != nil {
+						return ctx, err
+					}
+				}
+			}
+			return ctx, nil
+		},
+		ResponseReceived: func(ctx context.Context) {
+			for _, h := range hooks {
+				if h != nil && h.ResponseReceived != nil {
+					h.ResponseReceived(ctx)
+				}
+			}
+		},
+		Error: func(ctx context.Context, twerr Error) {
+			for _, h := range hooks {
+				if h != nil && h.Error != nil {
+					h.Error(ctx, twerr)
+				}
+			}
+		},
+	}
+}
+
+// ClientOptions encapsulate the configurable parameters on a Twirp client.
+type ClientOptions struct {
+	Hooks *ClientHooks
+
+	compression *ClientHooks
+}
+
+// ClientOption is a functional option for extending a Twirp client.
+type ClientOption func(*ClientOptions)
+
+// WithClientHooks defines the hooks for a Twirp client. It can be used
+// several times, the hooks are chained in order.
+func WithClientHooks(hooks *ClientHooks) ClientOption {
+	return func(o *ClientOptions) {
+		if o.Hooks == nil {
+			o.Hooks = hooks
+			return
+		}
+		o.Hooks = ChainClientHooks(o.Hooks, hooks)
+	}
+}
+
+// NewClientOptions applies opts in order, it is used by generated clients.
+func NewClientOptions(opts ...ClientOption) ClientOptions {
+	o := ClientOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Compress after all other hooks, so that hooks signing the body see the
+	// same bytes as the server after decoding.
+	if o.compression != nil {
+		o.Hooks = ChainClientHooks(o.Hooks, o.compression)
+	}
+	return o
+}
+
+// WithRequestCompression compresses request bodies of at least minSize bytes
+// with encoding, which is EncodingGzip or EncodingZstd. Only use it against
+// servers known to support the encoding.
+func WithRequestCompression(encoding string, minSize int) ClientOption {
+	return func(o *ClientOptions) {
+		o.compression = &ClientHooks{
+			RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+				return ctx, compressRequest(req, encoding, minSize)
+			},
+		}
+	}
+}
+
+func compressRequest(req *http.Request, encoding string, minSize int) error {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "" ||
+		req.ContentLength < int64(minSize) {
+		return nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body.Close()
+
+	body, err = CompressRequestBody(encoding, body)
+	if err != nil {
+		return err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Encoding", encoding)
+	return nil
+}
@@ -0,0 +1,109 @@
+package twirp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClientHooks(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-foo") != "bar" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(marshalErrorToJSON(NewError(Unauthenticated, "no foo")))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"pong"`))
+	}))
+	defer s.Close()
+
+	var calls []string
+	var status string
+	hooks := &ClientHooks{
+		RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+			calls = append(calls, "prepared")
+			if v, _ := ctx.Value("foo").(string); v != "" {
+				req.Header.Set("x-foo", v)
+			}
+			return ctx, nil
+		},
+		ResponseReceived: func(ctx context.Context) {
+			calls = append(calls, "received")
+			status, _ = StatusCode(ctx)
+		},
+		Error: func(ctx context.Context, err Error) {
+			calls = append(calls, "error")
+			status, _ = StatusCode(ctx)
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), "foo", "bar")
+	out := &wrapperspb.StringValue{}
+	err := DoJSONRequestWithHooks(ctx, http.DefaultClient, hooks, s.URL, wrapperspb.String("ping"), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Value != "pong" || status != "200" {
+		t.Fatal("invalid response", out, status)
+	}
+
+	err = DoJSONRequestWithHooks(context.Background(), http.DefaultClient, hooks, s.URL, wrapperspb.String("ping"), out)
+	if twerr, ok := err.(Error); !ok || twerr.Code() != Unauthenticated {
+		t.Fatal("invalid error", err)
+	}
+	if status != "401" {
+		t.Fatal("invalid status", status)
+	}
+
+	want := []string{"prepared", "received", "prepared", "error"}
+	if len(calls) != len(want) {
+		t.Fatal(calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatal(calls)
+		}
+	}
+}
+
+func TestClientHooksAbort(t *testing.T) {
+	var code ErrorCode
+	hooks := ChainClientHooks(
+		&ClientHooks{
+			RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+				return ctx, errors.New("abort")
+			},
+		},
+		&ClientHooks{
+			RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+				t.Fatal("later hooks should not be called")
+				return ctx, nil
+			},
+			Error: func(ctx context.Context, err Error) {
+				code = err.Code()
+			},
+		},
+	)
+
+	err := DoProtobufRequestWithHooks(context.Background(), http.DefaultClient, hooks,
+		"http://127.0.0.1:0", wrapperspb.String("ping"), &wrapperspb.StringValue{})
+	if err == nil || code != Internal {
+		t.Fatal("request should be aborted", err, code)
+	}
+}
+
+func TestWithClientHooks(t *testing.T) {
+	var n int
+	h := &ClientHooks{ResponseReceived: func(context.Context) { n++ }}
+
+	o := NewClientOptions(WithClientHooks(h), WithClientHooks(h))
+	o.Hooks.callResponseReceived(context.Background())
+	if n != 2 {
+		t.Fatal("hooks should be chained", n)
+	}
+}
@@ -0,0 +1,179 @@
+// Package clienthooks 提供 twirp 客户端常用的钩子
+//
+// 使用示例：
+//
+//	c := foo_v1.NewFooJSONClient(addr, http.DefaultClient,
+//		twirp.WithClientHooks(clienthooks.Default),
+//		twirp.WithClientHooks(clienthooks.Header("x-user-id")),
+//	)
+package clienthooks
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kiss/sniper/pkg/auth"
+	"github.com/go-kiss/sniper/pkg/log"
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+type spanKey struct{}
+type startKey struct{}
+
+// Default 包含链路追踪、日志和监控指标
+var Default = twirp.ChainClientHooks(Trace, Log, Metrics)
+
+// Trace 为每次调用创建 span，并通过请求头传递给服务端
+var Trace = &twirp.ClientHooks{
+	RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+		span, ctx := opentracing.StartSpanFromContext(ctx, "Twirp")
+		ext.SpanKindRPCClient.Set(span)
+		span.SetTag("method", fullMethod(ctx))
+
+		opentracing.GlobalTracer().Inject(
+			span.Context(),
+			opentracing.HTTPHeaders,
+			opentracing.HTTPHeadersCarrier(req.Header),
+		)
+
+		return context.WithValue(ctx, spanKey{}, span), nil
+	},
+	ResponseReceived: func(ctx context.Context) {
+		if span, ok := ctx.Value(spanKey{}).(opentracing.Span); ok {
+			span.Finish()
+		}
+	},
+	Error: func(ctx context.Context, err twirp.Error) {
+		span, ok := ctx.Value(spanKey{}).(opentracing.Span)
+		if !ok {
+			return
+		}
+		ext.Error.Set(span, true)
+		span.SetTag("code", string(err.Code()))
+		ext.LogError(span, err)
+		span.Finish()
+	},
+}
+
+// Log 记录调用日志，服务端错误和网络错误记录为 error，其余错误记录为 warn
+var Log = &twirp.ClientHooks{
+	RequestPrepared: start,
+	ResponseReceived: func(ctx context.Context) {
+		log.Get(ctx).Debugf("[Twirp] method:%s status:%s cost:%.3f",
+			fullMethod(ctx), status(ctx, nil), cost(ctx).Seconds())
+	},
+	Error: func(ctx context.Context, err twirp.Error) {
+		logger := log.Get(ctx).WithFields(log.Fields{
+			"method": fullMethod(ctx),
+			"status": status(ctx, err),
+			"cost":   cost(ctx).Seconds(),
+		})
+
+		if twirp.ServerHTTPStatusFromErrorCode(err.Code()) >= 500 {
+			logger.Errorf("[Twirp] %+v", err)
+		} else {
+			logger.Warnf("[Twirp] %v", err)
+		}
+	},
+}
+
+// Metrics 按方法和状态码统计调用耗时
+var Metrics = &twirp.ClientHooks{
+	RequestPrepared: start,
+	ResponseReceived: func(ctx context.Context) {
+		observe(ctx, nil)
+	},
+	Error: func(ctx context.Context, err twirp.Error) {
+		observe(ctx, err)
+	},
+}
+
+// Header 把当前服务收到的请求头透传给下游服务
+//
+// 只在服务端处理请求的 ctx 中生效，已经设置的请求头不会被覆盖。
+func Header(keys ...string) *twirp.ClientHooks {
+	return &twirp.ClientHooks{
+		RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+			in, ok := twirp.HttpRequest(ctx)
+			if !ok || in == nil {
+				return ctx, nil
+			}
+
+			for _, k := range keys {
+				if req.Header.Get(k) != "" {
+					continue
+				}
+				if v := in.Header.Get(k); v != "" {
+					req.Header.Set(k, v)
+				}
+			}
+			return ctx, nil
+		},
+	}
+}
+
+// HMAC 使用 auth.SignRequest 为请求签名
+func HMAC(key, secret string) *twirp.ClientHooks {
+	return &twirp.ClientHooks{
+		RequestPrepared: func(ctx context.Context, req *http.Request) (context.Context, error) {
+			return ctx, auth.SignRequest(req, key, secret)
+		},
+	}
+}
+
+func observe(ctx context.Context, err twirp.Error) {
+	if _, ok := ctx.Value(startKey{}).(time.Time); !ok {
+		return
+	}
+
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+	if pkg != "" {
+		service = pkg + "." + service
+	}
+
+	clientDurations.WithLabelValues(
+		service,
+		method,
+		status(ctx, err),
+	).Observe(cost(ctx).Seconds())
+}
+
+// start 记录开始时间，多个钩子同时使用时只记录一次
+func start(ctx context.Context, req *http.Request) (context.Context, error) {
+	if _, ok := ctx.Value(startKey{}).(time.Time); ok {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func cost(ctx context.Context) time.Duration {
+	t, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(t)
+}
+
+// status 返回 http 状态码，没有收到响应时根据错误码推算
+func status(ctx context.Context, err twirp.Error) string {
+	if s, ok := twirp.StatusCode(ctx); ok {
+		return s
+	}
+	if err == nil {
+		return "200"
+	}
+	return strconv.Itoa(twirp.ServerHTTPStatusFromErrorCode(err.Code()))
+}
+
+func fullMethod(ctx context.Context) string {
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+	return "/" + pkg + "." + service + "/" + method
+}
@@ -0,0 +1,46 @@
+package clienthooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestHooks(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-user-id") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`"pong"`))
+	}))
+	defer s.Close()
+
+	in, _ := http.NewRequest("POST", "/", nil)
+	in.Header.Set("x-user-id", "1")
+
+	ctx := twirp.WithHttpRequest(context.Background(), in)
+	ctx = twirp.WithPackageName(ctx, "foo.v1")
+	ctx = twirp.WithServiceName(ctx, "Foo")
+	ctx = twirp.WithMethodName(ctx, "Echo")
+
+	hooks := twirp.ChainClientHooks(Default, Header("x-user-id"))
+	out := &wrapperspb.StringValue{}
+	if err := twirp.DoJSONRequestWithHooks(ctx, http.DefaultClient, hooks, s.URL, wrapperspb.String("ping"), out); err != nil {
+		t.Fatal(err)
+	}
+
+	in.Header.Del("x-user-id")
+	if err := twirp.DoJSONRequestWithHooks(ctx, http.DefaultClient, hooks, s.URL, wrapperspb.String("ping"), out); err == nil {
+		t.Fatal("header should not be propagated")
+	}
+
+	if n := testutil.CollectAndCount(clientDurations); n != 2 {
+		t.Fatal("invalid metrics", n)
+	}
+}
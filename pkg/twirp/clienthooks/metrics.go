@@ -0,0 +1,19 @@
+package clienthooks
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1}
+
+var clientDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "sniper",
+	Subsystem: "twirp_client",
+	Name:      "durations_seconds",
+	Help:      "twirp client latency distributions",
+	Buckets:   defBuckets,
+}, []string{"service", "method", "status"})
+
+func init() {
+	prometheus.MustRegister(clientDurations)
+}
@@ -17,6 +17,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -35,6 +36,8 @@ const (
 	ResponseKey
 	AllowGETKey
 	MethodOptionKey
+	MaxBodySizeKey
+	CompressMinSizeKey
 )
 
 // MethodName extracts the name of the method being handled in the given
@@ -197,3 +200,31 @@ func AddHTTPResponseHeader(ctx context.Context, key, value string) error {
 
 	return nil
 }
+
+// MethodOptionValue parses the option of service method as comma separated
+// key=value pairs, e.g. "cache=10s,auth=user", and returns the value of key.
+// A key without "=" has an empty value.
+// If the key is not set, it returns ("", false).
+func MethodOptionValue(ctx context.Context, key string) (string, bool) {
+	option, _ := MethodOption(ctx)
+	for _, kv := range strings.Split(option, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		if k != "" && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ParseMethodOption parses a method option like MethodOptionValue, and returns
+// all the key=value pairs.
+func ParseMethodOption(option string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range strings.Split(option, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		if k != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -4,7 +4,7 @@
 // use this file except in compliance with the License. A copy of the License is
 // located at
 //
-//     http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // or in the "license" file accompanying this file. This file is distributed on
 // an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
@@ -17,32 +17,46 @@
 //
 // For example, a server method may return an InvalidArgumentError:
 //
-//     if req.Order != "DESC" && req.Order != "ASC" {
-//         return nil, twirp.InvalidArgumentError("Order", "must be DESC or ASC")
-//     }
+//	if req.Order != "DESC" && req.Order != "ASC" {
+//		return nil, twirp.InvalidArgumentError("Order", "must be DESC or ASC")
+//	}
 //
 // And the same twirp.Error is returned by the client, for example:
 //
-//     resp, err := twirpClient.RPCMethod(ctx, req)
-//     if err != nil {
-//         if twerr := err.(twirp.Error) {
-//             switch twerr.Code() {
-//             case twirp.InvalidArgument:
-//                 log.Error("invalid argument "+twirp.Meta("argument"))
-//             default:
-//                 log.Error(twerr.Error())
-//             }
-//         }
-//     }
+//	resp, err := twirpClient.RPCMethod(ctx, req)
+//	if err != nil {
+//		if twerr := err.(twirp.Error) {
+//			switch twerr.Code() {
+//			case twirp.InvalidArgument:
+//				log.Error("invalid argument "+twirp.Meta("argument"))
+//			default:
+//				log.Error(twerr.Error())
+//			}
+//		}
+//	}
 //
 // Clients may also return Internal errors if something failed on the system:
 // the server, the network, or the client itself (i.e. failure parsing
 // response).
 //
+// Structured details can be attached to errors with WithDetails, they are
+// serialized in the error response and restored by the client:
+//
+//	return nil, twirp.WithDetails(twirp.NewError(twirp.ResourceExhausted, "quota"), &pb.QuotaFailure{...})
+//
+//	for _, d := range twirp.Details(err) {
+//		if q, ok := d.(*pb.QuotaFailure); ok { ... }
+//	}
 package twirp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // Error represents an error in a Twirp service call.
@@ -134,6 +148,120 @@ func InternalErrorWith(err error) Error {
 	}
 }
 
+// WrapError returns a copy of twerr that wraps err. The returned error
+// supports errors.Is and errors.As for the wrapped error, which is never sent
+// to the client.
+func WrapError(twerr Error, err error) Error {
+	return &wrappedErr{
+		wrapper: twerr,
+		cause:   err,
+	}
+}
+
+// WithDetails returns a copy of err with the given messages attached as
+// details. Details are serialized as google.protobuf.Any in the JSON error
+// response, so the message types must be linked into the client binary to be
+// restored by Details. Messages which can not be marshaled are dropped.
+func WithDetails(err Error, details ...proto.Message) Error {
+	anys := make([]*anypb.Any, 0, len(details))
+	for _, d := range details {
+		a, aerr := anypb.New(d)
+		if aerr != nil {
+			continue
+		}
+		anys = append(anys, a)
+	}
+	return withDetails(err, anys)
+}
+
+func withDetails(err Error, anys []*anypb.Any) Error {
+	if d, ok := err.(detailer); ok {
+		return d.withErrorDetails(anys)
+	}
+
+	// Other implementations of Error can not hold details, copy them into a
+	// twerr but keep the original error reachable for errors.As.
+	e := &twerr{code: err.Code(), msg: err.Msg(), meta: err.MetaMap()}
+	return &wrappedErr{wrapper: e.withErrorDetails(anys), cause: err}
+}
+
+// Details returns the details attached to err, decoded into their concrete
+// message types. Details of unknown types are returned as *anypb.Any.
+func Details(err error) []proto.Message {
+	var d detailer
+	if !errors.As(err, &d) {
+		return nil
+	}
+
+	anys := d.errorDetails()
+	msgs := make([]proto.Message, 0, len(anys))
+	for _, a := range anys {
+		m, uerr := a.UnmarshalNew()
+		if uerr != nil {
+			msgs = append(msgs, a)
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// detailer is implemented by errors which can hold details.
+type detailer interface {
+	errorDetails() []*anypb.Any
+	withErrorDetails([]*anypb.Any) Error
+}
+
+type registeredError struct {
+	target error
+	code   ErrorCode
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = []registeredError{
+		{context.Canceled, Canceled},
+		{context.DeadlineExceeded, DeadlineExceeded},
+	}
+)
+
+// RegisterError maps errors matching target (according to errors.Is) to the
+// given code when they are returned by a service method. It is usually called
+// in an init function of the package which defines target.
+//
+// context.Canceled and context.DeadlineExceeded are registered by default.
+func RegisterError(target error, code ErrorCode) {
+	if !IsValidErrorCode(code) || code == NoError {
+		panic("twirp: invalid error code " + string(code))
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, registeredError{target: target, code: code})
+}
+
+// ConvertError converts any error into a twirp.Error. Errors which are, or
+// wrap, a twirp.Error are returned as is. Errors registered with
+// RegisterError get the registered code and the original message. All other
+// errors are wrapped with InternalErrorWith. The original error is always
+// reachable with errors.Is and errors.As.
+func ConvertError(err error) Error {
+	var twerr Error
+	if errors.As(err, &twerr) {
+		return twerr
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for i := len(registry) - 1; i >= 0; i-- {
+		if errors.Is(err, registry[i].target) {
+			return WrapError(NewError(registry[i].code, err.Error()), err)
+		}
+	}
+
+	return InternalErrorWith(err)
+}
+
 // ErrorCode represents a Twirp error type.
 type ErrorCode string
 
@@ -283,9 +411,10 @@ func IsValidErrorCode(code ErrorCode) bool {
 
 // twirp.Error implementation
 type twerr struct {
-	code ErrorCode
-	msg  string
-	meta map[string]string
+	code    ErrorCode
+	msg     string
+	meta    map[string]string
+	details []*anypb.Any
 }
 
 func (e *twerr) Code() ErrorCode { return e.code }
@@ -300,9 +429,10 @@ func (e *twerr) Meta(key string) string {
 
 func (e *twerr) WithMeta(key string, value string) Error {
 	newErr := &twerr{
-		code: e.code,
-		msg:  e.msg,
-		meta: make(map[string]string, len(e.meta)),
+		code:    e.code,
+		msg:     e.msg,
+		meta:    make(map[string]string, len(e.meta)),
+		details: e.details,
 	}
 	for k, v := range e.meta {
 		newErr.meta[k] = v
@@ -319,6 +449,20 @@ func (e *twerr) Error() string {
 	return fmt.Sprintf("twirp error %s: %s", e.code, e.msg)
 }
 
+func (e *twerr) errorDetails() []*anypb.Any { return e.details }
+
+func (e *twerr) withErrorDetails(anys []*anypb.Any) Error {
+	details := make([]*anypb.Any, 0, len(e.details)+len(anys))
+	details = append(details, e.details...)
+	details = append(details, anys...)
+	return &twerr{
+		code:    e.code,
+		msg:     e.msg,
+		meta:    e.meta,
+		details: details,
+	}
+}
+
 // wrappedErr fulfills the twirp.Error interface and the
 // github.com/pkg/errors.Causer interface. It exposes all the twirp error
 // methods, but root cause of an error can be retrieved with
@@ -340,4 +484,19 @@ func (e *wrappedErr) WithMeta(key string, val string) Error {
 		cause:   e.cause,
 	}
 }
-func (e *wrappedErr) Cause() error { return e.cause }
+func (e *wrappedErr) Cause() error  { return e.cause }
+func (e *wrappedErr) Unwrap() error { return e.cause }
+
+func (e *wrappedErr) errorDetails() []*anypb.Any {
+	if d, ok := e.wrapper.(detailer); ok {
+		return d.errorDetails()
+	}
+	return nil
+}
+
+func (e *wrappedErr) withErrorDetails(anys []*anypb.Any) Error {
+	return &wrappedErr{
+		wrapper: withDetails(e.wrapper, anys),
+		cause:   e.cause,
+	}
+}
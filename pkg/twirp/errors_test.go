@@ -14,9 +14,14 @@
 package twirp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http/httptest"
 	"sync"
 	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestWithMetaRaces(t *testing.T) {
@@ -38,3 +43,70 @@ func TestWithMetaRaces(t *testing.T) {
 		t.Errorf("err was mutated")
 	}
 }
+
+var errDomain = errors.New("domain error")
+
+func TestConvertError(t *testing.T) {
+	RegisterError(errDomain, FailedPrecondition)
+
+	err := ConvertError(fmt.Errorf("foo: %w", errDomain))
+	if err.Code() != FailedPrecondition || err.Msg() != "foo: domain error" {
+		t.Fatal("invalid error", err)
+	}
+	if !errors.Is(err, errDomain) {
+		t.Fatal("domain error should be wrapped")
+	}
+
+	err = ConvertError(fmt.Errorf("rpc: %w", context.DeadlineExceeded))
+	if err.Code() != DeadlineExceeded {
+		t.Fatal("invalid error", err)
+	}
+
+	twerr := NotFoundError("foo")
+	if err = ConvertError(fmt.Errorf("bar: %w", twerr)); err != twerr {
+		t.Fatal("twirp error should be returned as is", err)
+	}
+
+	cause := errors.New("boom")
+	err = ConvertError(cause)
+	if err.Code() != Internal || !errors.Is(err, cause) {
+		t.Fatal("invalid error", err)
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	cause := errors.New("boom")
+	err := WrapError(NewError(ResourceExhausted, "quota"), cause)
+	err = WithDetails(err, wrapperspb.String("foo"), wrapperspb.Int64(1))
+	err = err.WithMeta("k", "v")
+
+	if !errors.Is(err, cause) {
+		t.Fatal("cause should be kept")
+	}
+	if len(Details(err)) != 2 {
+		t.Fatal("invalid details", Details(err))
+	}
+
+	w := httptest.NewRecorder()
+	(*ServerHooks)(nil).WriteError(context.Background(), w, err)
+	resp := w.Result()
+	if resp.StatusCode != 403 {
+		t.Fatal("invalid status", resp.StatusCode)
+	}
+
+	got := errorFromResponse(resp)
+	if got.Code() != ResourceExhausted || got.Msg() != "quota" || got.Meta("k") != "v" {
+		t.Fatal("invalid error", got)
+	}
+
+	details := Details(got)
+	if len(details) != 2 {
+		t.Fatal("invalid details", details)
+	}
+	if s, ok := details[0].(*wrapperspb.StringValue); !ok || s.Value != "foo" {
+		t.Fatal("invalid detail", details[0])
+	}
+	if i, ok := details[1].(*wrapperspb.Int64Value); !ok || i.Value != 1 {
+		t.Fatal("invalid detail", details[1])
+	}
+}
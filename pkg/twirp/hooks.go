@@ -16,7 +16,10 @@ package twirp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 // ServerHooks is a container for callbacks that can instrument a
@@ -101,13 +104,15 @@ func (h *ServerHooks) CallError(ctx context.Context, err Error) context.Context
 
 // WriteError writes Twirp errors in the response and triggers hooks.
 func (h *ServerHooks) WriteError(ctx context.Context, resp http.ResponseWriter, err error) {
-	// Non-twirp errors are wrapped as Internal (default)
-	twerr, ok := err.(Error)
-	if !ok {
-		twerr = InternalErrorWith(err)
-	}
+	// Registered errors get their code, other non-twirp errors are wrapped
+	// as Internal (default)
+	twerr := ConvertError(err)
 
 	statusCode := ServerHTTPStatusFromErrorCode(twerr.Code())
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		statusCode = http.StatusRequestEntityTooLarge
+	}
 	ctx = WithStatusCode(ctx, statusCode)
 	ctx = h.CallError(ctx, twerr)
 
@@ -137,6 +142,15 @@ func (h *ServerHooks) WriteError(ctx context.Context, resp http.ResponseWriter,
 	h.CallResponseSent(ctx)
 }
 
+// twerrJSON is the JSON representation of a twirp.Error. Each detail is a
+// google.protobuf.Any in its JSON form.
+type twerrJSON struct {
+	Code    string            `json:"code"`
+	Msg     string            `json:"msg"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
 // marshalErrorToJSON returns JSON from a twirp.Error, that can be used as HTTP error response body.
 // If serialization fails, it will use a descriptive Internal error instead.
 func marshalErrorToJSON(twerr Error) []byte {
@@ -146,18 +160,23 @@ func marshalErrorToJSON(twerr Error) []byte {
 		msg = msg[:1000000]
 	}
 
-	type twerrJSON struct {
-		Code string            `json:"code"`
-		Msg  string            `json:"msg"`
-		Meta map[string]string `json:"meta,omitempty"`
-	}
-
 	tj := twerrJSON{
 		Code: string(twerr.Code()),
 		Msg:  msg,
 		Meta: twerr.MetaMap(),
 	}
 
+	var d detailer
+	if errors.As(twerr, &d) {
+		for _, a := range d.errorDetails() {
+			buf, err := protojson.Marshal(a)
+			if err != nil {
+				continue // the type is not linked in, nothing useful to send
+			}
+			tj.Details = append(tj.Details, buf)
+		}
+	}
+
 	buf, err := json.Marshal(&tj)
 	if err != nil {
 		buf = []byte("{\"type\": \"" + Internal + "\", \"msg\": \"There was an error but it could not be serialized into JSON\"}") // fallback
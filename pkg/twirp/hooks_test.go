@@ -86,3 +86,19 @@ func TestChainHooks(t *testing.T) {
 	// When none of the chained hooks has a handler there should be no panic.
 	chain.ResponseSent(ctx)
 }
+
+func TestMethodOptionValue(t *testing.T) {
+	ctx := WithMethodOption(context.Background(), "cache=10s,auth,skip=")
+
+	for k, want := range map[string]string{"cache": "10s", "auth": "", "skip": ""} {
+		if v, ok := MethodOptionValue(ctx, k); !ok || v != want {
+			t.Fatal("invalid option", k, v, ok)
+		}
+	}
+	if _, ok := MethodOptionValue(ctx, "foo"); ok {
+		t.Fatal("foo should not be set")
+	}
+	if _, ok := MethodOptionValue(context.Background(), "cache"); ok {
+		t.Fatal("cache should not be set")
+	}
+}
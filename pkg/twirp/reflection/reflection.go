@@ -0,0 +1,301 @@
+// Package reflection 根据 twirp.Server 的 ServiceDescriptor 生成服务目录
+//
+// 服务目录包含服务和方法的路径、注释、方法注释中的 sniper 选项，
+// 以及请求和响应用到的全部消息和枚举的结构，供调试工具、网页控制台
+// 和客户端生成工具使用。
+//
+//	h, err := reflection.NewHandler(foo_v1.NewFooServer(s, hooks))
+//	mux.Handle("/rpc/services", h)
+package reflection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kiss/sniper/pkg/twirp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Service 服务描述
+type Service struct {
+	Name       string    `json:"name"`
+	Package    string    `json:"package"`
+	File       string    `json:"file"`
+	PathPrefix string    `json:"path_prefix"`
+	Comment    string    `json:"comment,omitempty"`
+	Methods    []*Method `json:"methods"`
+
+	// 请求和响应直接或者间接用到的消息和枚举，键为全名
+	Messages map[string]*Message `json:"messages"`
+	Enums    map[string]*Enum    `json:"enums,omitempty"`
+}
+
+// Method 方法描述
+type Method struct {
+	Name            string            `json:"name"`
+	Path            string            `json:"path"`
+	Comment         string            `json:"comment,omitempty"`
+	Input           string            `json:"input"`
+	Output          string            `json:"output"`
+	ServerStreaming bool              `json:"server_streaming,omitempty"`
+	Options         map[string]string `json:"options,omitempty"`
+}
+
+// Message 消息描述
+type Message struct {
+	Name    string   `json:"name"`
+	Comment string   `json:"comment,omitempty"`
+	Fields  []*Field `json:"fields"`
+}
+
+// Field 字段描述
+//
+// Kind 为 protobuf 类型，如 string、int64、message、enum，
+// message 和 enum 类型的 Type 为对应的全名。map 字段的 Kind 为 map，
+// 键和值的类型分别保存在 Key 和 Value 中。
+type Field struct {
+	Name     string `json:"name"`
+	JSONName string `json:"json_name"`
+	Number   int32  `json:"number"`
+	Kind     string `json:"kind"`
+	Type     string `json:"type,omitempty"`
+	Repeated bool   `json:"repeated,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Oneof    string `json:"oneof,omitempty"`
+	Key      *Field `json:"key,omitempty"`
+	Value    *Field `json:"value,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Enum 枚举描述
+type Enum struct {
+	Name    string       `json:"name"`
+	Comment string       `json:"comment,omitempty"`
+	Values  []*EnumValue `json:"values"`
+}
+
+// EnumValue 枚举值描述
+type EnumValue struct {
+	Name    string `json:"name"`
+	Number  int32  `json:"number"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// methodOptioner 生成的服务端实现了该接口，返回方法注释中的选项
+type methodOptioner interface {
+	MethodOptions() map[string]string
+}
+
+// Describe 解析服务描述
+//
+// 引用其他文件的消息时，从 protoregistry.GlobalFiles 查找对应的文件，
+// 这类消息没有注释。
+func Describe(s twirp.Server) (*Service, error) {
+	sd, err := ServiceDescriptor(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var options map[string]string
+	if o, ok := s.(methodOptioner); ok {
+		options = o.MethodOptions()
+	}
+
+	d := &describer{
+		svc: &Service{
+			Name:       string(sd.FullName()),
+			Package:    string(sd.ParentFile().Package()),
+			File:       sd.ParentFile().Path(),
+			PathPrefix: "/" + string(sd.FullName()) + "/",
+			Comment:    comment(sd),
+			Methods:    []*Method{},
+			Messages:   map[string]*Message{},
+			Enums:      map[string]*Enum{},
+		},
+	}
+
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		m := &Method{
+			Name:            string(md.Name()),
+			Path:            d.svc.PathPrefix + string(md.Name()),
+			Comment:         comment(md),
+			Input:           string(md.Input().FullName()),
+			Output:          string(md.Output().FullName()),
+			ServerStreaming: md.IsStreamingServer(),
+		}
+		if option := options[m.Name]; option != "" {
+			m.Options = twirp.ParseMethodOption(option)
+		}
+		d.svc.Methods = append(d.svc.Methods, m)
+
+		d.message(md.Input())
+		d.message(md.Output())
+	}
+
+	return d.svc, nil
+}
+
+// ServiceDescriptor 解压并解析服务的 ServiceDescriptor
+func ServiceDescriptor(s twirp.Server) (protoreflect.ServiceDescriptor, error) {
+	gz, index := s.ServiceDescriptor()
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, fmt.Errorf("reflection: invalid service descriptor: %w", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("reflection: invalid service descriptor: %w", err)
+	}
+
+	fdp := &descriptorpb.FileDescriptorProto{}
+	if err = proto.Unmarshal(b, fdp); err != nil {
+		return nil, fmt.Errorf("reflection: invalid service descriptor: %w", err)
+	}
+
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("reflection: invalid service descriptor: %w", err)
+	}
+	if index < 0 || index >= fd.Services().Len() {
+		return nil, fmt.Errorf("reflection: service index %d out of range in %s", index, fd.Path())
+	}
+	return fd.Services().Get(index), nil
+}
+
+type describer struct {
+	svc *Service
+}
+
+// message 递归收集消息及其字段用到的消息和枚举
+func (d *describer) message(md protoreflect.MessageDescriptor) {
+	name := string(md.FullName())
+	if _, ok := d.svc.Messages[name]; ok {
+		return
+	}
+
+	m := &Message{Name: name, Comment: comment(md), Fields: []*Field{}}
+	d.svc.Messages[name] = m
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		f := d.field(fd)
+		f.Comment = comment(fd)
+		if fd.IsMap() {
+			f.Kind = "map"
+			f.Type = ""
+			f.Repeated = false
+			f.Key = d.field(fd.MapKey())
+			f.Value = d.field(fd.MapValue())
+		}
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			f.Oneof = string(od.Name())
+		}
+		m.Fields = append(m.Fields, f)
+	}
+}
+
+func (d *describer) field(fd protoreflect.FieldDescriptor) *Field {
+	f := &Field{
+		Name:     string(fd.Name()),
+		JSONName: fd.JSONName(),
+		Number:   int32(fd.Number()),
+		Kind:     fd.Kind().String(),
+		Repeated: fd.IsList(),
+		Optional: fd.HasOptionalKeyword(),
+	}
+
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		f.Type = string(fd.Message().FullName())
+		if !fd.IsMap() {
+			d.message(fd.Message())
+		}
+	case protoreflect.EnumKind:
+		f.Type = string(fd.Enum().FullName())
+		d.enum(fd.Enum())
+	}
+
+	return f
+}
+
+func (d *describer) enum(ed protoreflect.EnumDescriptor) {
+	name := string(ed.FullName())
+	if _, ok := d.svc.Enums[name]; ok {
+		return
+	}
+
+	e := &Enum{Name: name, Comment: comment(ed), Values: []*EnumValue{}}
+	values := ed.Values()
+	for i := 0; i < values.Len(); i++ {
+		vd := values.Get(i)
+		e.Values = append(e.Values, &EnumValue{
+			Name:    string(vd.Name()),
+			Number:  int32(vd.Number()),
+			Comment: comment(vd),
+		})
+	}
+	d.svc.Enums[name] = e
+}
+
+// comment 返回前置注释，没有前置注释时返回行尾注释
+func comment(d protoreflect.Descriptor) string {
+	loc := d.ParentFile().SourceLocations().ByDescriptor(d)
+	c := loc.LeadingComments
+	if strings.TrimSpace(c) == "" {
+		c = loc.TrailingComments
+	}
+
+	lines := strings.Split(strings.TrimSpace(c), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NewHandler 返回服务目录的 http.Handler
+//
+// 默认返回全部服务，通过参数 service=foo.v1.Foo 查询单个服务。
+func NewHandler(servers ...twirp.Server) (http.Handler, error) {
+	services := make([]*Service, 0, len(servers))
+	for _, s := range servers {
+		svc, err := Describe(s)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body any = map[string]any{"services": services}
+
+		if name := r.URL.Query().Get("service"); name != "" {
+			i := sort.Search(len(services), func(i int) bool { return services[i].Name >= name })
+			if i == len(services) || services[i].Name != name {
+				http.Error(w, "service not found", http.StatusNotFound)
+				return
+			}
+			body = services[i]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(body)
+	}), nil
+}
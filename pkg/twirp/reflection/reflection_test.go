@@ -0,0 +1,180 @@
+package reflection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const echoProto = `
+name: "test/v1/echo.proto"
+package: "test.v1"
+dependency: "google/protobuf/wrappers.proto"
+syntax: "proto3"
+message_type {
+  name: "EchoRequest"
+  field { name: "msg" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "msg" }
+  field { name: "tags" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".test.v1.EchoRequest.TagsEntry" json_name: "tags" }
+  field { name: "kind" number: 3 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".test.v1.Kind" json_name: "kind" }
+  field { name: "note" number: 4 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".google.protobuf.StringValue" json_name: "note" }
+  field { name: "ids" number: 5 label: LABEL_REPEATED type: TYPE_INT64 json_name: "ids" }
+  field { name: "id" number: 6 label: LABEL_OPTIONAL type: TYPE_INT64 json_name: "id" oneof_index: 0 }
+  field { name: "name" number: 7 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "name" oneof_index: 0 }
+  nested_type {
+    name: "TagsEntry"
+    field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "key" }
+    field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".test.v1.EchoResponse" json_name: "value" }
+    options { map_entry: true }
+  }
+  oneof_decl { name: "target" }
+}
+message_type {
+  name: "EchoResponse"
+  field { name: "msg" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "msg" }
+}
+enum_type {
+  name: "Kind"
+  value { name: "UNKNOWN" number: 0 }
+  value { name: "FOO" number: 1 }
+}
+service {
+  name: "Echo"
+  method { name: "Echo" input_type: ".test.v1.EchoRequest" output_type: ".test.v1.EchoResponse" }
+  method { name: "Tick" input_type: ".test.v1.EchoRequest" output_type: ".test.v1.EchoResponse" server_streaming: true }
+}
+source_code_info {
+  location { path: [6, 0] span: [0, 0, 0] leading_comments: " 回显服务\n 第二行\n" }
+  location { path: [6, 0, 2, 0] span: [0, 0, 0] leading_comments: " 回显\n" trailing_comments: " sniper:auth=admin\n" }
+  location { path: [6, 0, 2, 1] span: [0, 0, 0] trailing_comments: " sniper:limit=1\n" }
+  location { path: [4, 0] span: [0, 0, 0] leading_comments: " 请求\n" }
+  location { path: [4, 0, 2, 0] span: [0, 0, 0] leading_comments: " 消息内容\n" }
+  location { path: [5, 0, 2, 1] span: [0, 0, 0] trailing_comments: " 类型一\n" }
+}
+`
+
+type echoServer struct {
+	http.Handler
+	descriptor []byte
+}
+
+func (s echoServer) ServiceDescriptor() ([]byte, int) { return s.descriptor, 0 }
+func (s echoServer) ProtocGenTwirpVersion() string    { return "test" }
+func (s echoServer) MethodOptions() map[string]string {
+	return map[string]string{"Echo": "auth=admin,cache=10s", "Tick": "limit=1"}
+}
+
+func newEchoServer(t *testing.T) echoServer {
+	fdp := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal([]byte(echoProto), fdp); err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(fdp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return echoServer{Handler: http.NotFoundHandler(), descriptor: buf.Bytes()}
+}
+
+func TestDescribe(t *testing.T) {
+	svc, err := Describe(newEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.Name != "test.v1.Echo" || svc.PathPrefix != "/test.v1.Echo/" ||
+		svc.File != "test/v1/echo.proto" || svc.Comment != "回显服务\n第二行" {
+		t.Fatalf("%+v", svc)
+	}
+
+	if len(svc.Methods) != 2 {
+		t.Fatal(svc.Methods)
+	}
+	echo, tick := svc.Methods[0], svc.Methods[1]
+	if echo.Path != "/test.v1.Echo/Echo" || echo.Comment != "回显" || echo.ServerStreaming ||
+		echo.Input != "test.v1.EchoRequest" || echo.Options["cache"] != "10s" || echo.Options["auth"] != "admin" {
+		t.Fatalf("%+v", echo)
+	}
+	if !tick.ServerStreaming || tick.Options["limit"] != "1" {
+		t.Fatalf("%+v", tick)
+	}
+
+	req := svc.Messages["test.v1.EchoRequest"]
+	if req == nil || req.Comment != "请求" || len(req.Fields) != 7 {
+		t.Fatalf("%+v", req)
+	}
+	if f := req.Fields[0]; f.Kind != "string" || f.Comment != "消息内容" {
+		t.Fatalf("%+v", f)
+	}
+	if f := req.Fields[1]; f.Kind != "map" || f.Repeated || f.Key.Kind != "string" ||
+		f.Value.Kind != "message" || f.Value.Type != "test.v1.EchoResponse" {
+		t.Fatalf("%+v", f)
+	}
+	if f := req.Fields[2]; f.Kind != "enum" || f.Type != "test.v1.Kind" {
+		t.Fatalf("%+v", f)
+	}
+	if f := req.Fields[4]; f.Kind != "int64" || !f.Repeated {
+		t.Fatalf("%+v", f)
+	}
+	if f := req.Fields[5]; f.Oneof != "target" {
+		t.Fatalf("%+v", f)
+	}
+
+	// 其他文件的消息同样收录，map 的 entry 消息不收录
+	for _, name := range []string{"test.v1.EchoResponse", "google.protobuf.StringValue"} {
+		if svc.Messages[name] == nil {
+			t.Fatal(name)
+		}
+	}
+	if len(svc.Messages) != 3 {
+		t.Fatal(svc.Messages)
+	}
+
+	kind := svc.Enums["test.v1.Kind"]
+	if kind == nil || len(kind.Values) != 2 || kind.Values[1].Name != "FOO" || kind.Values[1].Comment != "类型一" {
+		t.Fatalf("%+v", kind)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h, err := NewHandler(newEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rpc/services", nil))
+	var all struct{ Services []*Service }
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil || len(all.Services) != 1 {
+		t.Fatal(w.Body.String(), err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rpc/services?service=test.v1.Echo", nil))
+	var svc Service
+	if err := json.Unmarshal(w.Body.Bytes(), &svc); err != nil || svc.Name != "test.v1.Echo" {
+		t.Fatal(w.Body.String(), err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rpc/services?service=test.v1.Foo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+
+	if _, err := NewHandler(echoServer{descriptor: []byte("bad")}); err == nil {
+		t.Fatal("should fail")
+	}
+}
@@ -0,0 +1,442 @@
+package twirp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types of server streaming responses.
+//
+// Clients choose the framing with the Accept header. Browsers should use
+// text/event-stream, requests with an application/protobuf body default to
+// length-prefixed protobuf, everything else to newline-delimited JSON.
+const (
+	// ContentTypeNDJSON frames every message as one line of JSON, wrapped as
+	// {"result": message}. A failed stream ends with {"error": twirpError}.
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	// ContentTypeProtobufStream frames every message with a 1 byte flag and a
+	// 4 byte big-endian length. Flag 0 is a message, flag 1 ends the stream
+	// with an empty payload on success or a JSON twirp error on failure.
+	ContentTypeProtobufStream = "application/x-protobuf-stream"
+
+	// ContentTypeEventStream sends Server-Sent Events. Messages are JSON in
+	// the data field of unnamed events, the stream ends with an "end" event
+	// on success or an "error" event carrying a JSON twirp error.
+	ContentTypeEventStream = "text/event-stream"
+)
+
+const (
+	frameMessage byte = 0
+	frameEnd     byte = 1
+
+	// maxFrameSize limits the memory a single frame can allocate on clients.
+	maxFrameSize = 64 << 20
+)
+
+// ServerStream is passed to server streaming methods to send messages to the
+// client. Send is not safe to call from multiple goroutines.
+type ServerStream[T proto.Message] interface {
+	Send(T) error
+}
+
+// StreamWriter implements ServerStream on top of an http.ResponseWriter. It
+// is used by generated servers.
+//
+// Hooks fire once per stream: ResponsePrepared before the first message is
+// written, Error if the method fails and ResponseSent when the stream ends.
+// Errors returned before any message has been sent are written as regular
+// twirp error responses.
+type StreamWriter[T proto.Message] struct {
+	ctx     context.Context
+	resp    http.ResponseWriter
+	hooks   *ServerHooks
+	format  string
+	started bool
+	err     error
+}
+
+// NewStreamWriter prepares a stream for the request, choosing the framing
+// from the request headers.
+func NewStreamWriter[T proto.Message](ctx context.Context, resp http.ResponseWriter, req *http.Request, hooks *ServerHooks) *StreamWriter[T] {
+	return &StreamWriter[T]{
+		ctx:    ctx,
+		resp:   resp,
+		hooks:  hooks,
+		format: streamFormat(req),
+	}
+}
+
+func streamFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, ContentTypeEventStream):
+		return ContentTypeEventStream
+	case strings.Contains(accept, ContentTypeProtobufStream):
+		return ContentTypeProtobufStream
+	case strings.Contains(accept, ContentTypeNDJSON):
+		return ContentTypeNDJSON
+	}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/protobuf") {
+		return ContentTypeProtobufStream
+	}
+	return ContentTypeNDJSON
+}
+
+// Context returns the request context, including values added by hooks.
+func (w *StreamWriter[T]) Context() context.Context {
+	return w.ctx
+}
+
+// Send writes a message and flushes it to the client. An error means the
+// client is gone and the method should stop.
+func (w *StreamWriter[T]) Send(m T) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		w.err = ConvertError(err)
+		return w.err
+	}
+
+	w.start()
+
+	var b []byte
+	var err error
+	if w.format == ContentTypeProtobufStream {
+		b, err = proto.Marshal(m)
+	} else {
+		b, err = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(m)
+	}
+	if err != nil {
+		return InternalErrorWith(wrapErr(err, "failed to marshal stream message"))
+	}
+
+	if err := w.writeFrame(frameMessage, b); err != nil {
+		w.err = NewError(Unknown, "failed to write stream message: "+err.Error())
+		w.hooks.CallError(w.ctx, w.err.(Error))
+		return w.err
+	}
+	return nil
+}
+
+// Finish ends the stream. err is the error returned by the method, if any.
+func (w *StreamWriter[T]) Finish(err error) {
+	if err != nil && !w.started {
+		w.hooks.WriteError(w.ctx, w.resp, err)
+		return
+	}
+
+	w.start()
+
+	if err != nil {
+		twerr := ConvertError(err)
+		w.ctx = WithStatusCode(w.ctx, ServerHTTPStatusFromErrorCode(twerr.Code()))
+		w.ctx = w.hooks.CallError(w.ctx, twerr)
+		if w.err == nil {
+			w.writeFrame(frameEnd, marshalErrorToJSON(twerr))
+		}
+	} else if w.err == nil {
+		w.writeFrame(frameEnd, nil)
+	}
+
+	w.hooks.CallResponseSent(w.ctx)
+}
+
+func (w *StreamWriter[T]) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	w.ctx = w.hooks.CallResponsePrepared(w.ctx)
+	w.ctx = WithStatusCode(w.ctx, http.StatusOK)
+
+	h := w.resp.Header()
+	h.Set("Content-Type", w.format)
+	h.Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.resp.WriteHeader(http.StatusOK)
+	http.NewResponseController(w.resp).Flush()
+}
+
+func (w *StreamWriter[T]) writeFrame(flag byte, b []byte) error {
+	var buf bytes.Buffer
+	switch w.format {
+	case ContentTypeProtobufStream:
+		var head [5]byte
+		head[0] = flag
+		binary.BigEndian.PutUint32(head[1:], uint32(len(b)))
+		buf.Write(head[:])
+		buf.Write(b)
+	case ContentTypeEventStream:
+		switch {
+		case flag == frameMessage:
+			buf.WriteString("data: ")
+			buf.Write(b)
+		case b == nil:
+			buf.WriteString("event: end\ndata: {}")
+		default:
+			buf.WriteString("event: error\ndata: ")
+			buf.Write(b)
+		}
+		buf.WriteString("\n\n")
+	default:
+		switch {
+		case flag == frameMessage:
+			buf.WriteString(`{"result":`)
+			buf.Write(b)
+			buf.WriteString("}\n")
+		case b == nil:
+			// A clean end of the response ends the stream.
+		default:
+			buf.WriteString(`{"error":`)
+			buf.Write(b)
+			buf.WriteString("}\n")
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	if _, err := w.resp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return http.NewResponseController(w.resp).Flush()
+}
+
+// ClientStream reads messages of a server streaming method. It is returned
+// by generated clients.
+//
+//	stream, err := client.Watch(ctx, req)
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		fmt.Println(stream.Msg())
+//	}
+//	return stream.Err()
+//
+// The ResponseReceived hook is called once the stream ends successfully, the
+// Error hook if it fails.
+type ClientStream[T proto.Message] struct {
+	ctx    context.Context
+	hooks  *ClientHooks
+	body   io.ReadCloser
+	r      *bufio.Reader
+	format string
+	newMsg func() T
+	msg    T
+	err    error
+	done   bool
+}
+
+// DoProtobufStreamWithHooks sends a protobuf request to a server streaming
+// method and returns a reader of length-prefixed protobuf messages.
+func DoProtobufStreamWithHooks[T proto.Message](ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, in proto.Message, newMsg func() T) (*ClientStream[T], error) {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return nil, clientError("failed to marshal proto request", err)
+	}
+	return doStream(ctx, client, hooks, url, body, "application/protobuf", ContentTypeProtobufStream, newMsg)
+}
+
+// DoJSONStreamWithHooks sends a JSON request to a server streaming method and
+// returns a reader of newline-delimited JSON messages.
+func DoJSONStreamWithHooks[T proto.Message](ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, in proto.Message, newMsg func() T) (*ClientStream[T], error) {
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, clientError("failed to marshal json request", err)
+	}
+	return doStream(ctx, client, hooks, url, body, "application/json", ContentTypeNDJSON, newMsg)
+}
+
+func doStream[T proto.Message](ctx context.Context, client HTTPClient, hooks *ClientHooks, url string, body []byte, contentType, accept string, newMsg func() T) (*ClientStream[T], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, clientError("aborted because context was done", err)
+	}
+
+	ctx, req, twerr := prepareRequest(ctx, hooks, url, body, contentType, accept)
+	if twerr != nil {
+		return nil, twerr
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		twerr := clientError("failed to do request", err)
+		hooks.callError(ctx, twerr)
+		return nil, twerr
+	}
+	ctx = WithStatusCode(ctx, resp.StatusCode)
+
+	if resp.StatusCode != 200 {
+		twerr := errorFromResponse(resp)
+		resp.Body.Close()
+		hooks.callError(ctx, twerr)
+		return nil, twerr
+	}
+
+	return &ClientStream[T]{
+		ctx:    ctx,
+		hooks:  hooks,
+		body:   resp.Body,
+		r:      bufio.NewReader(resp.Body),
+		format: accept,
+		newMsg: newMsg,
+	}, nil
+}
+
+// Next reads the next message. It returns false when the stream ends or
+// fails, check Err to tell them apart.
+func (s *ClientStream[T]) Next() bool {
+	if s.done {
+		return false
+	}
+
+	var m T
+	var err error
+	if s.format == ContentTypeProtobufStream {
+		m, err = s.readFrame()
+	} else {
+		m, err = s.readLine()
+	}
+
+	if err == io.EOF {
+		s.finish(nil)
+		return false
+	}
+	if err != nil {
+		s.finish(err)
+		return false
+	}
+
+	s.msg = m
+	return true
+}
+
+// Msg returns the message read by the last call to Next.
+func (s *ClientStream[T]) Msg() T {
+	return s.msg
+}
+
+// Err returns the error that ended the stream, if any.
+func (s *ClientStream[T]) Err() error {
+	return s.err
+}
+
+// Close releases the connection. It is safe to call Close before the stream
+// ends, and more than once.
+func (s *ClientStream[T]) Close() error {
+	if !s.done {
+		s.finish(NewError(Canceled, "stream closed by client"))
+	}
+	return nil
+}
+
+func (s *ClientStream[T]) finish(err error) {
+	s.done = true
+	s.body.Close()
+
+	if err == nil {
+		s.hooks.callResponseReceived(s.ctx)
+		return
+	}
+
+	twerr, ok := err.(Error)
+	if !ok {
+		if cerr := s.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		twerr = clientError("failed to read stream", err)
+	}
+	s.err = twerr
+	s.hooks.callError(s.ctx, twerr)
+}
+
+// readFrame returns io.EOF after a successful end frame.
+func (s *ClientStream[T]) readFrame() (m T, err error) {
+	var head [5]byte
+	if _, err = io.ReadFull(s.r, head[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return m, err
+	}
+
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > maxFrameSize {
+		return m, fmt.Errorf("stream frame of %d bytes is too large", size)
+	}
+	b := make([]byte, size)
+	if _, err = io.ReadFull(s.r, b); err != nil {
+		return m, err
+	}
+
+	switch head[0] {
+	case frameMessage:
+		m = s.newMsg()
+		if err = proto.Unmarshal(b, m); err != nil {
+			return m, clientError("failed to unmarshal stream message", err)
+		}
+		return m, nil
+	case frameEnd:
+		if len(b) == 0 {
+			return m, io.EOF
+		}
+		return m, decodeStreamError(b)
+	default:
+		return m, fmt.Errorf("unknown stream frame flag %d", head[0])
+	}
+}
+
+// readLine returns io.EOF at a clean end of the response.
+func (s *ClientStream[T]) readLine() (m T, err error) {
+	line, err := s.r.ReadBytes('\n')
+	if err == io.EOF {
+		if len(bytes.TrimSpace(line)) != 0 {
+			return m, io.ErrUnexpectedEOF
+		}
+		return m, io.EOF
+	}
+	if err != nil {
+		return m, err
+	}
+
+	var frame struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err = json.Unmarshal(line, &frame); err != nil {
+		return m, clientError("failed to unmarshal stream frame", err)
+	}
+	if frame.Error != nil {
+		return m, decodeStreamError(frame.Error)
+	}
+
+	m = s.newMsg()
+	if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(frame.Result, m); err != nil {
+		return m, clientError("failed to unmarshal stream message", err)
+	}
+	return m, nil
+}
+
+func decodeStreamError(b []byte) Error {
+	var tj twerrJSON
+	if err := json.Unmarshal(b, &tj); err != nil {
+		return clientError("failed to unmarshal stream error", err)
+	}
+	return tj.toError()
+}
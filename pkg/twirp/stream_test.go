@@ -0,0 +1,121 @@
+package twirp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newStreamServer(hooks *ServerHooks, n int, fail error) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := NewStreamWriter[*wrapperspb.StringValue](r.Context(), w, r, hooks)
+		var err error
+		for i := 0; i < n; i++ {
+			if err = s.Send(wrapperspb.String(strings.Repeat("a", i+1))); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = fail
+		}
+		s.Finish(err)
+	}))
+}
+
+func newString() *wrapperspb.StringValue { return new(wrapperspb.StringValue) }
+
+func TestStream(t *testing.T) {
+	var calls []string
+	hooks := &ServerHooks{
+		ResponsePrepared: func(ctx context.Context) context.Context {
+			calls = append(calls, "prepared")
+			return ctx
+		},
+		Error: func(ctx context.Context, err Error) context.Context {
+			calls = append(calls, "error")
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			calls = append(calls, "sent")
+		},
+	}
+
+	for _, do := range []func(context.Context, HTTPClient, *ClientHooks, string, *wrapperspb.StringValue) (*ClientStream[*wrapperspb.StringValue], error){
+		func(ctx context.Context, c HTTPClient, h *ClientHooks, url string, in *wrapperspb.StringValue) (*ClientStream[*wrapperspb.StringValue], error) {
+			return DoJSONStreamWithHooks(ctx, c, h, url, in, newString)
+		},
+		func(ctx context.Context, c HTTPClient, h *ClientHooks, url string, in *wrapperspb.StringValue) (*ClientStream[*wrapperspb.StringValue], error) {
+			return DoProtobufStreamWithHooks(ctx, c, h, url, in, newString)
+		},
+	} {
+		calls = nil
+		s := newStreamServer(hooks, 3, nil)
+		st, err := do(context.Background(), http.DefaultClient, nil, s.URL, wrapperspb.String("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for st.Next() {
+			got = append(got, st.Msg().Value)
+		}
+		if st.Err() != nil || strings.Join(got, ",") != "a,aa,aaa" {
+			t.Fatal(got, st.Err())
+		}
+		if strings.Join(calls, ",") != "prepared,sent" {
+			t.Fatal(calls)
+		}
+		s.Close()
+
+		calls = nil
+		s = newStreamServer(hooks, 1, NewError(DataLoss, "boom"))
+		st, _ = do(context.Background(), http.DefaultClient, nil, s.URL, wrapperspb.String("ping"))
+		n := 0
+		for st.Next() {
+			n++
+		}
+		var twerr Error
+		if n != 1 || !errors.As(st.Err(), &twerr) || twerr.Code() != DataLoss || twerr.Msg() != "boom" {
+			t.Fatal(n, st.Err())
+		}
+		if strings.Join(calls, ",") != "prepared,error,sent" {
+			t.Fatal(calls)
+		}
+		s.Close()
+
+		// errors before the first message are regular error responses
+		calls = nil
+		s = newStreamServer(hooks, 0, NewError(NotFound, "nope"))
+		_, err = do(context.Background(), http.DefaultClient, nil, s.URL, wrapperspb.String("ping"))
+		if !errors.As(err, &twerr) || twerr.Code() != NotFound {
+			t.Fatal(err)
+		}
+		if strings.Join(calls, ",") != "error,sent" {
+			t.Fatal(calls)
+		}
+		s.Close()
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypeProtobufStream)
+		w.Write([]byte{frameMessage, 0, 0, 0, 0})
+	}))
+	defer s.Close()
+
+	st, err := DoProtobufStreamWithHooks(context.Background(), http.DefaultClient, nil, s.URL, wrapperspb.String("ping"), newString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Next() || st.Next() {
+		t.Fatal("should read one message")
+	}
+	if st.Err() == nil {
+		t.Fatal("stream without end frame should fail")
+	}
+}
@@ -0,0 +1,49 @@
+package twirp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeoutHeader carries the remaining time budget of a request, so that
+// servers can stop working on requests the client has already given up on.
+//
+// Generated clients set it from the context deadline, using the number of
+// milliseconds followed by "ms", e.g. "1500ms".
+const TimeoutHeader = "Twirp-Timeout"
+
+// FormatTimeout formats a time budget for the TimeoutHeader. Budgets are
+// rounded up to whole milliseconds so that a small positive budget is not
+// sent as zero.
+func FormatTimeout(d time.Duration) string {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	return strconv.FormatInt(int64(ms), 10) + "ms"
+}
+
+// ParseTimeout parses the value of the TimeoutHeader. Any duration accepted by
+// time.ParseDuration is allowed, a plain integer is read as milliseconds.
+func ParseTimeout(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header %q", TimeoutHeader, v)
+	}
+	return d, nil
+}
+
+// remainingBudget returns the time left before the context deadline. The
+// boolean is false if the context has no deadline.
+func remainingBudget(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
@@ -0,0 +1,82 @@
+package twirp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTimeoutFormat(t *testing.T) {
+	if v := FormatTimeout(1500 * time.Millisecond); v != "1500ms" {
+		t.Fatal(v)
+	}
+	if v := FormatTimeout(time.Microsecond); v != "1ms" {
+		t.Fatal(v)
+	}
+
+	for v, want := range map[string]time.Duration{
+		"1500ms": 1500 * time.Millisecond,
+		"2s":     2 * time.Second,
+		"300":    300 * time.Millisecond,
+		"-1ms":   -time.Millisecond,
+	} {
+		d, err := ParseTimeout(v)
+		if err != nil || d != want {
+			t.Fatal(v, d, err)
+		}
+	}
+	if _, err := ParseTimeout("soon"); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestClientTimeoutHeader(t *testing.T) {
+	var header string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(TimeoutHeader)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"pong"`))
+	}))
+	defer s.Close()
+
+	out := &wrapperspb.StringValue{}
+	if err := DoJSONRequest(context.Background(), http.DefaultClient, s.URL, wrapperspb.String("ping"), out); err != nil {
+		t.Fatal(err)
+	}
+	if header != "" {
+		t.Fatal("header should be empty without deadline", header)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := DoJSONRequest(ctx, http.DefaultClient, s.URL, wrapperspb.String("ping"), out); err != nil {
+		t.Fatal(err)
+	}
+	d, err := ParseTimeout(header)
+	if err != nil || d <= 0 || d > time.Second {
+		t.Fatal("invalid budget", header, err)
+	}
+}
+
+func TestClientTimeoutError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := DoJSONRequest(ctx, http.DefaultClient, s.URL, wrapperspb.String("ping"), &wrapperspb.StringValue{})
+	if twerr, ok := err.(Error); !ok || twerr.Code() != DeadlineExceeded {
+		t.Fatal("invalid error", err)
+	}
+
+	err = DoJSONRequest(ctx, http.DefaultClient, s.URL, wrapperspb.String("ping"), &wrapperspb.StringValue{})
+	if twerr, ok := err.(Error); !ok || twerr.Code() != DeadlineExceeded {
+		t.Fatal("invalid error", err)
+	}
+}